@@ -8,10 +8,12 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
 	"os/signal"
-	"regexp"
+	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 
 	"suah.dev/protect"
@@ -25,18 +27,34 @@ import (
 	"github.com/ruvcoindev/ruvchain/src/admin"
 	"github.com/ruvcoindev/ruvchain/src/config"
 	"github.com/ruvcoindev/ruvchain/src/ipv6rwc"
+	"github.com/ruvcoindev/ruvchain/src/metrics"
+	"github.com/ruvcoindev/ruvchain/src/proxy"
+	"github.com/ruvcoindev/ruvchain/src/radv"
 
 	"github.com/ruvcoindev/ruvchain/src/core"
+	"github.com/ruvcoindev/ruvchain/src/dns"
+	"github.com/ruvcoindev/ruvchain/src/firewall"
 	"github.com/ruvcoindev/ruvchain/src/multicast"
 	"github.com/ruvcoindev/ruvchain/src/tun"
 	"github.com/ruvcoindev/ruvchain/src/version"
 )
 
 type node struct {
-	core      *core.Core
-	tun       *tun.TunAdapter
-	multicast *multicast.Multicast
-	admin     *admin.AdminSocket
+	core        *core.Core
+	tun         *tun.TunAdapter
+	extraTuns   []*tun.TunAdapter
+	multicast   *multicast.Multicast
+	admin       *admin.AdminSocket
+	metrics     *metrics.Metrics
+	proxy       *proxy.Proxy
+	dns         *dns.Server
+	firewall    *firewall.Firewall
+	radv        *radv.RouterAdvertiser
+	logger      *log.Logger
+	config      *config.NodeConfig
+	configPath  string
+	listeners   map[string]*core.Listener // by configured listen address
+	reloadMutex sync.Mutex
 }
 
 // The main function is responsible for configuring and starting Ruvchain.
@@ -203,7 +221,12 @@ func main() {
 		return
 	}
 
-	n := &node{}
+	n := &node{
+		logger:     logger,
+		config:     cfg,
+		configPath: *useconffile,
+		listeners:  map[string]*core.Listener{},
+	}
 
 	// Set up the Ruvchain node itself.
 	{
@@ -218,9 +241,6 @@ func main() {
 				return !iprange.Contains(ip)
 			}),
 		}
-		for _, addr := range cfg.Listen {
-			options = append(options, core.ListenAddress(addr))
-		}
 		for _, peer := range cfg.Peers {
 			options = append(options, core.Peer{URI: peer})
 		}
@@ -236,9 +256,17 @@ func main() {
 			}
 			options = append(options, core.AllowedPublicKey(k[:]))
 		}
+		if path := cfg.PeerStateFile; path != "" {
+			options = append(options, core.PeerStateFile(n.resolvePath(path)))
+		}
 		if n.core, err = core.New(cfg.Certificate, logger, options...); err != nil {
 			panic(err)
 		}
+		for _, addr := range cfg.Listen {
+			if err := n.listen(addr); err != nil {
+				logger.Errorln(err)
+			}
+		}
 		address, subnet := n.core.Address(), n.core.Subnet()
 		logger.Printf("Your public key is %s", hex.EncodeToString(n.core.PublicKey()))
 		logger.Printf("Your IPv6 address is %s", address.String())
@@ -253,26 +281,51 @@ func main() {
 		if cfg.LogLookups {
 			options = append(options, admin.LogLookups{})
 		}
+		if cfg.AdminTLSCertFile != "" {
+			options = append(options, admin.TLSCertificate(n.resolvePath(cfg.AdminTLSCertFile)))
+		}
+		if cfg.AdminTLSKeyFile != "" {
+			options = append(options, admin.TLSKey(n.resolvePath(cfg.AdminTLSKeyFile)))
+		}
+		if cfg.AdminTLSClientCA != "" {
+			options = append(options, admin.TLSClientCA(n.resolvePath(cfg.AdminTLSClientCA)))
+		}
+		for _, token := range cfg.AdminTokens {
+			options = append(options, admin.AccessToken{Token: token.Token, ReadOnly: token.ReadOnly})
+		}
+		for _, user := range cfg.AdminUsers {
+			options = append(options, admin.AccessUser{User: user.User, ReadOnly: user.ReadOnly})
+		}
+		for _, operator := range cfg.AdminOperators {
+			options = append(options, admin.AccessOperator{PublicKey: operator.PublicKey, ReadOnly: operator.ReadOnly})
+		}
 		if n.admin, err = admin.New(n.core, logger, options...); err != nil {
 			panic(err)
 		}
 		if n.admin != nil {
 			n.admin.SetupAdminHandlers()
+			_ = n.admin.AddHandler(
+				"reloadConfig", "Reload the configuration file and apply any changes", []string{},
+				func(in json.RawMessage) (interface{}, error) {
+					req := &admin.ReloadConfigRequest{}
+					if err := json.Unmarshal(in, &req); err != nil {
+						return nil, err
+					}
+					return n.reloadConfig()
+				},
+			)
 		}
 	}
 
 	// Set up the multicast module.
 	{
+		intfs, err := multicastOptions(cfg.MulticastInterfaces)
+		if err != nil {
+			panic(err)
+		}
 		options := []multicast.SetupOption{}
-		for _, intf := range cfg.MulticastInterfaces {
-			options = append(options, multicast.MulticastInterface{
-				Regex:    regexp.MustCompile(intf.Regex),
-				Beacon:   intf.Beacon,
-				Listen:   intf.Listen,
-				Port:     intf.Port,
-				Priority: uint8(intf.Priority),
-				Password: intf.Password,
-			})
+		for _, intf := range intfs {
+			options = append(options, intf)
 		}
 		if n.multicast, err = multicast.New(n.core, logger, options...); err != nil {
 			panic(err)
@@ -282,18 +335,164 @@ func main() {
 		}
 	}
 
+	// Set up the routes to and from subnets outside of the network.
+	rwc := ipv6rwc.NewReadWriteCloser(n.core)
+	{
+		for _, s := range cfg.LocalSubnets {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				panic(fmt.Errorf("invalid local subnet %q: %w", s, err))
+			}
+			if err := rwc.AddLocalRoute(prefix); err != nil {
+				panic(err)
+			}
+		}
+		for s, k := range cfg.RemoteSubnets {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				panic(fmt.Errorf("invalid remote subnet %q: %w", s, err))
+			}
+			key, err := hex.DecodeString(k)
+			if err != nil {
+				panic(fmt.Errorf("invalid key for remote subnet %q: %w", s, err))
+			}
+			if err := rwc.AddRemoteRoute(prefix, key); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	// Set up the further TUN interfaces, which must be added before the
+	// ReadWriteCloser is used by the TUN module.
+	var intfs []*ipv6rwc.Interface
+	for _, c := range cfg.ExtraInterfaces {
+		selector := ipv6rwc.Selector{}
+		for _, d := range c.Destinations {
+			selector.Destinations = append(selector.Destinations, ipv6rwc.Destination(d))
+		}
+		for _, k := range c.PublicKeys {
+			key, err := hex.DecodeString(k)
+			if err != nil {
+				panic(fmt.Errorf("invalid key for interface %q: %w", c.Name, err))
+			}
+			selector.Keys = append(selector.Keys, key)
+		}
+		intf, err := rwc.AddInterface(selector)
+		if err != nil {
+			panic(fmt.Errorf("interface %q: %w", c.Name, err))
+		}
+		intfs = append(intfs, intf)
+	}
+
+	// Set up the firewall. This comes before the TUN module so that no
+	// packets pass unfiltered.
+	{
+		options := []firewall.SetupOption{
+			firewall.InboundPolicy(cfg.FirewallInbound),
+			firewall.OutboundPolicy(cfg.FirewallOutbound),
+		}
+		for _, r := range cfg.FirewallRules {
+			options = append(options, firewall.Rule{
+				Action:      r.Action,
+				Direction:   r.Direction,
+				PublicKey:   r.PublicKey,
+				Source:      r.Source,
+				Destination: r.Destination,
+				Protocol:    r.Protocol,
+				Ports:       r.Ports,
+			})
+		}
+		if n.firewall, err = firewall.New(rwc, logger, options...); err != nil {
+			panic(err)
+		}
+		if n.admin != nil && n.firewall != nil {
+			n.firewall.SetupAdminHandlers(n.admin)
+		}
+	}
+
 	// Set up the TUN module.
 	{
 		options := []tun.SetupOption{
 			tun.InterfaceName(cfg.IfName),
 			tun.InterfaceMTU(cfg.IfMTU),
+			tun.InterfaceQueues(cfg.IfQueues),
 		}
-		if n.tun, err = tun.New(ipv6rwc.NewReadWriteCloser(n.core), logger, options...); err != nil {
+		if n.tun, err = tun.New(rwc, logger, options...); err != nil {
 			panic(err)
 		}
 		if n.admin != nil && n.tun != nil {
 			n.tun.SetupAdminHandlers(n.admin)
 		}
+		// The routes of the further interfaces replace any of the same
+		// prefixes on the main one.
+		for i, c := range cfg.ExtraInterfaces {
+			mtu := c.MTU
+			if mtu == 0 {
+				mtu = cfg.IfMTU
+			}
+			options := []tun.SetupOption{
+				tun.InterfaceName(c.Name),
+				tun.InterfaceMTU(mtu),
+				tun.InterfaceQueues(cfg.IfQueues),
+			}
+			t, err := tun.New(intfs[i], logger, options...)
+			if err != nil {
+				panic(err)
+			}
+			n.extraTuns = append(n.extraTuns, t)
+		}
+	}
+
+	// Set up the router advertisements for the subnet on a LAN interface.
+	{
+		options := []radv.SetupOption{
+			radv.InterfaceName(cfg.SubnetInterface),
+			radv.DefaultRouter(cfg.SubnetDefaultRouter),
+		}
+		if n.radv, err = radv.New(n.core, n.tun, logger, options...); err != nil {
+			panic(err)
+		}
+	}
+
+	// Set up the metrics listener.
+	{
+		options := []metrics.SetupOption{
+			metrics.ListenAddress(cfg.MetricsListen),
+		}
+		if n.metrics, err = metrics.New(n.core, n.tun, logger, options...); err != nil {
+			panic(err)
+		}
+	}
+
+	// Set up the SOCKS5 and HTTP CONNECT proxies and port forwards.
+	{
+		options := []proxy.SetupOption{
+			proxy.SOCKSListenAddress(cfg.SOCKSListen),
+			proxy.HTTPListenAddress(cfg.HTTPProxyListen),
+		}
+		for _, f := range cfg.Forwards {
+			options = append(options, proxy.Forward{Type: f.Type, Protocol: f.Protocol, Listen: f.Listen, Target: f.Target})
+		}
+		if n.proxy, err = proxy.New(n.tun, logger, options...); err != nil {
+			panic(err)
+		}
+		if n.admin != nil && n.proxy != nil {
+			n.proxy.SetupAdminHandlers(n.admin)
+		}
+	}
+
+	// Set up the DNS server. This comes after the TUN module so that it can
+	// listen on the TUN address.
+	{
+		options := []dns.SetupOption{
+			dns.ListenAddress(cfg.DNSListen),
+		}
+		for name, addr := range cfg.DNSHosts {
+			options = append(options, dns.Host{Name: name, Address: addr})
+		}
+		if n.dns, err = dns.New(n.core, logger, options...); err != nil {
+			panic(err)
+		}
 	}
 
 	//Windows service shutdown
@@ -323,20 +522,72 @@ func main() {
 	if len(cfg.MulticastInterfaces) > 0 {
 		promises = append(promises, "mcast")
 	}
+	if n.configPath != "" || cfg.PeerStateFile != "" {
+		// Needed to re-read the configuration file on reload, and to read
+		// the peer state file.
+		promises = append(promises, "rpath")
+	}
+	if cfg.PeerStateFile != "" {
+		// Needed to atomically replace the peer state file.
+		promises = append(promises, "wpath", "fattr")
+	}
 	if err := protect.Pledge(strings.Join(promises, " ")); err != nil {
 		panic(fmt.Sprintf("pledge: %v: %v", promises, err))
 	}
 
+	// Reload the configuration file when we receive a SIGHUP.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				logger.Infoln("Reloading configuration from", n.configPath)
+				res, err := n.reloadConfig()
+				if err != nil {
+					logger.Errorln("Failed to reload configuration:", err)
+				}
+				if res == nil {
+					continue
+				}
+				if len(res.Applied) > 0 {
+					logger.Infoln("Applied configuration changes:", strings.Join(res.Applied, ", "))
+				}
+				if len(res.RestartRequired) > 0 {
+					logger.Warnln("Configuration changes that require a restart were not applied:", strings.Join(res.RestartRequired, ", "))
+				}
+			}
+		}
+	}()
+
 	// Block until we are told to shut down.
 	<-ctx.Done()
 
 	// Shut down the node.
 	_ = n.admin.Stop()
+	_ = n.metrics.Stop()
+	_ = n.proxy.Stop()
+	_ = n.dns.Stop()
 	_ = n.multicast.Stop()
+	_ = n.radv.Stop()
+	for _, t := range n.extraTuns {
+		_ = t.Stop()
+	}
 	_ = n.tun.Stop()
 	n.core.Stop()
 }
 
+// resolvePath resolves a relative path from the configuration against the
+// directory of the configuration file.
+func (n *node) resolvePath(path string) string {
+	if !filepath.IsAbs(path) && n.configPath != "" {
+		path = filepath.Join(filepath.Dir(n.configPath), path)
+	}
+	return path
+}
+
 func setLogLevel(loglevel string, logger *log.Logger) {
 	levels := [...]string{"error", "warn", "info", "debug", "trace"}
 	loglevel = strings.ToLower(loglevel)
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+
+	"github.com/ruvcoindev/ruvchain/src/admin"
+	"github.com/ruvcoindev/ruvchain/src/config"
+	"github.com/ruvcoindev/ruvchain/src/core"
+	"github.com/ruvcoindev/ruvchain/src/multicast"
+)
+
+// reloadableFields lists the NodeConfig fields that can be applied to a
+// running node. Changes to any other field are refused and reported back
+// to the user, as they can only take effect after a restart.
+var reloadableFields = map[string]struct{}{
+	"Peers":               {},
+	"InterfacePeers":      {},
+	"Listen":              {},
+	"AllowedPublicKeys":   {},
+	"MulticastInterfaces": {},
+	"NodeInfo":            {},
+	"NodeInfoPrivacy":     {},
+}
+
+// restartRequired returns the names of the fields that differ between the
+// two configurations but that cannot be changed at runtime.
+func restartRequired(current, next *config.NodeConfig) []string {
+	var fields []string
+	cv, nv := reflect.ValueOf(current).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < cv.NumField(); i++ {
+		name := cv.Type().Field(i).Name
+		if _, ok := reloadableFields[name]; ok {
+			continue
+		}
+		if name == "Certificate" {
+			// Derived from the private key, which is compared anyway.
+			continue
+		}
+		if !reflect.DeepEqual(cv.Field(i).Interface(), nv.Field(i).Interface()) {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+type peerConfig struct {
+	uri   string
+	sintf string
+}
+
+func configuredPeers(cfg *config.NodeConfig) map[peerConfig]struct{} {
+	peers := make(map[peerConfig]struct{})
+	for _, peer := range cfg.Peers {
+		peers[peerConfig{uri: peer}] = struct{}{}
+	}
+	for intf, intfpeers := range cfg.InterfacePeers {
+		for _, peer := range intfpeers {
+			peers[peerConfig{uri: peer, sintf: intf}] = struct{}{}
+		}
+	}
+	return peers
+}
+
+// reloadConfig re-reads the configuration file and applies any changes to
+// the running node. Changes that need a restart are not applied.
+func (n *node) reloadConfig() (*admin.ReloadConfigResponse, error) {
+	n.reloadMutex.Lock()
+	defer n.reloadMutex.Unlock()
+
+	if n.configPath == "" {
+		return nil, fmt.Errorf("configuration was not loaded from a file, use -useconffile to enable reloading")
+	}
+	f, err := os.Open(n.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open configuration: %w", err)
+	}
+	defer f.Close()
+	cfg := config.GenerateConfig()
+	if _, err := cfg.ReadFrom(f); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+
+	res := &admin.ReloadConfigResponse{
+		Applied:         []string{},
+		RestartRequired: restartRequired(n.config, cfg),
+	}
+	var errs []error
+	apply := func(field string, fn func() error) {
+		if err := fn(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+			return
+		}
+		res.Applied = append(res.Applied, field)
+	}
+
+	current, next := configuredPeers(n.config), configuredPeers(cfg)
+	if !reflect.DeepEqual(current, next) {
+		apply("Peers", func() error {
+			applied, err := n.applyPeers(current, next)
+			if err != nil {
+				// Record the peers that the node has now, so that the next
+				// reload compares against them and not the old ones.
+				n.config.Peers, n.config.InterfacePeers = peerLists(applied, cfg, n.config)
+				return err
+			}
+			n.config.Peers, n.config.InterfacePeers = cfg.Peers, cfg.InterfacePeers
+			return nil
+		})
+	}
+
+	listeners := make([]string, 0, len(n.listeners))
+	for addr, listener := range n.listeners {
+		select {
+		case <-listener.Done():
+			// Stopped through the admin socket, start it again.
+			delete(n.listeners, addr)
+			continue
+		default:
+		}
+		listeners = append(listeners, addr)
+	}
+	slices.Sort(listeners)
+	if !reflect.DeepEqual(listeners, sortedCopy(cfg.Listen)) {
+		apply("Listen", func() error {
+			if err := n.applyListeners(cfg.Listen); err != nil {
+				return err
+			}
+			n.config.Listen = cfg.Listen
+			return nil
+		})
+	}
+
+	if !reflect.DeepEqual(sortedCopy(n.config.AllowedPublicKeys), sortedCopy(cfg.AllowedPublicKeys)) {
+		apply("AllowedPublicKeys", func() error {
+			keys := make([]ed25519.PublicKey, 0, len(cfg.AllowedPublicKeys))
+			for _, allowed := range cfg.AllowedPublicKeys {
+				k, err := hex.DecodeString(allowed)
+				if err != nil || len(k) != ed25519.PublicKeySize {
+					return fmt.Errorf("invalid public key %q", allowed)
+				}
+				keys = append(keys, k)
+			}
+			n.core.SetAllowedPublicKeys(keys)
+			n.config.AllowedPublicKeys = cfg.AllowedPublicKeys
+			return nil
+		})
+	}
+
+	if !reflect.DeepEqual(n.config.MulticastInterfaces, cfg.MulticastInterfaces) {
+		apply("MulticastInterfaces", func() error {
+			if n.multicast == nil {
+				return fmt.Errorf("multicast module is not running")
+			}
+			options, err := multicastOptions(cfg.MulticastInterfaces)
+			if err != nil {
+				return err
+			}
+			if err := n.multicast.SetInterfaces(options...); err != nil {
+				return err
+			}
+			n.config.MulticastInterfaces = cfg.MulticastInterfaces
+			return nil
+		})
+	}
+
+	if !reflect.DeepEqual(n.config.NodeInfo, cfg.NodeInfo) || n.config.NodeInfoPrivacy != cfg.NodeInfoPrivacy {
+		apply("NodeInfo", func() error {
+			if err := n.core.SetNodeInfo(cfg.NodeInfo, core.NodeInfoPrivacy(cfg.NodeInfoPrivacy)); err != nil {
+				return err
+			}
+			n.config.NodeInfo, n.config.NodeInfoPrivacy = cfg.NodeInfo, cfg.NodeInfoPrivacy
+			return nil
+		})
+	}
+
+	return res, errors.Join(errs...)
+}
+
+// applyPeers adds and removes peers to get from the current ones to the next
+// ones, and returns the peers that the node has afterwards, which are the
+// next ones unless there are errors.
+func (n *node) applyPeers(current, next map[peerConfig]struct{}) (map[peerConfig]struct{}, error) {
+	applied := maps.Clone(current)
+	var errs []error
+	for peer := range current {
+		if _, ok := next[peer]; ok {
+			continue
+		}
+		u, err := url.Parse(peer.uri)
+		if err != nil {
+			delete(applied, peer) // Can't have been added either
+			continue
+		}
+		if err := n.core.RemovePeer(u, peer.sintf); err != nil && !errors.Is(err, core.ErrLinkNotConfigured) {
+			errs = append(errs, fmt.Errorf("failed to remove peer %q: %w", u.Redacted(), err))
+			continue
+		}
+		delete(applied, peer)
+		n.logger.Infof("Removed peer %s", u.Redacted())
+	}
+	for peer := range next {
+		if _, ok := current[peer]; ok {
+			continue
+		}
+		u, err := url.Parse(peer.uri)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to parse peering URI %q: %w", peer.uri, err))
+			continue
+		}
+		if err := n.core.AddConfiguredPeer(u, peer.sintf); err != nil && !errors.Is(err, core.ErrLinkAlreadyConfigured) {
+			errs = append(errs, fmt.Errorf("failed to add peer %q: %w", u.Redacted(), err))
+			continue
+		}
+		applied[peer] = struct{}{}
+		n.logger.Infof("Added peer %s", u.Redacted())
+	}
+	return applied, errors.Join(errs...)
+}
+
+// peerLists returns the Peers and InterfacePeers that configure the given
+// peers, in the order that they appear in the given configurations.
+func peerLists(peers map[peerConfig]struct{}, cfgs ...*config.NodeConfig) ([]string, map[string][]string) {
+	list, intfs := []string{}, map[string][]string{}
+	seen := make(map[peerConfig]struct{}, len(peers))
+	add := func(peer peerConfig) {
+		if _, ok := peers[peer]; !ok {
+			return
+		}
+		if _, ok := seen[peer]; ok {
+			return
+		}
+		seen[peer] = struct{}{}
+		if peer.sintf == "" {
+			list = append(list, peer.uri)
+		} else {
+			intfs[peer.sintf] = append(intfs[peer.sintf], peer.uri)
+		}
+	}
+	for _, cfg := range cfgs {
+		for _, uri := range cfg.Peers {
+			add(peerConfig{uri: uri})
+		}
+		for intf, uris := range cfg.InterfacePeers {
+			for _, uri := range uris {
+				add(peerConfig{uri: uri, sintf: intf})
+			}
+		}
+	}
+	return list, intfs
+}
+
+func (n *node) applyListeners(addrs []string) error {
+	var errs []error
+	for addr, listener := range n.listeners {
+		if slices.Contains(addrs, addr) {
+			continue
+		}
+		listener.Cancel()
+		delete(n.listeners, addr)
+	}
+	for _, addr := range addrs {
+		if _, ok := n.listeners[addr]; ok {
+			continue
+		}
+		if err := n.listen(addr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// listen starts a listener for the given configured listen address and
+// keeps track of it, so that it can be stopped again on reload.
+func (n *node) listen(addr string) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listener URI %q: %w", addr, err)
+	}
+	listener, err := n.core.Listen(u, "")
+	if err != nil {
+		return fmt.Errorf("failed to start listener %q: %w", addr, err)
+	}
+	n.listeners[addr] = listener
+	return nil
+}
+
+func multicastOptions(intfs []config.MulticastInterfaceConfig) ([]multicast.MulticastInterface, error) {
+	options := make([]multicast.MulticastInterface, 0, len(intfs))
+	for _, intf := range intfs {
+		regex, err := regexp.Compile(intf.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface regex %q: %w", intf.Regex, err)
+		}
+		options = append(options, multicast.MulticastInterface{
+			Regex:    regex,
+			Beacon:   intf.Beacon,
+			Listen:   intf.Listen,
+			Port:     intf.Port,
+			Priority: uint8(intf.Priority),
+			Password: intf.Password,
+		})
+	}
+	return options, nil
+}
+
+func sortedCopy(s []string) []string {
+	s = slices.Clone(s)
+	slices.Sort(s)
+	if s == nil {
+		s = []string{}
+	}
+	return s
+}
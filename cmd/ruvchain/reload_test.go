@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gologme/log"
+	"github.com/ruvcoindev/ruvchain/src/config"
+	"github.com/ruvcoindev/ruvchain/src/core"
+)
+
+// Changes to reloadable fields must not be reported as needing a restart.
+func TestRestartRequiredReloadable(t *testing.T) {
+	current := config.GenerateConfig()
+	next := *current
+	next.Peers = []string{"tls://1.2.3.4:5678"}
+	next.Listen = []string{"tls://[::]:0"}
+	next.AllowedPublicKeys = []string{"abcd"}
+	next.NodeInfo = map[string]interface{}{"name": "test"}
+	next.NodeInfoPrivacy = true
+	if fields := restartRequired(current, &next); len(fields) != 0 {
+		t.Fatalf("unexpected restart required for %v", fields)
+	}
+}
+
+// Changes to the private key or TUN settings can't be applied at runtime.
+func TestRestartRequiredPrivateKey(t *testing.T) {
+	current := config.GenerateConfig()
+	next := config.GenerateConfig()
+	next.IfName = "ruv0"
+	next.IfMTU = current.IfMTU
+	next.MulticastInterfaces = current.MulticastInterfaces
+	next.AdminListen = current.AdminListen
+	fields := restartRequired(current, next)
+	if !reflect.DeepEqual(fields, []string{"PrivateKey", "IfName"}) {
+		t.Fatalf("unexpected restart required for %v", fields)
+	}
+}
+
+// Peers are identified by both the URI and the source interface.
+func TestConfiguredPeers(t *testing.T) {
+	cfg := config.GenerateConfig()
+	cfg.Peers = []string{"tls://1.2.3.4:5678"}
+	cfg.InterfacePeers = map[string][]string{
+		"eth0": {"tls://1.2.3.4:5678"},
+	}
+	peers := configuredPeers(cfg)
+	if len(peers) != 2 {
+		t.Fatalf("expected 2 peers, got %d", len(peers))
+	}
+	if _, ok := peers[peerConfig{uri: "tls://1.2.3.4:5678", sintf: "eth0"}]; !ok {
+		t.Fatal("interface peer missing")
+	}
+}
+
+// Changes that fail to apply are not recorded as applied, so that the next
+// reload tries them again.
+func TestReloadConfigFailure(t *testing.T) {
+	cfg := config.GenerateConfig()
+	cfg.AdminListen = "none"
+	cfg.MulticastInterfaces = nil
+	require := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	require(cfg.GenerateSelfSignedCertificate())
+	c, err := core.New(cfg.Certificate, log.New(os.Stderr, "", 0))
+	require(err)
+	defer c.Stop()
+	n := &node{
+		core:       c,
+		logger:     log.New(os.Stderr, "", 0),
+		config:     cfg,
+		configPath: filepath.Join(t.TempDir(), "ruvchain.conf"),
+		listeners:  make(map[string]*core.Listener),
+	}
+
+	next := *cfg
+	next.Peers = []string{"unknown://1.2.3.4:5678"}
+	next.Listen = []string{"unknown://[::]:0"}
+	b, err := json.Marshal(&next)
+	require(err)
+	require(os.WriteFile(n.configPath, b, 0600))
+	res, err := n.reloadConfig()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(res.Applied) != 0 {
+		t.Fatalf("unexpected applied fields %v", res.Applied)
+	}
+	if len(n.config.Peers) != 0 || len(n.config.Listen) != 0 {
+		t.Fatalf("failed changes were recorded: %v %v", n.config.Peers, n.config.Listen)
+	}
+}
+
+// Peers that were added by a reload that failed partway are recorded, so
+// that removing them from the file later removes them from the node.
+func TestReloadConfigPartialPeers(t *testing.T) {
+	cfg := config.GenerateConfig()
+	cfg.AdminListen = "none"
+	cfg.MulticastInterfaces = nil
+	require := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	require(cfg.GenerateSelfSignedCertificate())
+	c, err := core.New(cfg.Certificate, log.New(os.Stderr, "", 0))
+	require(err)
+	defer c.Stop()
+	n := &node{
+		core:       c,
+		logger:     log.New(os.Stderr, "", 0),
+		config:     cfg,
+		configPath: filepath.Join(t.TempDir(), "ruvchain.conf"),
+		listeners:  make(map[string]*core.Listener),
+	}
+	write := func(cfg *config.NodeConfig) {
+		t.Helper()
+		b, err := json.Marshal(cfg)
+		require(err)
+		require(os.WriteFile(n.configPath, b, 0600))
+	}
+
+	next := *cfg
+	next.Peers = []string{"tcp://127.0.0.1:1", "unknown://1.2.3.4:5678"}
+	write(&next)
+	if _, err := n.reloadConfig(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if !reflect.DeepEqual(n.config.Peers, []string{"tcp://127.0.0.1:1"}) {
+		t.Fatalf("unexpected recorded peers %v", n.config.Peers)
+	}
+
+	next.Peers = []string{}
+	write(&next)
+	res, err := n.reloadConfig()
+	require(err)
+	if !reflect.DeepEqual(res.Applied, []string{"Peers"}) {
+		t.Fatalf("unexpected applied fields %v", res.Applied)
+	}
+	u, _ := url.Parse("tcp://127.0.0.1:1")
+	if err := c.RemovePeer(u, ""); !errors.Is(err, core.ErrLinkNotConfigured) {
+		t.Fatalf("expected the peer to have been removed, got %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"log"
@@ -16,6 +18,10 @@ import (
 type CmdLineEnv struct {
 	args             []string
 	endpoint, server string
+	token            string
+	remote           string
+	caCert           string
+	cert, key        string
 	injson, ver      bool
 }
 
@@ -40,11 +46,18 @@ func (cmdLineEnv *CmdLineEnv) parseFlagsAndArgs() {
 		fmt.Println("  - ", os.Args[0], "getPeers")
 		fmt.Println("  - ", os.Args[0], "-endpoint=tcp://localhost:5001 getPeers")
 		fmt.Println("  - ", os.Args[0], "-endpoint=unix:///var/run/ruv.sock getPeers")
+		fmt.Println("  - ", os.Args[0], "-endpoint=tls://node.example.com:5002 -cacert=ca.pem -cert=client.pem -key=client.key getPeers")
+		fmt.Println("  - ", os.Args[0], "-remote=<public key> getPeers")
 	}
 
 	server := flag.String("endpoint", cmdLineEnv.endpoint, "Admin socket endpoint")
 	injson := flag.Bool("json", false, "Output in JSON format (as opposed to pretty-print)")
 	ver := flag.Bool("version", false, "Prints the version of this build")
+	caCert := flag.String("cacert", "", "CA certificate to verify a tls:// endpoint with, instead of the system roots")
+	cert := flag.String("cert", "", "Client certificate to present to a tls:// endpoint")
+	key := flag.String("key", "", "Key for the client certificate")
+	token := flag.String("token", "", "Admin socket access token, defaults to the RUVCHAIN_ADMIN_TOKEN environment variable")
+	remote := flag.String("remote", "", "Public key of a remote node to send the request to, signed by the local node")
 
 	flag.Parse()
 
@@ -52,6 +65,14 @@ func (cmdLineEnv *CmdLineEnv) parseFlagsAndArgs() {
 	cmdLineEnv.server = *server
 	cmdLineEnv.injson = *injson
 	cmdLineEnv.ver = *ver
+	cmdLineEnv.token = *token
+	cmdLineEnv.remote = *remote
+	cmdLineEnv.caCert = *caCert
+	cmdLineEnv.cert = *cert
+	cmdLineEnv.key = *key
+	if cmdLineEnv.token == "" {
+		cmdLineEnv.token = os.Getenv("RUVCHAIN_ADMIN_TOKEN")
+	}
 }
 
 func (cmdLineEnv *CmdLineEnv) setEndpoint(logger *log.Logger) {
@@ -87,3 +108,29 @@ func (cmdLineEnv *CmdLineEnv) setEndpoint(logger *log.Logger) {
 		logger.Println("Using endpoint", cmdLineEnv.endpoint, "from command line")
 	}
 }
+
+// tlsConfig builds the TLS configuration for connecting to a tls:// endpoint.
+func (cmdLineEnv *CmdLineEnv) tlsConfig(host string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cmdLineEnv.caCert != "" {
+		pem, err := os.ReadFile(cmdLineEnv.caCert)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cmdLineEnv.caCert)
+		}
+	}
+	if cmdLineEnv.cert != "" || cmdLineEnv.key != "" {
+		cert, err := tls.LoadX509KeyPair(cmdLineEnv.cert, cmdLineEnv.key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
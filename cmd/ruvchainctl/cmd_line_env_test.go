@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+	"github.com/ruvcoindev/ruvchain/src/admin"
+	"github.com/ruvcoindev/ruvchain/src/config"
+	"github.com/ruvcoindev/ruvchain/src/core"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate signed by the given CA, or a self-signed
+// CA if there isn't one, and writes it and its key as PEM files to the
+// directory.
+func newTestCert(t *testing.T, dir, name string, ca *testCert) (*testCert, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv6loopback, net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	parent, signer := template, key
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(dir, name+".pem")
+	keyPath := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert, key}, certPath, keyPath
+}
+
+// A tls:// admin socket with a client CA only serves clients that present a
+// certificate signed by that CA.
+func TestTLSAdminSocket(t *testing.T) {
+	dir := t.TempDir()
+	ca, caPath, _ := newTestCert(t, dir, "ca", nil)
+	_, serverCert, serverKey := newTestCert(t, dir, "server", ca)
+	_, clientCert, clientKey := newTestCert(t, dir, "client", ca)
+	otherCA, _, _ := newTestCert(t, dir, "otherca", nil)
+	_, otherCert, otherKey := newTestCert(t, dir, "other", otherCA)
+
+	logger := log.New(io.Discard, "", log.Flags())
+	cfg := config.GenerateConfig()
+	c, err := core.New(cfg.Certificate, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	// Find a free port for the admin socket to listen on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	a, err := admin.New(c, logger,
+		admin.ListenAddress("tls://"+addr),
+		admin.TLSCertificate(serverCert),
+		admin.TLSKey(serverKey),
+		admin.TLSClientCA(caPath),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop() // nolint:errcheck
+
+	request := func(env CmdLineEnv) error {
+		config, err := env.tlsConfig("127.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := tls.Dial("tcp", addr, config)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		// With TLS 1.3 the server only checks the client certificate after
+		// the client considers the handshake complete, so a refusal shows up
+		// when reading the response.
+		if err := json.NewEncoder(conn).Encode(&admin.AdminSocketRequest{Name: "list"}); err != nil {
+			return err
+		}
+		var resp admin.AdminSocketResponse
+		if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+			return err
+		}
+		if resp.Status != "success" {
+			t.Fatalf("list failed: %s", resp.Error)
+		}
+		return nil
+	}
+
+	if err := request(CmdLineEnv{caCert: caPath}); err == nil {
+		t.Fatal("expected client without a certificate to be refused")
+	}
+	if err := request(CmdLineEnv{caCert: caPath, cert: otherCert, key: otherKey}); err == nil {
+		t.Fatal("expected client with a certificate from another CA to be refused")
+	}
+	if err := request(CmdLineEnv{caCert: caPath, cert: clientCert, key: clientKey}); err != nil {
+		t.Fatalf("expected client with a valid certificate to be served: %v", err)
+	}
+	// The client must also verify the server against the given CA.
+	if err := request(CmdLineEnv{cert: clientCert, key: clientKey}); err == nil {
+		t.Fatal("expected server to be untrusted without the CA")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -18,7 +19,9 @@ import (
 	"github.com/olekukonko/tablewriter"
 	"github.com/ruvcoindev/ruvchain/src/admin"
 	"github.com/ruvcoindev/ruvchain/src/core"
+	"github.com/ruvcoindev/ruvchain/src/firewall"
 	"github.com/ruvcoindev/ruvchain/src/multicast"
+	"github.com/ruvcoindev/ruvchain/src/proxy"
 	"github.com/ruvcoindev/ruvchain/src/tun"
 	"github.com/ruvcoindev/ruvchain/src/version"
 )
@@ -73,6 +76,12 @@ func run() int {
 		case "tcp":
 			logger.Println("Connecting to TCP socket", u.Host)
 			conn, err = net.Dial("tcp", u.Host)
+		case "tls":
+			logger.Println("Connecting to TLS socket", u.Host)
+			var config *tls.Config
+			if config, err = cmdLineEnv.tlsConfig(u.Hostname()); err == nil {
+				conn, err = tls.Dial("tcp", u.Host, config)
+			}
 		default:
 			logger.Println("Unknown protocol or malformed address - check your endpoint")
 			err = errors.New("protocol not supported")
@@ -95,7 +104,7 @@ func run() int {
 
 	decoder := json.NewDecoder(conn)
 	encoder := json.NewEncoder(conn)
-	send := &admin.AdminSocketRequest{}
+	send := &admin.AdminSocketRequest{Token: cmdLineEnv.token, Remote: cmdLineEnv.remote}
 	recv := &admin.AdminSocketResponse{}
 	args := map[string]string{}
 	for c, a := range cmdLineEnv.args {
@@ -138,6 +147,9 @@ func run() int {
 		if json, err := json.MarshalIndent(recv.Response, "", "  "); err == nil {
 			fmt.Println(string(json))
 		}
+		if strings.EqualFold(send.Name, "subscribe") {
+			return printEvents(decoder, true)
+		}
 		return 0
 	}
 
@@ -266,14 +278,19 @@ func run() int {
 		if err := json.Unmarshal(recv.Response, &resp); err != nil {
 			panic(err)
 		}
-		table.SetHeader([]string{"Public Key", "IP Address", "Uptime", "RX", "TX"})
+		table.SetHeader([]string{"Public Key", "IP Address", "Uptime", "RX", "TX", "Path MTU"})
 		for _, p := range resp.Sessions {
+			mtu := "-"
+			if p.PathMTU > 0 {
+				mtu = fmt.Sprintf("%d", p.PathMTU)
+			}
 			table.Append([]string{
 				p.PublicKey,
 				p.IPAddress,
 				(time.Duration(p.Uptime) * time.Second).String(),
 				p.RXBytes.String(),
 				p.TXBytes.String(),
+				mtu,
 			})
 		}
 		table.Render()
@@ -323,7 +340,143 @@ func run() int {
 		}
 		table.Render()
 
-	case "addpeer", "removepeer":
+	case "getlisteners":
+		var resp admin.GetListenersResponse
+		if err := json.Unmarshal(recv.Response, &resp); err != nil {
+			panic(err)
+		}
+		fmtBool := func(b bool) string {
+			if b {
+				return "Yes"
+			}
+			return "-"
+		}
+		table.SetHeader([]string{"Scheme", "Address", "Interface", "Pr", "Password", "Accepted", "Active", "Rejected"})
+		for _, l := range resp.Listeners {
+			intf := l.Interface
+			if intf == "" {
+				intf = "-"
+			}
+			table.Append([]string{
+				l.Scheme,
+				l.Address,
+				intf,
+				fmt.Sprintf("%d", l.Priority),
+				fmtBool(l.Password),
+				fmt.Sprintf("%d", l.Accepted),
+				fmt.Sprintf("%d", l.Active),
+				fmt.Sprintf("%d", l.RejectedMaxConns+l.RejectedMaxPerIP+l.RejectedRate),
+			})
+		}
+		table.Render()
+
+	case "getforwards":
+		var resp proxy.GetForwardsResponse
+		if err := json.Unmarshal(recv.Response, &resp); err != nil {
+			panic(err)
+		}
+		table.SetHeader([]string{"Type", "Protocol", "Listen", "Target"})
+		for _, f := range resp.Forwards {
+			table.Append([]string{f.Type, f.Protocol, f.Listen, f.Target})
+		}
+		table.Render()
+
+	case "getfirewall":
+		var resp firewall.GetFirewallResponse
+		if err := json.Unmarshal(recv.Response, &resp); err != nil {
+			panic(err)
+		}
+		orAny := func(s string) string {
+			if s == "" {
+				return "*"
+			}
+			return s
+		}
+		table.SetHeader([]string{"Index", "Action", "Direction", "Key", "Source", "Destination", "Protocol", "Ports", "Packets", "Bytes"})
+		for _, r := range resp.Rules {
+			table.Append([]string{
+				fmt.Sprintf("%d", r.Index), r.Action, r.Direction, orAny(r.PublicKey), orAny(r.Source),
+				orAny(r.Destination), orAny(r.Protocol), orAny(r.Ports), fmt.Sprintf("%d", r.Packets), r.Bytes.String(),
+			})
+		}
+		for _, d := range []struct {
+			direction, policy string
+			counter           firewall.CounterEntry
+		}{
+			{"in", resp.InboundPolicy, resp.InboundDefault},
+			{"out", resp.OutboundPolicy, resp.OutboundDefault},
+		} {
+			table.Append([]string{
+				"-", d.policy, d.direction, "*", "*", "*", "*", "*", fmt.Sprintf("%d", d.counter.Packets), d.counter.Bytes.String(),
+			})
+		}
+		table.Render()
+		fmt.Printf("Established flows: %d, %d packets, %s\n", resp.Flows, resp.Established.Packets, resp.Established.Bytes)
+
+	case "addlistener":
+		var resp admin.AddListenerResponse
+		if err := json.Unmarshal(recv.Response, &resp); err != nil {
+			panic(err)
+		}
+		fmt.Println("Listening on", resp.Address)
+
+	case "reloadconfig":
+		var resp admin.ReloadConfigResponse
+		if err := json.Unmarshal(recv.Response, &resp); err != nil {
+			panic(err)
+		}
+		applied, restart := "-", "-"
+		if len(resp.Applied) > 0 {
+			applied = strings.Join(resp.Applied, ", ")
+		}
+		if len(resp.RestartRequired) > 0 {
+			restart = strings.Join(resp.RestartRequired, ", ")
+		}
+		table.Append([]string{"Applied changes:", applied})
+		table.Append([]string{"Restart required for:", restart})
+		table.Render()
+
+	case "getpeerstate":
+		var resp core.GetPeerStateResponse
+		if err := json.Unmarshal(recv.Response, &resp); err != nil {
+			panic(err)
+		}
+		table.SetHeader([]string{"URI", "Interface", "Runtime", "Uptime", "Failures", "Last Error"})
+		for _, peer := range resp.Peers {
+			intf, runtime, uptime, lasterr := "-", "No", "-", "-"
+			if peer.Interface != "" {
+				intf = peer.Interface
+			}
+			if peer.Runtime {
+				runtime = "Yes"
+			}
+			if peer.Uptime > 0 {
+				uptime = peer.Uptime.Round(time.Second).String()
+			}
+			if peer.LastError != "" {
+				lasterr = fmt.Sprintf("%s ago: %s", time.Since(peer.LastErrorTime).Round(time.Second), peer.LastError)
+			}
+			table.Append([]string{
+				peer.URI,
+				intf,
+				runtime,
+				uptime,
+				fmt.Sprintf("%d", peer.Failures),
+				lasterr,
+			})
+		}
+		table.Render()
+
+	case "subscribe":
+		var resp admin.SubscribeResponse
+		if err := json.Unmarshal(recv.Response, &resp); err != nil {
+			panic(err)
+		}
+		fmt.Println("Subscribed to", strings.Join(resp.Events, ", ")+", waiting for events")
+		return printEvents(decoder, cmdLineEnv.injson)
+
+	case "addpeer", "removepeer", "removelistener", "clearpeerstate", "addforward", "removeforward",
+		"addfirewallrule", "removefirewallrule":
 
 	default:
 		fmt.Println(string(recv.Response))
@@ -331,3 +484,46 @@ func run() int {
 
 	return 0
 }
+
+// printEvents prints the events that follow a subscribe response until the
+// connection is closed.
+func printEvents(decoder *json.Decoder, injson bool) int {
+	for {
+		var recv admin.AdminSocketResponse
+		if err := decoder.Decode(&recv); err != nil {
+			fmt.Println("Connection closed:", err)
+			return 1
+		}
+		if recv.Status == "error" {
+			fmt.Println("Admin socket returned an error:", recv.Error)
+			return 1
+		}
+		if injson {
+			// One event per line, so that the output can be piped.
+			var buf bytes.Buffer
+			if err := json.Compact(&buf, recv.Response); err != nil {
+				panic(err)
+			}
+			fmt.Println(buf.String())
+			continue
+		}
+		var ev admin.EventEntry
+		if err := json.Unmarshal(recv.Response, &ev); err != nil {
+			panic(err)
+		}
+		fields := []string{ev.Time.Format(time.RFC3339), ev.Event}
+		if ev.IPAddress != "" {
+			fields = append(fields, ev.IPAddress)
+		}
+		if ev.URI != "" {
+			fields = append(fields, ev.URI)
+		}
+		if ev.Inbound {
+			fields = append(fields, "inbound")
+		}
+		if ev.Error != "" {
+			fields = append(fields, "error: "+ev.Error)
+		}
+		fmt.Println(strings.Join(fields, " "))
+	}
+}
@@ -0,0 +1,28 @@
+package admin
+
+import (
+	"fmt"
+	"net/url"
+)
+
+type AddListenerRequest struct {
+	Uri   string `json:"uri"`
+	Sintf string `json:"intf,omitempty"`
+}
+
+type AddListenerResponse struct {
+	Address string `json:"address"`
+}
+
+func (a *AdminSocket) addListenerHandler(req *AddListenerRequest, res *AddListenerResponse) error {
+	u, err := url.Parse(req.Uri)
+	if err != nil {
+		return fmt.Errorf("unable to parse listener URI: %w", err)
+	}
+	listener, err := a.core.Listen(u, req.Sintf)
+	if err != nil {
+		return err
+	}
+	res.Address = listener.Addr().String()
+	return nil
+}
@@ -1,6 +1,8 @@
 package admin
 
 import (
+	"crypto/ed25519"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,16 +17,22 @@ import (
 	"github.com/ruvcoindev/ruvchain/src/core"
 )
 
-// TODO: Add authentication
-
 type AdminSocket struct {
-	core     *core.Core
-	log      core.Logger
-	listener net.Listener
-	handlers map[string]handler
-	done     chan struct{}
-	config   struct {
-		listenaddr ListenAddress
+	core      *core.Core
+	log       core.Logger
+	listener  net.Listener
+	handlers  map[string]handler
+	done      chan struct{}
+	auth      adminAuth
+	operators map[[ed25519.PublicKeySize]byte]role
+	config    struct {
+		listenaddr  ListenAddress
+		tokens      []AccessToken
+		users       []AccessUser
+		operators   []AccessOperator
+		tlsCert     TLSCertificate
+		tlsKey      TLSKey
+		tlsClientCA TLSClientCA
 	}
 }
 
@@ -32,6 +40,8 @@ type AdminSocketRequest struct {
 	Name      string          `json:"request"`
 	Arguments json.RawMessage `json:"arguments,omitempty"`
 	KeepAlive bool            `json:"keepalive,omitempty"`
+	Token     string          `json:"token,omitempty"`
+	Remote    string          `json:"remote,omitempty"` // Public key of a node to send the request to
 }
 
 type AdminSocketResponse struct {
@@ -45,6 +55,7 @@ type handler struct {
 	desc    string              // What does the endpoint do?
 	args    []string            // List of human-readable argument names
 	handler core.AddHandlerFunc // First is input map, second is output
+	role    role                // Access needed to call the handler
 }
 
 type ListResponse struct {
@@ -55,10 +66,22 @@ type ListEntry struct {
 	Command     string   `json:"command"`
 	Description string   `json:"description"`
 	Fields      []string `json:"fields,omitempty"`
+	ReadOnly    bool     `json:"read_only,omitempty"`
 }
 
 // AddHandler is called for each admin function to add the handler and help documentation to the API.
+// If access control is enabled, the handler can only be called with read-write access.
 func (a *AdminSocket) AddHandler(name, desc string, args []string, handlerfunc core.AddHandlerFunc) error {
+	return a.addHandler(name, desc, args, handlerfunc, roleReadWrite)
+}
+
+// AddReadOnlyHandler is like AddHandler, but for handlers that don't change
+// anything and can therefore also be called with read-only access.
+func (a *AdminSocket) AddReadOnlyHandler(name, desc string, args []string, handlerfunc core.AddHandlerFunc) error {
+	return a.addHandler(name, desc, args, handlerfunc, roleReadOnly)
+}
+
+func (a *AdminSocket) addHandler(name, desc string, args []string, handlerfunc core.AddHandlerFunc, r role) error {
 	if _, ok := a.handlers[strings.ToLower(name)]; ok {
 		return errors.New("handler already exists")
 	}
@@ -66,6 +89,7 @@ func (a *AdminSocket) AddHandler(name, desc string, args []string, handlerfunc c
 		desc:    desc,
 		args:    args,
 		handler: handlerfunc,
+		role:    r,
 	}
 	return nil
 }
@@ -83,7 +107,14 @@ func New(c *core.Core, log core.Logger, opts ...SetupOption) (*AdminSocket, erro
 	if a.config.listenaddr == "none" || a.config.listenaddr == "" {
 		return nil, nil
 	}
+	if err := a.auth.setup(a.config.tokens, a.config.users); err != nil {
+		return nil, err
+	}
+	if err := a.setupOperators(a.config.operators); err != nil {
+		return nil, err
+	}
 
+	var network string
 	listenaddr := string(a.config.listenaddr)
 	u, err := url.Parse(listenaddr)
 	if err == nil {
@@ -115,6 +146,13 @@ func New(c *core.Core, log core.Logger, opts ...SetupOption) (*AdminSocket, erro
 			}
 		case "tcp":
 			a.listener, err = net.Listen("tcp", u.Host)
+		case "tls":
+			var config *tls.Config
+			if config, err = a.tlsConfig(); err != nil {
+				return nil, err
+			}
+			a.listener, err = tls.Listen("tcp", u.Host, config)
+			network = "tls"
 		default:
 			a.listener, err = net.Listen("tcp", listenaddr)
 		}
@@ -125,17 +163,21 @@ func New(c *core.Core, log core.Logger, opts ...SetupOption) (*AdminSocket, erro
 		a.log.Errorf("Admin socket failed to listen: %v", err)
 		os.Exit(1)
 	}
+	if network == "" {
+		network = a.listener.Addr().Network()
+	}
 	a.log.Infof("%s admin socket listening on %s",
-		strings.ToUpper(a.listener.Addr().Network()),
+		strings.ToUpper(network),
 		a.listener.Addr().String())
 
-	_ = a.AddHandler("list", "List available commands", []string{}, func(_ json.RawMessage) (interface{}, error) {
+	_ = a.AddReadOnlyHandler("list", "List available commands", []string{}, func(_ json.RawMessage) (interface{}, error) {
 		res := &ListResponse{}
 		for name, handler := range a.handlers {
 			res.List = append(res.List, ListEntry{
 				Command:     name,
 				Description: handler.desc,
 				Fields:      handler.args,
+				ReadOnly:    handler.role == roleReadOnly,
 			})
 		}
 		sort.SliceStable(res.List, func(i, j int) bool {
@@ -149,7 +191,7 @@ func New(c *core.Core, log core.Logger, opts ...SetupOption) (*AdminSocket, erro
 }
 
 func (a *AdminSocket) SetupAdminHandlers() {
-	_ = a.AddHandler(
+	_ = a.AddReadOnlyHandler(
 		"getSelf", "Show details about this node", []string{},
 		func(in json.RawMessage) (interface{}, error) {
 			req := &GetSelfRequest{}
@@ -163,7 +205,7 @@ func (a *AdminSocket) SetupAdminHandlers() {
 			return res, nil
 		},
 	)
-	_ = a.AddHandler(
+	_ = a.AddReadOnlyHandler(
 		"getPeers", "Show directly connected peers", []string{},
 		func(in json.RawMessage) (interface{}, error) {
 			req := &GetPeersRequest{}
@@ -177,7 +219,7 @@ func (a *AdminSocket) SetupAdminHandlers() {
 			return res, nil
 		},
 	)
-	_ = a.AddHandler(
+	_ = a.AddReadOnlyHandler(
 		"getTree", "Show known Tree entries", []string{},
 		func(in json.RawMessage) (interface{}, error) {
 			req := &GetTreeRequest{}
@@ -191,7 +233,7 @@ func (a *AdminSocket) SetupAdminHandlers() {
 			return res, nil
 		},
 	)
-	_ = a.AddHandler(
+	_ = a.AddReadOnlyHandler(
 		"getPaths", "Show established paths through this node", []string{},
 		func(in json.RawMessage) (interface{}, error) {
 			req := &GetPathsRequest{}
@@ -205,7 +247,7 @@ func (a *AdminSocket) SetupAdminHandlers() {
 			return res, nil
 		},
 	)
-	_ = a.AddHandler(
+	_ = a.AddReadOnlyHandler(
 		"getSessions", "Show established traffic sessions with remote nodes", []string{},
 		func(in json.RawMessage) (interface{}, error) {
 			req := &GetSessionsRequest{}
@@ -247,6 +289,62 @@ func (a *AdminSocket) SetupAdminHandlers() {
 			return res, nil
 		},
 	)
+	_ = a.AddReadOnlyHandler(
+		"getListeners", "Show listeners for incoming peer connections", []string{},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &GetListenersRequest{}
+			res := &GetListenersResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := a.getListenersHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddHandler(
+		"addListener", "Start a listener for incoming peer connections", []string{"uri", "intf"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &AddListenerRequest{}
+			res := &AddListenerResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := a.addListenerHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddHandler(
+		"removeListener", "Stop a listener for incoming peer connections", []string{"uri", "intf"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &RemoveListenerRequest{}
+			res := &RemoveListenerResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := a.removeListenerHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddReadOnlyHandler(
+		"subscribe", "Stream events as they happen, optionally only from the given categories (peers, sessions, paths, listeners)", []string{"events"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &SubscribeRequest{}
+			res := &SubscribeResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := a.subscribeHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
 }
 
 // IsStarted returns true if the module has been started.
@@ -306,11 +404,13 @@ func (a *AdminSocket) handleRequest(conn net.Conn) {
 
 	defer conn.Close()
 
+	connRole := a.connRole(conn)
 	for {
 		var err error
 		var buf json.RawMessage
 		var req AdminSocketRequest
 		var resp AdminSocketResponse
+		var sub *subscription
 		req.Arguments = []byte("{}")
 		if err := func() error {
 			if err = decoder.Decode(&buf); err != nil {
@@ -319,15 +419,47 @@ func (a *AdminSocket) handleRequest(conn net.Conn) {
 			if err = json.Unmarshal(buf, &req); err != nil {
 				return fmt.Errorf("Failed to unmarshal request")
 			}
+			// Don't echo the token back in the response.
+			token := req.Token
+			req.Token = ""
 			resp.Request = req
 			if req.Name == "" {
 				return fmt.Errorf("No request specified")
 			}
 			reqname := strings.ToLower(req.Name)
+			if req.Remote != "" {
+				// Remote requests are signed with the node's own key, so
+				// they need the same access as changing the node would.
+				if a.auth.enabled() {
+					r, err := a.auth.requestRole(connRole, token)
+					switch {
+					case err != nil:
+						return err
+					case r < roleReadWrite:
+						return fmt.Errorf("Remote requests require read-write access")
+					}
+				}
+				if resp.Response, err = a.forwardRequest(req); err != nil {
+					return err
+				}
+				resp.Status = "success"
+				return nil
+			}
 			handler, ok := a.handlers[reqname]
 			if !ok {
 				return fmt.Errorf("Unknown action '%s', try 'list' for help", reqname)
 			}
+			if a.auth.enabled() {
+				r, err := a.auth.requestRole(connRole, token)
+				switch {
+				case err != nil:
+					return err
+				case r == roleNone:
+					return errAuthRequired
+				case r < handler.role:
+					return fmt.Errorf("Action '%s' requires read-write access", reqname)
+				}
+			}
 			res, err := handler.handler(req.Arguments)
 			if err != nil {
 				return err
@@ -335,6 +467,9 @@ func (a *AdminSocket) handleRequest(conn net.Conn) {
 			if resp.Response, err = json.Marshal(res); err != nil {
 				return fmt.Errorf("Failed to marshal response: %w", err)
 			}
+			if r, ok := res.(*SubscribeResponse); ok {
+				sub = r.sub
+			}
 			resp.Status = "success"
 			return nil
 		}(); err != nil {
@@ -344,6 +479,12 @@ func (a *AdminSocket) handleRequest(conn net.Conn) {
 		if err = encoder.Encode(resp); err != nil {
 			a.log.Debugln("Encode error:", err)
 		}
+		if sub != nil {
+			// The connection now belongs to the subscription, which
+			// keeps it open and pushes events until it is closed.
+			a.stream(conn, encoder, req, sub)
+			break
+		}
 		if !req.KeepAlive {
 			break
 		} else {
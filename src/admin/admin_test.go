@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/gologme/log"
+	"github.com/ruvcoindev/ruvchain/src/config"
+	"github.com/ruvcoindev/ruvchain/src/core"
+)
+
+// newTestAdmin starts an admin socket for a new node, listening on a UNIX
+// socket unless another ListenAddress is given.
+func newTestAdmin(t *testing.T, opts ...SetupOption) *AdminSocket {
+	t.Helper()
+	logger := log.New(io.Discard, "", log.Flags())
+	cfg := config.GenerateConfig()
+	c, err := core.New(cfg.Certificate, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Stop)
+	opts = append([]SetupOption{
+		ListenAddress("unix://" + filepath.Join(t.TempDir(), "admin.sock")),
+	}, opts...)
+	a, err := New(c, logger, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = a.Stop() })
+	a.SetupAdminHandlers()
+	return a
+}
+
+// request sends a single request over the given connection and returns the
+// response.
+func request(t *testing.T, conn net.Conn, req AdminSocketRequest) AdminSocketResponse {
+	t.Helper()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		t.Fatal(err)
+	}
+	var resp AdminSocketResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// dial connects to the admin socket.
+func dial(t *testing.T, a *AdminSocket) net.Conn {
+	t.Helper()
+	addr := a.listener.Addr()
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
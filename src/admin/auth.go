@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"os/user"
+	"strconv"
+)
+
+// role is the level of access that a request has to the admin socket.
+type role int
+
+const (
+	roleNone role = iota
+	roleReadOnly
+	roleReadWrite
+)
+
+var errAuthRequired = errors.New("authentication required, supply a valid token")
+var errInvalidToken = errors.New("invalid token")
+
+type adminAuth struct {
+	tokens map[[sha256.Size]byte]role // By hash, so lookups don't leak the token
+	users  map[uint32]role            // By UID, for UNIX socket connections
+}
+
+// setup resolves the configured tokens and users. Access control is only
+// enabled if at least one of them is configured.
+func (auth *adminAuth) setup(tokens []AccessToken, users []AccessUser) error {
+	auth.tokens = make(map[[sha256.Size]byte]role, len(tokens))
+	auth.users = make(map[uint32]role, len(users))
+	for _, t := range tokens {
+		if t.Token == "" {
+			return errors.New("admin tokens must not be empty")
+		}
+		auth.tokens[sha256.Sum256([]byte(t.Token))] = accessRole(t.ReadOnly)
+	}
+	for _, u := range users {
+		uid, err := lookupUID(u.User)
+		if err != nil {
+			return err
+		}
+		auth.users[uid] = accessRole(u.ReadOnly)
+	}
+	return nil
+}
+
+func (auth *adminAuth) enabled() bool {
+	return len(auth.tokens) > 0 || len(auth.users) > 0
+}
+
+// connRole returns the access that a connection has without a token, which
+// is only ever the case for UNIX socket connections from configured users.
+func (a *AdminSocket) connRole(conn net.Conn) role {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok || len(a.auth.users) == 0 {
+		return roleNone
+	}
+	uid, err := peerUID(uc)
+	if err != nil {
+		a.log.Debugln("Failed to get admin socket peer credentials:", err)
+		return roleNone
+	}
+	return a.auth.users[uid]
+}
+
+// requestRole returns the access that a request has, which is the greater
+// of the access of the connection and of the token, if one is given.
+func (auth *adminAuth) requestRole(conn role, token string) (role, error) {
+	if token == "" {
+		return conn, nil
+	}
+	r, ok := auth.tokens[sha256.Sum256([]byte(token))]
+	if !ok {
+		return roleNone, errInvalidToken
+	}
+	return max(r, conn), nil
+}
+
+func accessRole(readOnly bool) role {
+	if readOnly {
+		return roleReadOnly
+	}
+	return roleReadWrite
+}
+
+// lookupUID accepts either a user name or a numeric user ID.
+func lookupUID(name string) (uint32, error) {
+	if uid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(uid), nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, fmt.Errorf("unknown admin user %q: %w", name, err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("admin user %q has no numeric user ID", name)
+	}
+	return uint32(uid), nil
+}
@@ -0,0 +1,120 @@
+package admin
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRequestRole(t *testing.T) {
+	var auth adminAuth
+	if err := auth.setup([]AccessToken{
+		{Token: "rw"},
+		{Token: "ro", ReadOnly: true},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		conn  role
+		token string
+		want  role
+		err   error
+	}{
+		{roleNone, "", roleNone, nil},
+		{roleNone, "rw", roleReadWrite, nil},
+		{roleNone, "ro", roleReadOnly, nil},
+		{roleReadWrite, "ro", roleReadWrite, nil}, // The greater access wins
+		{roleReadOnly, "", roleReadOnly, nil},
+		{roleReadWrite, "wrong", roleNone, errInvalidToken},
+	} {
+		r, err := auth.requestRole(tt.conn, tt.token)
+		if r != tt.want || !errors.Is(err, tt.err) {
+			t.Fatalf("requestRole(%v, %q) = %v, %v, want %v, %v", tt.conn, tt.token, r, err, tt.want, tt.err)
+		}
+	}
+	if err := auth.setup([]AccessToken{{Token: ""}}, nil); err == nil {
+		t.Fatal("expected empty token to be refused")
+	}
+}
+
+// Read-only access can call read-only handlers but not the others, and
+// requests without access are refused outright.
+func TestAuthTokens(t *testing.T) {
+	a := newTestAdmin(t,
+		AccessToken{Token: "rw"},
+		AccessToken{Token: "ro", ReadOnly: true},
+	)
+	conn := dial(t, a)
+	for _, tt := range []struct {
+		name  string
+		token string
+		err   string // Empty if the handler should be reached
+	}{
+		{"getSelf", "", errAuthRequired.Error()},
+		{"getSelf", "wrong", errInvalidToken.Error()},
+		{"getSelf", "ro", ""},
+		{"getSelf", "rw", ""},
+		{"clearPeerState", "ro", "requires read-write access"},
+		{"clearPeerState", "rw", "peer state file is not configured"},
+	} {
+		resp := request(t, conn, AdminSocketRequest{Name: tt.name, Token: tt.token, KeepAlive: true})
+		switch {
+		case tt.err == "" && resp.Status != "success":
+			t.Fatalf("%s with token %q failed: %s", tt.name, tt.token, resp.Error)
+		case tt.err != "" && !strings.Contains(resp.Error, tt.err):
+			t.Fatalf("%s with token %q: expected error %q, got %q", tt.name, tt.token, tt.err, resp.Error)
+		}
+		if resp.Request.Token != "" {
+			t.Fatal("token was echoed back in the response")
+		}
+	}
+}
+
+// Connections to a UNIX socket from a configured user get that user's
+// access without a token.
+func TestAuthUsers(t *testing.T) {
+	uid := strconv.Itoa(os.Getuid())
+	a := newTestAdmin(t, AccessUser{User: uid, ReadOnly: true})
+	conn := dial(t, a)
+	if _, err := peerUID(conn.(*net.UnixConn)); err != nil {
+		t.Skip("peer credentials are not supported:", err)
+	}
+	resp := request(t, conn, AdminSocketRequest{Name: "getSelf", KeepAlive: true})
+	if resp.Status != "success" {
+		t.Fatalf("getSelf failed for the configured user: %s", resp.Error)
+	}
+	resp = request(t, conn, AdminSocketRequest{Name: "clearPeerState", KeepAlive: true})
+	if !strings.Contains(resp.Error, "requires read-write access") {
+		t.Fatalf("expected read-only user to be refused, got %q", resp.Error)
+	}
+
+	// Other users get no access, and neither do other kinds of connection.
+	if err := a.auth.setup(nil, []AccessUser{{User: strconv.Itoa(os.Getuid() + 1)}}); err != nil {
+		t.Fatal(err)
+	}
+	if r := a.connRole(dial(t, a)); r != roleNone {
+		t.Fatalf("expected no access for another user, got %v", r)
+	}
+	if err := a.auth.setup(nil, []AccessUser{{User: uid}}); err != nil {
+		t.Fatal(err)
+	}
+	if r := a.connRole(dial(t, a)); r != roleReadWrite {
+		t.Fatalf("expected read-write access for the current user, got %v", r)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tcp, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	if r := a.connRole(tcp); r != roleNone {
+		t.Fatalf("expected no access over TCP, got %v", r)
+	}
+}
@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"slices"
+	"strings"
+)
+
+type GetListenersRequest struct{}
+
+type GetListenersResponse struct {
+	Listeners []ListenerEntry `json:"listeners"`
+}
+
+type ListenerEntry struct {
+	URI       string `json:"uri"`
+	Scheme    string `json:"scheme"`
+	Address   string `json:"address"`
+	Interface string `json:"interface,omitempty"`
+	Local     bool   `json:"local,omitempty"`
+	Priority  uint64 `json:"priority"`
+	Password  bool   `json:"password"`
+	Accepted  uint64 `json:"accepted"`
+	Active    uint64 `json:"active"`
+
+	MaxConns         uint64  `json:"max_conns,omitempty"`
+	MaxPerIP         uint64  `json:"max_per_ip,omitempty"`
+	AcceptRate       float64 `json:"accept_rate,omitempty"`
+	RejectedMaxConns uint64  `json:"rejected_max_conns"`
+	RejectedMaxPerIP uint64  `json:"rejected_max_per_ip"`
+	RejectedRate     uint64  `json:"rejected_rate"`
+}
+
+func (a *AdminSocket) getListenersHandler(_ *GetListenersRequest, res *GetListenersResponse) error {
+	listeners := a.core.GetListeners()
+	res.Listeners = make([]ListenerEntry, 0, len(listeners))
+	for _, l := range listeners {
+		res.Listeners = append(res.Listeners, ListenerEntry{
+			URI:       l.URI,
+			Scheme:    l.Scheme,
+			Address:   l.Address.String(),
+			Interface: l.Interface,
+			Local:     l.Local,
+			Priority:  uint64(l.Priority), // can't be uint8 thanks to gobind
+			Password:  l.Password,
+			Accepted:  l.Accepted,
+			Active:    l.Active,
+
+			MaxConns:         l.MaxConns,
+			MaxPerIP:         l.MaxPerIP,
+			AcceptRate:       l.AcceptRate,
+			RejectedMaxConns: l.RejectedMaxConns,
+			RejectedMaxPerIP: l.RejectedMaxPerIP,
+			RejectedRate:     l.RejectedRate,
+		})
+	}
+	slices.SortStableFunc(res.Listeners, func(a, b ListenerEntry) int {
+		if d := strings.Compare(a.Scheme, b.Scheme); d != 0 {
+			return d
+		}
+		return strings.Compare(a.Address, b.Address)
+	})
+	return nil
+}
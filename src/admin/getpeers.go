@@ -18,22 +18,23 @@ type GetPeersResponse struct {
 }
 
 type PeerEntry struct {
-	URI           string        `json:"remote,omitempty"`
-	Up            bool          `json:"up"`
-	Inbound       bool          `json:"inbound"`
-	IPAddress     string        `json:"address,omitempty"`
-	PublicKey     string        `json:"key"`
-	Port          uint64        `json:"port"`
-	Priority      uint64        `json:"priority"`
-	Cost          uint64        `json:"cost"`
-	RXBytes       DataUnit      `json:"bytes_recvd,omitempty"`
-	TXBytes       DataUnit      `json:"bytes_sent,omitempty"`
-	RXRate        DataUnit      `json:"rate_recvd,omitempty"`
-	TXRate        DataUnit      `json:"rate_sent,omitempty"`
-	Uptime        float64       `json:"uptime,omitempty"`
-	Latency       time.Duration `json:"latency,omitempty"`
-	LastErrorTime time.Duration `json:"last_error_time,omitempty"`
-	LastError     string        `json:"last_error,omitempty"`
+	URI           string            `json:"remote,omitempty"`
+	Up            bool              `json:"up"`
+	Inbound       bool              `json:"inbound"`
+	IPAddress     string            `json:"address,omitempty"`
+	PublicKey     string            `json:"key"`
+	Port          uint64            `json:"port"`
+	Priority      uint64            `json:"priority"`
+	Cost          uint64            `json:"cost"`
+	RXBytes       DataUnit          `json:"bytes_recvd,omitempty"`
+	TXBytes       DataUnit          `json:"bytes_sent,omitempty"`
+	RXRate        DataUnit          `json:"rate_recvd,omitempty"`
+	TXRate        DataUnit          `json:"rate_sent,omitempty"`
+	Uptime        float64           `json:"uptime,omitempty"`
+	Latency       time.Duration     `json:"latency,omitempty"`
+	LastErrorTime time.Duration     `json:"last_error_time,omitempty"`
+	LastError     string            `json:"last_error,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
 }
 
 func (a *AdminSocket) getPeersHandler(_ *GetPeersRequest, res *GetPeersResponse) error {
@@ -52,6 +53,7 @@ func (a *AdminSocket) getPeersHandler(_ *GetPeersRequest, res *GetPeersResponse)
 			RXRate:   DataUnit(p.RXRate),
 			TXRate:   DataUnit(p.TXRate),
 			Uptime:   p.Uptime.Seconds(),
+			Metadata: p.Metadata,
 		}
 		if p.Latency > 0 {
 			peer.Latency = p.Latency
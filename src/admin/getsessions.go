@@ -21,6 +21,7 @@ type SessionEntry struct {
 	RXBytes   DataUnit `json:"bytes_recvd"`
 	TXBytes   DataUnit `json:"bytes_sent"`
 	Uptime    float64  `json:"uptime"`
+	PathMTU   uint64   `json:"path_mtu,omitempty"` // Only once it has been learned
 }
 
 func (a *AdminSocket) getSessionsHandler(_ *GetSessionsRequest, res *GetSessionsResponse) error {
@@ -28,13 +29,17 @@ func (a *AdminSocket) getSessionsHandler(_ *GetSessionsRequest, res *GetSessions
 	res.Sessions = make([]SessionEntry, 0, len(sessions))
 	for _, s := range sessions {
 		addr := address.AddrForKey(s.Key)
-		res.Sessions = append(res.Sessions, SessionEntry{
+		entry := SessionEntry{
 			IPAddress: net.IP(addr[:]).String(),
 			PublicKey: hex.EncodeToString(s.Key[:]),
 			RXBytes:   DataUnit(s.RXBytes),
 			TXBytes:   DataUnit(s.TXBytes),
 			Uptime:    s.Uptime.Seconds(),
-		})
+		}
+		if s.PathMTULearned {
+			entry.PathMTU = s.PathMTU
+		}
+		res.Sessions = append(res.Sessions, entry)
 	}
 	slices.SortStableFunc(res.Sessions, func(a, b SessionEntry) int {
 		return strings.Compare(a.PublicKey, b.PublicKey)
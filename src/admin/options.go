@@ -19,6 +19,18 @@ func (c *AdminSocket) _applyOption(opt SetupOption) {
 		c.config.listenaddr = v
 	case LogLookups:
 		c.logLookups()
+	case AccessToken:
+		c.config.tokens = append(c.config.tokens, v)
+	case AccessUser:
+		c.config.users = append(c.config.users, v)
+	case AccessOperator:
+		c.config.operators = append(c.config.operators, v)
+	case TLSCertificate:
+		c.config.tlsCert = v
+	case TLSKey:
+		c.config.tlsKey = v
+	case TLSClientCA:
+		c.config.tlsClientCA = v
 	}
 }
 
@@ -34,6 +46,41 @@ type LogLookups struct{}
 
 func (l LogLookups) isSetupOption() {}
 
+// AccessToken allows requests that carry the token. Once any tokens or users
+// are configured, requests without access are refused.
+type AccessToken struct {
+	Token    string
+	ReadOnly bool
+}
+
+// AccessUser allows connections to a UNIX admin socket from the given user,
+// specified either by name or by user ID.
+type AccessUser struct {
+	User     string
+	ReadOnly bool
+}
+
+// AccessOperator allows signed admin requests sent over the network from
+// the node with the given hex-encoded public key.
+type AccessOperator struct {
+	PublicKey string
+	ReadOnly  bool
+}
+
+func (a AccessToken) isSetupOption()    {}
+func (a AccessUser) isSetupOption()     {}
+func (a AccessOperator) isSetupOption() {}
+
+// Paths to the PEM encoded certificate and key for a tls:// admin socket,
+// and optionally to the CA that client certificates must be signed by.
+type TLSCertificate string
+type TLSKey string
+type TLSClientCA string
+
+func (a TLSCertificate) isSetupOption() {}
+func (a TLSKey) isSetupOption()         {}
+func (a TLSClientCA) isSetupOption()    {}
+
 func (a *AdminSocket) logLookups() {
 	type resi struct {
 		Address string   `json:"addr"`
@@ -58,7 +105,7 @@ func (a *AdminSocket) logLookups() {
 		infos[k] = info{path: l.Path, time: time.Now()}
 		m.Unlock()
 	})
-	_ = a.AddHandler(
+	_ = a.AddReadOnlyHandler(
 		"lookups", "Dump a record of lookups received in the past hour", []string{},
 		func(in json.RawMessage) (interface{}, error) {
 			m.Lock()
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package admin
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUID is not supported on this platform, so connections can only be
+// authenticated with tokens.
+func peerUID(conn *net.UnixConn) (uint32, error) {
+	return 0, errors.New("peer credentials are not supported on this platform")
+}
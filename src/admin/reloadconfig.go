@@ -0,0 +1,8 @@
+package admin
+
+type ReloadConfigRequest struct{}
+
+type ReloadConfigResponse struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
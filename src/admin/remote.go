@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errNotOperator = errors.New("access denied")
+
+// setupOperators resolves the configured operator keys and, if there are any,
+// starts answering remote admin requests from them.
+func (a *AdminSocket) setupOperators(operators []AccessOperator) error {
+	a.operators = make(map[[ed25519.PublicKeySize]byte]role, len(operators))
+	for _, o := range operators {
+		key, err := hex.DecodeString(o.PublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid admin operator key %q", o.PublicKey)
+		}
+		a.operators[[ed25519.PublicKeySize]byte(key)] = accessRole(o.ReadOnly)
+	}
+	if len(a.operators) > 0 {
+		a.core.SetRemoteAdminHandler(a.isOperator, a.handleRemoteRequest)
+	}
+	return nil
+}
+
+// isOperator is called by the core to drop requests from other nodes before
+// they are checked any further.
+func (a *AdminSocket) isOperator(from ed25519.PublicKey) bool {
+	return a.operators[[ed25519.PublicKeySize]byte(from)] != roleNone
+}
+
+// handleRemoteRequest is called by the core for each signed admin request
+// that arrives over the network.
+func (a *AdminSocket) handleRemoteRequest(from ed25519.PublicKey, name string, args json.RawMessage) (interface{}, error) {
+	r := a.operators[[ed25519.PublicKeySize]byte(from)]
+	if r == roleNone {
+		a.log.Warnf("Refused remote admin request %q from %s", name, hex.EncodeToString(from))
+		return nil, errNotOperator
+	}
+	reqname := strings.ToLower(name)
+	handler, ok := a.handlers[reqname]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("Unknown action '%s', try 'list' for help", reqname)
+	case reqname == "subscribe":
+		return nil, fmt.Errorf("Action '%s' is not available remotely", reqname)
+	case r < handler.role:
+		return nil, fmt.Errorf("Action '%s' requires read-write access", reqname)
+	}
+	a.log.Infof("Remote admin request %q from %s", reqname, hex.EncodeToString(from))
+	if len(args) == 0 {
+		args = []byte("{}")
+	}
+	return handler.handler(args)
+}
+
+// forwardRequest sends the request to the remote node given in the request,
+// signed with this node's key, and returns the remote node's response.
+func (a *AdminSocket) forwardRequest(req AdminSocketRequest) (json.RawMessage, error) {
+	key, err := hex.DecodeString(req.Remote)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid remote key %q", req.Remote)
+	}
+	if strings.EqualFold(req.Name, "subscribe") {
+		return nil, fmt.Errorf("Action '%s' is not available remotely", strings.ToLower(req.Name))
+	}
+	res, err := a.core.RemoteAdmin(key, req.Name, req.Arguments)
+	if err != nil {
+		return nil, fmt.Errorf("remote node: %w", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,21 @@
+package admin
+
+import (
+	"fmt"
+	"net/url"
+)
+
+type RemoveListenerRequest struct {
+	Uri   string `json:"uri"`
+	Sintf string `json:"intf,omitempty"`
+}
+
+type RemoveListenerResponse struct{}
+
+func (a *AdminSocket) removeListenerHandler(req *RemoveListenerRequest, _ *RemoveListenerResponse) error {
+	u, err := url.Parse(req.Uri)
+	if err != nil {
+		return fmt.Errorf("unable to parse listener URI: %w", err)
+	}
+	return a.core.RemoveListener(u, req.Sintf)
+}
@@ -0,0 +1,160 @@
+package admin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ruvcoindev/ruvchain/src/address"
+	"github.com/ruvcoindev/ruvchain/src/core"
+)
+
+// How many events can be queued for a subscriber before it is considered
+// too slow and the subscription is ended.
+const subscribeQueueSize = 256
+
+// The event categories that can be subscribed to, and the events in each.
+var eventCategories = map[string][]core.EventType{
+	"peers":     {core.EventPeerUp, core.EventPeerDown},
+	"sessions":  {core.EventSessionOpen, core.EventSessionClose},
+	"paths":     {core.EventPathUpdate},
+	"listeners": {core.EventListenerStart, core.EventListenerStop},
+}
+
+type SubscribeRequest struct {
+	Events string `json:"events,omitempty"` // Comma-separated categories, all if empty
+}
+
+type SubscribeResponse struct {
+	Events []string `json:"events"`
+	sub    *subscription
+}
+
+// EventEntry is sent with the status "event" for each event after the
+// response to a subscribe request.
+type EventEntry struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	IPAddress string    `json:"address,omitempty"`
+	PublicKey string    `json:"key,omitempty"`
+	URI       string    `json:"uri,omitempty"`
+	Inbound   bool      `json:"inbound,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type subscription struct {
+	ch          chan core.Event
+	unsubscribe func()
+}
+
+func (a *AdminSocket) subscribeHandler(req *SubscribeRequest, res *SubscribeResponse) error {
+	wanted := map[core.EventType]struct{}{}
+	for _, name := range strings.Split(req.Events, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		types, ok := eventCategories[name]
+		if !ok {
+			return fmt.Errorf("unknown event category %q", name)
+		}
+		for _, t := range types {
+			wanted[t] = struct{}{}
+		}
+		if !slices.Contains(res.Events, name) {
+			res.Events = append(res.Events, name)
+		}
+	}
+	if len(res.Events) == 0 {
+		for name, types := range eventCategories {
+			for _, t := range types {
+				wanted[t] = struct{}{}
+			}
+			res.Events = append(res.Events, name)
+		}
+	}
+	slices.Sort(res.Events)
+
+	sub := &subscription{
+		ch: make(chan core.Event, subscribeQueueSize),
+	}
+	var full bool
+	sub.unsubscribe = a.core.Subscribe(func(ev core.Event) {
+		if _, ok := wanted[ev.Type]; !ok || full {
+			return
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			// The subscriber isn't keeping up, so give up on it rather
+			// than holding up everyone else.
+			full = true
+			close(sub.ch)
+		}
+	})
+	res.sub = sub
+	return nil
+}
+
+// stream sends events to the connection until it is closed, the admin
+// socket is stopped or the subscriber falls behind.
+func (a *AdminSocket) stream(conn net.Conn, encoder *json.Encoder, req AdminSocketRequest, sub *subscription) {
+	defer sub.unsubscribe()
+	closed := make(chan struct{})
+	go func() {
+		// Nothing more is expected from the client, so anything it sends
+		// is discarded until the connection closes.
+		_, _ = io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-a.done:
+			return
+		case ev, ok := <-sub.ch:
+			resp := AdminSocketResponse{
+				Status:  "event",
+				Request: req,
+			}
+			if !ok {
+				resp.Status = "error"
+				resp.Error = "subscriber fell behind, events were dropped"
+				_ = encoder.Encode(resp)
+				return
+			}
+			var err error
+			if resp.Response, err = json.Marshal(eventEntry(ev)); err != nil {
+				a.log.Debugln("Failed to marshal event:", err)
+				continue
+			}
+			if err = encoder.Encode(resp); err != nil {
+				a.log.Debugln("Encode error:", err)
+				return
+			}
+		}
+	}
+}
+
+func eventEntry(ev core.Event) EventEntry {
+	entry := EventEntry{
+		Event:   string(ev.Type),
+		Time:    ev.Time,
+		URI:     ev.URI,
+		Inbound: ev.Inbound,
+	}
+	if addr := address.AddrForKey(ev.Key); addr != nil {
+		entry.IPAddress = net.IP(addr[:]).String()
+		entry.PublicKey = hex.EncodeToString(ev.Key)
+	}
+	if ev.Error != nil {
+		entry.Error = ev.Error.Error()
+	}
+	return entry
+}
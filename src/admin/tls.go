@@ -0,0 +1,39 @@
+package admin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// tlsConfig builds the TLS configuration for a tls:// admin socket. If a
+// client CA is configured then clients must present a certificate that is
+// signed by it.
+func (a *AdminSocket) tlsConfig() (*tls.Config, error) {
+	if a.config.tlsCert == "" || a.config.tlsKey == "" {
+		return nil, errors.New("a certificate and key are required for a tls:// admin socket")
+	}
+	cert, err := tls.LoadX509KeyPair(string(a.config.tlsCert), string(a.config.tlsKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load admin socket certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if a.config.tlsClientCA != "" {
+		pem, err := os.ReadFile(string(a.config.tlsClientCA))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read admin socket client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in admin socket client CA %q", a.config.tlsClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
@@ -45,17 +45,78 @@ type NodeConfig struct {
 	Certificate         *tls.Certificate           `json:"-"`
 	Peers               []string                   `comment:"List of outbound peer connection strings (e.g. tls://a.b.c.d:e or\nsocks://a.b.c.d:e/f.g.h.i:j). Connection strings can contain options,\nsee https://ruvcoindev.github.io/configurationref.html#peers.\nRuvchain has no concept of bootstrap nodes - all network traffic\nwill transit peer connections. Therefore make sure to only peer with\nnearby nodes that have good connectivity and low latency. Avoid adding\npeers to this list from distant countries as this will worsen your\nnode's connectivity and performance considerably."`
 	InterfacePeers      map[string][]string        `comment:"List of connection strings for outbound peer connections in URI format,\narranged by source interface, e.g. { \"eth0\": [ \"tls://a.b.c.d:e\" ] }.\nYou should only use this option if your machine is multi-homed and you\nwant to establish outbound peer connections on different interfaces.\nOtherwise you should use \"Peers\"."`
-	Listen              []string                   `comment:"Listen addresses for incoming connections. You will need to add\nlisteners in order to accept incoming peerings from non-local nodes.\nThis is not required if you wish to establish outbound peerings only.\nMulticast peer discovery will work regardless of any listeners set\nhere. Each listener should be specified in URI format as above, e.g.\ntls://0.0.0.0:0 or tls://[::]:0 to listen on all interfaces."`
+	Listen              []string                   `comment:"Listen addresses for incoming connections. You will need to add\nlisteners in order to accept incoming peerings from non-local nodes.\nThis is not required if you wish to establish outbound peerings only.\nMulticast peer discovery will work regardless of any listeners set\nhere. Each listener should be specified in URI format as above, e.g.\ntls://0.0.0.0:0 or tls://[::]:0 to listen on all interfaces. The\noptions ?maxconns=N, ?maxperip=N and ?acceptrate=N (per second) can\nbe used to limit the number of incoming connections. A listener\nsuch as socks://a.b.c.d:e accepts peerings that are relayed by that\nSOCKS server, for nodes that can only reach others through one."`
 	AdminListen         string                     `json:",omitempty" comment:"Listen address for admin connections. Default is to listen for local\nconnections either on TCP/5001 or a UNIX socket depending on your\nplatform. Use this value for ruvchainctl -endpoint=X. To disable\nthe admin socket, use the value \"none\" instead."`
+	AdminTLSCertFile    string                     `json:",omitempty" comment:"Paths to the PEM encoded certificate and key to use when AdminListen\nis a tls://host:port address. Relative paths are relative to the\ndirectory of the configuration file."`
+	AdminTLSKeyFile     string                     `json:",omitempty"`
+	AdminTLSClientCA    string                     `json:",omitempty" comment:"Optional path to a PEM encoded CA certificate. If set, clients of a\ntls:// admin socket must present a certificate signed by this CA.\nUse ruvchainctl -cacert=X -cert=Y -key=Z to connect."`
+	AdminTokens         []AdminTokenConfig         `json:",omitempty" comment:"Optional list of tokens that give access to the admin socket. If any\ntokens or users are configured then requests without access are\nrefused. Tokens with ReadOnly set can only use commands that don't\nchange anything, e.g. for monitoring. Use ruvchainctl -token=X."`
+	AdminUsers          []AdminUserConfig          `json:",omitempty" comment:"Optional list of local users, by name or user ID, that are allowed to\nuse a unix:// admin socket without a token. This is checked using\nthe peer credentials of the socket on Linux, macOS and FreeBSD."`
+	AdminOperators      []AdminOperatorConfig      `json:",omitempty" comment:"Optional list of hex-encoded public keys of nodes that are allowed\nto send signed admin requests to this node over the network, e.g.\nusing ruvchainctl -remote=X on the operator's node. Operators with\nReadOnly set can only use commands that don't change anything.\nThe admin socket must not be disabled for this to work."`
+	MetricsListen       string                     `json:",omitempty" comment:"Optional listen address for an HTTP endpoint that serves node metrics\nin the Prometheus text format at /metrics, e.g. \"127.0.0.1:9101\".\nThe metrics include peer public keys and URIs, so avoid exposing\nthis to untrusted networks. Leave empty to disable."`
+	SOCKSListen         string                     `json:",omitempty" comment:"Optional local listen address for a SOCKS5 proxy into the network,\ne.g. \"127.0.0.1:1080\". Destinations can be IPv6 addresses in the\nnetwork or names of the form <public key>.ruv. There is no\nauthentication, so avoid exposing this to untrusted networks.\nRequires IfName to be \"netstack\". Leave empty to disable."`
+	HTTPProxyListen     string                     `json:",omitempty" comment:"Optional local listen address for an HTTP CONNECT proxy into the\nnetwork, e.g. \"127.0.0.1:8080\", otherwise as for SOCKSListen."`
+	Forwards            []ForwardConfig            `json:",omitempty" comment:"Optional list of TCP or UDP port forwards, which require IfName to\nbe \"netstack\". Local forwards listen on a local address, e.g.\n\"127.0.0.1:8080\", and connect to an address in the network, e.g.\n\"[fa00::1]:80\" or \"<public key>.ruv:80\". Remote forwards listen\non this node's address, e.g. \":80\", and connect to a local address."`
+	DNSListen           string                     `json:",omitempty" comment:"Optional listen address for a DNS server that resolves names in the\nnetwork, e.g. \"127.0.0.1:5353\" or \"[<your address>]:53\". It answers\nAAAA queries for <public key>.ruv, where the key may be split into\nlabels of up to 63 characters, for <name>.ruv where a node has\nset \"dnsname\" in its NodeInfo, and for the names in DNSHosts.\nNodeInfo names are first come, first served and not authenticated,\nso any node can claim or block one. Use DNSHosts to bind names to\npublic keys where that matters. Leave empty to disable."`
+	DNSHosts            map[string]string          `json:",omitempty" comment:"Optional static names for the DNS server, as a { \"name\": \"address\" }\nmap, where the address is an IPv6 address or a public key."`
 	MulticastInterfaces []MulticastInterfaceConfig `comment:"Configuration for which interfaces multicast peer discovery should be\nenabled on. Regex is a regular expression which is matched against an\ninterface name, and interfaces use the first configuration that they\nmatch against. Beacon controls whether or not your node advertises its\npresence to others, whereas Listen controls whether or not your node\nlistens out for and tries to connect to other advertising nodes. See\nhttps://ruvcoindev.github.io/configurationref.html#multicastinterfaces\nfor more supported options."`
-	AllowedPublicKeys   []string                   `comment:"List of peer public keys to allow incoming peering connections\nfrom. If left empty/undefined then all connections will be allowed\nby default. This does not affect outgoing peerings, nor does it\naffect link-local peers discovered via multicast.\nWARNING: THIS IS NOT A FIREWALL and DOES NOT limit who can reach\nopen ports or services running on your machine!"`
-	IfName              string                     `comment:"Local network interface name for TUN adapter, or \"auto\" to select\nan interface automatically, or \"none\" to run without TUN."`
+	AllowedPublicKeys   []string                   `comment:"List of peer public keys to allow incoming peering connections\nfrom. If left empty/undefined then all connections will be allowed\nby default. This does not affect outgoing peerings, nor does it\naffect link-local peers discovered via multicast. Older nodes\nthat cannot prove they hold their key are refused when this is set.\nWARNING: THIS IS NOT A FIREWALL and DOES NOT limit who can reach\nopen ports or services running on your machine! Use FirewallRules\nfor that instead."`
+	FirewallInbound     string                     `json:",omitempty" comment:"What to do with packets from the network that no firewall rule\nmatches, either \"allow\" or \"deny\". Default is \"allow\". The firewall\nis only enabled if this, FirewallOutbound or FirewallRules is set."`
+	FirewallOutbound    string                     `json:",omitempty" comment:"What to do with packets to the network that no firewall rule\nmatches, either \"allow\" or \"deny\". Default is \"allow\"."`
+	FirewallRules       []FirewallRuleConfig       `json:",omitempty" comment:"Optional list of firewall rules for packets between the TUN adapter\nand the network, checked in order. Action is \"allow\" or \"deny\",\nDirection is \"in\" or \"out\". PublicKey is that of the remote node,\nSource and Destination are IPv6 or IPv4 prefixes, Protocol is\n\"tcp\", \"udp\", \"icmp\" (ICMPv6), \"icmpv4\" or a number and Ports\nis a destination port or range, e.g. \"8000-8999\". Empty fields\nmatch anything. Replies to allowed packets are always allowed,\ne.g. to allow SSH from a single node:\n{ Action: \"allow\", Direction: \"in\", PublicKey: \"<key>\", Protocol:\n\"tcp\", Ports: \"22\" } with FirewallInbound set to \"deny\"."`
+	PeerStateFile       string                     `json:",omitempty" comment:"Optional path to a file in which to remember peers added at runtime\nand the recent connection history of all peers. Peers added at\nruntime are restored after a restart and the most reliable peers\nare dialled first, with the others following a second apart.\nRelative paths are relative to the directory of the configuration\nfile."`
+	IfName              string                     `comment:"Local network interface name for TUN adapter, or \"auto\" to select\nan interface automatically, or \"none\" to run without TUN, or\n\"netstack\" to use a userspace network stack that needs no special\nprivileges."`
 	IfMTU               uint64                     `comment:"Maximum Transmission Unit (MTU) size for your local TUN interface.\nDefault is the largest supported size for your platform. The lowest\npossible value is 1280."`
+	IfQueues            uint64                     `json:",omitempty" comment:"Number of queues for the TUN interface on Linux, each read and\nwritten in parallel, which can raise throughput on machines with\nseveral cores. Default is 1."`
+	ExtraInterfaces     []ExtraInterfaceConfig     `json:",omitempty" comment:"Optional list of further TUN interfaces, each for the packets that\nmatch it, so that host routing and firewalls can tell them apart by\ninterface. Destinations picks packets to this node's \"address\", to\nits \"subnet\" or to \"routed\" LocalSubnets, and PublicKeys picks\npackets from the given nodes, e.g. { \"Name\": \"ruv1\", \"PublicKeys\":\n[ \"<key>\" ] }. Empty fields match any packet, and each packet goes\nto the first interface it matches, or else to IfName. These\ninterfaces have no address of their own. On Linux, routes to the\naddresses, subnets and RemoteSubnets of their PublicKeys are added\nautomatically, elsewhere interfaces must be set up by hand."`
+	LocalSubnets        []string                   `json:",omitempty" comment:"Optional list of IPv4 or IPv6 prefixes behind this node, e.g. a LAN\nor your public IPv6 /48, that other nodes can route to through this\nnode using RemoteSubnets. Packets from the network are accepted for\naddresses in these prefixes, and packets from them can be sent."`
+	RemoteSubnets       map[string]string          `json:",omitempty" comment:"Optional routes to IPv4 or IPv6 prefixes outside of the network, as\na { \"prefix\": \"public key\" } map, e.g. { \"10.0.0.0/8\": \"<key>\" }.\nPackets to these prefixes are sent to the node with that key, which\nmust have them in its LocalSubnets, and packets from them are only\naccepted from that node. On Linux the routes are added to the TUN\ninterface automatically, elsewhere they must be added by hand."`
+	SubnetInterface     string                     `json:",omitempty" comment:"Optional LAN interface to act as a gateway for, e.g. \"eth1\". The\nsubnet of this node is assigned to it and advertised with router\nadvertisements, so that hosts on the LAN get addresses in it along\nwith a route to the network. On Linux this also enables IPv6\nforwarding for all interfaces while running, and hosts must have\naccept_ra_rt_info_max_plen set to at least 7 to use the route,\nunless SubnetDefaultRouter is set. Requires a TUN adapter. Leave empty to disable."`
+	SubnetDefaultRouter bool                       `json:",omitempty" comment:"Also advertise this node as the default IPv6 router on the\nSubnetInterface, for hosts that ignore the route to the network, e.g.\nLinux hosts where accept_ra_rt_info_max_plen can't be changed. All\nIPv6 traffic from the LAN then goes to this node, so only use this\nif there is no other IPv6 router on the LAN."`
 	LogLookups          bool                       `json:",omitempty"`
 	NodeInfoPrivacy     bool                       `comment:"By default, nodeinfo contains some defaults including the platform,\narchitecture and Ruvchain version. These can help when surveying\nthe network and diagnosing network routing problems. Enabling\nnodeinfo privacy prevents this, so that only items specified in\n\"NodeInfo\" are sent back if specified."`
 	NodeInfo            map[string]interface{}     `comment:"Optional nodeinfo. This must be a { \"key\": \"value\", ... } map\nor set as null. This is entirely optional but, if set, is visible\nto the whole network on request."`
 }
 
+type AdminTokenConfig struct {
+	Token    string
+	ReadOnly bool `json:",omitempty"`
+}
+
+type AdminUserConfig struct {
+	User     string
+	ReadOnly bool `json:",omitempty"`
+}
+
+type ForwardConfig struct {
+	Type     string // "local" or "remote"
+	Protocol string `json:",omitempty"` // "tcp" or "udp", default "tcp"
+	Listen   string
+	Target   string
+}
+
+type ExtraInterfaceConfig struct {
+	Name         string
+	MTU          uint64   `json:",omitempty"` // Default is IfMTU
+	Destinations []string `json:",omitempty"` // "address", "subnet" or "routed"
+	PublicKeys   []string `json:",omitempty"`
+}
+
+type FirewallRuleConfig struct {
+	Action      string // "allow" or "deny"
+	Direction   string // "in" or "out"
+	PublicKey   string `json:",omitempty"`
+	Source      string `json:",omitempty"`
+	Destination string `json:",omitempty"`
+	Protocol    string `json:",omitempty"`
+	Ports       string `json:",omitempty"`
+}
+
+type AdminOperatorConfig struct {
+	PublicKey string
+	ReadOnly  bool `json:",omitempty"`
+}
+
 type MulticastInterfaceConfig struct {
 	Regex    string
 	Beacon   bool
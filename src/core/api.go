@@ -3,6 +3,7 @@ package core
 import (
 	"crypto/ed25519"
 	"encoding/json"
+	"fmt"
 	"net"
 	"net/url"
 	"sync/atomic"
@@ -37,6 +38,27 @@ type PeerInfo struct {
 	TXRate        uint64
 	Uptime        time.Duration
 	Latency       time.Duration
+	Metadata      map[string]string // From the transport, see ConnMetadata
+}
+
+type ListenerInfo struct {
+	URI       string
+	Scheme    string
+	Address   net.Addr
+	Interface string
+	Local     bool
+	Priority  uint8
+	Password  bool
+	Accepted  uint64
+	Active    uint64
+	// Limits set with the listener URL options, zero if unlimited.
+	MaxConns   uint64
+	MaxPerIP   uint64
+	AcceptRate float64
+	// Connections rejected because of each of the limits above.
+	RejectedMaxConns uint64
+	RejectedMaxPerIP uint64
+	RejectedRate     uint64
 }
 
 type TreeEntryInfo struct {
@@ -54,10 +76,12 @@ type PathEntryInfo struct {
 }
 
 type SessionInfo struct {
-	Key     ed25519.PublicKey
-	RXBytes uint64
-	TXBytes uint64
-	Uptime  time.Duration
+	Key            ed25519.PublicKey
+	RXBytes        uint64
+	TXBytes        uint64
+	Uptime         time.Duration
+	PathMTU        uint64 // Assumed to be MTU unless PathMTULearned
+	PathMTULearned bool
 }
 
 func (c *Core) GetSelf() SelfInfo {
@@ -92,6 +116,9 @@ func (c *Core) GetPeers() []PeerInfo {
 				peerinfo.RXRate = atomic.LoadUint64(&c.rxrate)
 				peerinfo.TXRate = atomic.LoadUint64(&c.txrate)
 				peerinfo.Uptime = time.Since(c.up)
+				if md, ok := c.Conn.(ConnMetadata); ok {
+					peerinfo.Metadata = md.Metadata()
+				}
 			}
 			if p, ok := conns[conn]; ok {
 				peerinfo.Key = p.Key
@@ -108,6 +135,45 @@ func (c *Core) GetPeers() []PeerInfo {
 	return peers
 }
 
+func (c *Core) GetListeners() []ListenerInfo {
+	var listeners []ListenerInfo
+	phony.Block(&c.links, func() {
+		for li := range c.links._listeners {
+			listeners = append(listeners, ListenerInfo{
+				URI:       li.uri,
+				Scheme:    li.scheme,
+				Address:   li.Addr(),
+				Interface: li.sintf,
+				Local:     li.local,
+				Priority:  li.options.priority,
+				Password:  len(li.options.password) > 0,
+				Accepted:  li.accepted.Load(),
+				Active:    li.limits.active(),
+
+				MaxConns:         li.limits.maxConns,
+				MaxPerIP:         li.limits.maxPerIP,
+				AcceptRate:       li.limits.rate,
+				RejectedMaxConns: li.limits.rejectedConns.Load(),
+				RejectedMaxPerIP: li.limits.rejectedPerIP.Load(),
+				RejectedRate:     li.limits.rejectedRate.Load(),
+			})
+		}
+	})
+	return listeners
+}
+
+// GetDialFailures returns the number of failed outbound connection attempts
+// since the node started, by error class, e.g. "dns", "refused" or "timeout".
+func (c *Core) GetDialFailures() map[string]uint64 {
+	failures := map[string]uint64{}
+	phony.Block(&c.links, func() {
+		for class, count := range c.links._dialFailures {
+			failures[class] = count
+		}
+	})
+	return failures
+}
+
 func (c *Core) GetTree() []TreeEntryInfo {
 	var trees []TreeEntryInfo
 	ts := c.PacketConn.PacketConn.Debug.GetTree()
@@ -145,11 +211,22 @@ func (c *Core) GetSessions() []SessionInfo {
 		info.RXBytes = s.RX
 		info.TXBytes = s.TX
 		info.Uptime = s.Uptime
+		var key keyArray
+		copy(key[:], s.Key)
+		info.PathMTU, info.PathMTULearned = c.proto.pathMTU.info(key)
 		sessions = append(sessions, info)
 	}
 	return sessions
 }
 
+// Subscribe registers a function that is called for every event on the node,
+// such as peerings coming up or going down. Events are delivered one at a
+// time and in order, so the function must not block. The returned function
+// cancels the subscription.
+func (c *Core) Subscribe(fn func(Event)) (unsubscribe func()) {
+	return c.events.subscribe(fn)
+}
+
 // Listen starts a new listener (either TCP or TLS). The input should be a url.URL
 // parsed from a string of the form e.g. "tcp://a.b.c.d:e". In the case of a
 // link-local address, the interface should be provided as the second argument.
@@ -164,6 +241,14 @@ func (c *Core) ListenLocal(u *url.URL, sintf string) (*Listener, error) {
 	return c.links.listen(u, sintf, true)
 }
 
+// RemoveListener stops a listener that was started with Listen. The listener
+// can be specified either by the URI it was started with, or by its scheme
+// and the address it is bound to, e.g. "tls://[::]:12345". Listeners started
+// with ListenLocal can't be removed this way.
+func (c *Core) RemoveListener(u *url.URL, sintf string) error {
+	return c.links.unlisten(u, sintf)
+}
+
 // Address gets the IPv6 address of the Ruvchain node. This is always a /128
 // address. The IPv6 address is only relevant when the node is operating as an
 // IP router and often is meaningless when embedded into an application, unless
@@ -200,14 +285,32 @@ func (c *Core) SetLogger(log Logger) {
 //
 // This adds the peer to the peer list, so that they will be called again if the
 // connection drops.
+//
+// If a peer state file is configured, peers added this way are remembered
+// and will be added again automatically when the node restarts.
 func (c *Core) AddPeer(u *url.URL, sintf string) error {
+	if err := c.links.add(u, sintf, linkTypePersistent); err != nil {
+		return err
+	}
+	c.peerState.addRuntime(u, sintf)
+	return nil
+}
+
+// AddConfiguredPeer adds a peer in the same way as AddPeer, but is intended
+// for peers that come from the node configuration, e.g. when reloading it.
+// These are not remembered in the peer state file as runtime peers.
+func (c *Core) AddConfiguredPeer(u *url.URL, sintf string) error {
 	return c.links.add(u, sintf, linkTypePersistent)
 }
 
 // RemovePeer removes a peer. The peer should be specified in URI format, see AddPeer.
 // The peer is not disconnected immediately.
 func (c *Core) RemovePeer(u *url.URL, sintf string) error {
-	return c.links.remove(u, sintf, linkTypePersistent)
+	if err := c.links.remove(u, sintf, linkTypePersistent); err != nil {
+		return err
+	}
+	c.peerState.removeRuntime(u, sintf)
+	return nil
 }
 
 // CallPeer calls a peer once. This should be specified in the peer URI format,
@@ -222,6 +325,43 @@ func (c *Core) CallPeer(u *url.URL, sintf string) error {
 	return c.links.add(u, sintf, linkTypeEphemeral)
 }
 
+// SetAllowedPublicKeys replaces the list of public keys that are allowed to
+// establish incoming peering connections. An empty list allows all keys. This
+// only affects new connections, existing peerings are not disconnected.
+func (c *Core) SetAllowedPublicKeys(keys []ed25519.PublicKey) {
+	phony.Block(c, func() {
+		c.config._allowedPublicKeys = make(map[[32]byte]struct{}, len(keys))
+		for _, key := range keys {
+			pk := [32]byte{}
+			copy(pk[:], key)
+			c.config._allowedPublicKeys[pk] = struct{}{}
+		}
+	})
+}
+
+// SetNodeInfo replaces the nodeinfo that is sent to remote nodes on request.
+func (c *Core) SetNodeInfo(nodeinfo NodeInfo, privacy NodeInfoPrivacy) error {
+	if err := c.proto.nodeinfo.setNodeInfo(nodeinfo, bool(privacy)); err != nil {
+		return err
+	}
+	phony.Block(c, func() {
+		c.config.nodeinfo = nodeinfo
+		c.config.nodeinfoPrivacy = privacy
+	})
+	return nil
+}
+
+// GetNodeInfo requests the nodeinfo of a remote node and waits up to the
+// timeout for the response.
+func (c *Core) GetNodeInfo(key ed25519.PublicKey, timeout time.Duration) (json.RawMessage, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key length")
+	}
+	var k keyArray
+	copy(k[:], key)
+	return c.proto.nodeinfo.getNodeInfo(k, timeout)
+}
+
 func (c *Core) PublicKey() ed25519.PublicKey {
 	return c.public
 }
@@ -232,30 +372,53 @@ type AddHandler interface {
 	AddHandler(name, desc string, args []string, handlerfunc AddHandlerFunc) error
 }
 
+// AddReadOnlyHandler is optionally implemented by an AddHandler that
+// restricts access to its handlers, so that handlers which don't change
+// anything can be made available to read-only users.
+type AddReadOnlyHandler interface {
+	AddReadOnlyHandler(name, desc string, args []string, handlerfunc AddHandlerFunc) error
+}
+
 type AddHandlerFunc func(json.RawMessage) (interface{}, error)
 
 // SetAdmin must be called after Init and before Start.
 // It sets the admin handler for NodeInfo and the Debug admin functions.
 func (c *Core) SetAdmin(a AddHandler) error {
-	if err := a.AddHandler(
+	addReadOnly := a.AddHandler
+	if ro, ok := a.(AddReadOnlyHandler); ok {
+		addReadOnly = ro.AddReadOnlyHandler
+	}
+	if err := addReadOnly(
 		"getNodeInfo", "Request nodeinfo from a remote node by its public key", []string{"key"},
 		c.proto.nodeinfo.nodeInfoAdminHandler,
 	); err != nil {
 		return err
 	}
+	if err := addReadOnly(
+		"getPeerState", "Show the stored peer history from the peer state file", []string{},
+		c.peerState.getPeerStateHandler,
+	); err != nil {
+		return err
+	}
 	if err := a.AddHandler(
+		"clearPeerState", "Clear the stored peer history and forget runtime peers", []string{},
+		c.peerState.clearPeerStateHandler,
+	); err != nil {
+		return err
+	}
+	if err := addReadOnly(
 		"debug_remoteGetSelf", "Debug use only", []string{"key"},
 		c.proto.getSelfHandler,
 	); err != nil {
 		return err
 	}
-	if err := a.AddHandler(
+	if err := addReadOnly(
 		"debug_remoteGetPeers", "Debug use only", []string{"key"},
 		c.proto.getPeersHandler,
 	); err != nil {
 		return err
 	}
-	if err := a.AddHandler(
+	if err := addReadOnly(
 		"debug_remoteGetTree", "Debug use only", []string{"key"},
 		c.proto.getTreeHandler,
 	); err != nil {
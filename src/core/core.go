@@ -34,6 +34,8 @@ type Core struct {
 	public       ed25519.PublicKey
 	links        links
 	proto        protoHandler
+	peerState    peerState
+	events       events
 	log          Logger
 	addPeerTimer *time.Timer
 	config       struct {
@@ -41,9 +43,11 @@ type Core struct {
 		//_peers             map[Peer]*linkInfo         // configurable after startup
 		_listeners         map[ListenAddress]struct{} // configurable after startup
 		peerFilter         func(ip net.IP) bool       // immutable after startup
-		nodeinfo           NodeInfo                   // immutable after startup
-		nodeinfoPrivacy    NodeInfoPrivacy            // immutable after startup
+		nodeinfo           NodeInfo                   // configurable after startup
+		nodeinfoPrivacy    NodeInfoPrivacy            // configurable after startup
 		_allowedPublicKeys map[[32]byte]struct{}      // configurable after startup
+		peerStateFile      PeerStateFile              // immutable after startup
+		transports         []CustomTransport          // immutable after startup
 	}
 	pathNotify func(ed25519.PublicKey)
 }
@@ -105,13 +109,22 @@ func New(cert *tls.Certificate, logger Logger, opts ...SetupOption) (*Core, erro
 		return nil, fmt.Errorf("error creating encryption: %w", err)
 	}
 	c.proto.init(c)
+	c.events.init(c)
 	if err := c.links.init(c); err != nil {
 		return nil, fmt.Errorf("error initialising links: %w", err)
 	}
+	if err := c.peerState.init(c, string(c.config.peerStateFile)); err != nil {
+		return nil, fmt.Errorf("error initialising peer state: %w", err)
+	}
+	// Now do the peers and listeners. If we have a peer state file then
+	// the peers that have been the most reliable in the past are dialled
+	// first, followed by any peers that were added at runtime before.
+	var peers []Peer
 	for _, opt := range opts {
-		switch opt.(type) {
-		case Peer, ListenAddress:
-			// Now do the peers and listeners.
+		switch v := opt.(type) {
+		case Peer:
+			peers = append(peers, v)
+		case ListenAddress:
 			if err = c._applyOption(opt); err != nil {
 				return nil, fmt.Errorf("failed to apply configuration option %T: %w", opt, err)
 			}
@@ -119,6 +132,31 @@ func New(cert *tls.Certificate, logger Logger, opts ...SetupOption) (*Core, erro
 			continue
 		}
 	}
+	c.peerState.sortPeers(peers)
+	for rank, peer := range peers {
+		u, err := url.Parse(peer.URI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply configuration option %T: unable to parse peering URI: %w", peer, err)
+		}
+		switch err = c.links.addAfter(u, peer.SourceInterface, linkTypePersistent, c.peerState.dialDelay(rank)); err {
+		case nil, ErrLinkAlreadyConfigured:
+			// The same peer may be configured more than once.
+		default:
+			return nil, fmt.Errorf("failed to apply configuration option %T: %w", peer, err)
+		}
+	}
+	for rank, peer := range c.peerState.runtimePeers() {
+		u, err := url.Parse(peer.URI)
+		if err != nil {
+			continue
+		}
+		switch err = c.links.addAfter(u, peer.SourceInterface, linkTypePersistent, c.peerState.dialDelay(len(peers)+rank)); err {
+		case nil, ErrLinkAlreadyConfigured:
+		default:
+			c.log.Warnf("Failed to restore peer %s: %s", u.Redacted(), err)
+		}
+	}
+	c.peerState.start()
 	if err := c.proto.nodeinfo.setNodeInfo(c.config.nodeinfo, bool(c.config.nodeinfoPrivacy)); err != nil {
 		return nil, fmt.Errorf("error setting node info: %w", err)
 	}
@@ -157,6 +195,9 @@ func (c *Core) Stop() {
 
 // This function is unsafe and should only be ran by the core actor.
 func (c *Core) _close() error {
+	if err := c.peerState.save(); err != nil {
+		c.log.Warnln("Failed to save peer state:", err)
+	}
 	c.cancel()
 	c.links.shutdown()
 	err := c.PacketConn.Close()
@@ -224,6 +265,7 @@ func (c *Core) WriteTo(p []byte, addr net.Addr) (n int, err error) {
 }
 
 func (c *Core) doPathNotify(key ed25519.PublicKey) {
+	c.events.emit(Event{Type: EventPathUpdate, Key: key})
 	c.Act(nil, func() {
 		if c.pathNotify != nil {
 			c.pathNotify(key)
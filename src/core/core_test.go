@@ -2,12 +2,26 @@ package core
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
 	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/Arceliar/phony"
 	"github.com/gologme/log"
 	"github.com/ruvcoindev/ruvchain/src/config"
 )
@@ -288,3 +302,984 @@ func TestAllowedPublicKeysLocal(t *testing.T) {
 	require_True(t, peers[0].Up)
 	require_True(t, peers[0].LastError == nil)
 }
+
+func TestListenerManagement(t *testing.T) {
+	cfg := config.GenerateConfig()
+	c, err := New(cfg.Certificate, nil)
+	require_NoError(t, err)
+	defer c.Stop()
+
+	u, err := url.Parse("tcp://127.0.0.1:0?priority=3&password=foo")
+	require_NoError(t, err)
+	l, err := c.Listen(u, "")
+	require_NoError(t, err)
+
+	listeners := c.GetListeners()
+	require_Equal(t, len(listeners), 1)
+	require_Equal(t, listeners[0].Scheme, "tcp")
+	require_Equal(t, listeners[0].Priority, 3)
+	require_True(t, listeners[0].Password)
+
+	// Removing by an unknown address should fail.
+	u, err = url.Parse("tcp://127.0.0.1:1")
+	require_NoError(t, err)
+	require_True(t, c.RemoveListener(u, "") == ErrLinkListenerNotFound)
+
+	// Removing by the bound address should stop the listener.
+	u, err = url.Parse("tcp://" + l.Addr().String())
+	require_NoError(t, err)
+	require_NoError(t, c.RemoveListener(u, ""))
+	select {
+	case <-l.Done():
+	case <-time.After(time.Second):
+		t.Fatal("listener was not stopped")
+	}
+	for i := 0; i < 10 && len(c.GetListeners()) > 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	require_Equal(t, len(c.GetListeners()), 0)
+}
+
+func TestPeerStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	cfg := config.GenerateConfig()
+	c, err := New(cfg.Certificate, nil, PeerStateFile(path))
+	require_NoError(t, err)
+
+	u, err := url.Parse("tcp://127.0.0.1:1?priority=2")
+	require_NoError(t, err)
+	require_NoError(t, c.AddPeer(u, ""))
+	c.Stop()
+
+	// The runtime peer should be saved including its options.
+	bs, err := os.ReadFile(path)
+	require_NoError(t, err)
+	require_True(t, bytes.Contains(bs, []byte(`"uri": "tcp://127.0.0.1:1?priority=2"`)))
+
+	// The runtime peer should be restored.
+	c, err = New(cfg.Certificate, nil, PeerStateFile(path))
+	require_NoError(t, err)
+	peers := c.GetPeers()
+	require_Equal(t, len(peers), 1)
+	require_Equal(t, peers[0].URI, "tcp://127.0.0.1:1")
+
+	// Once removed again, it should be forgotten.
+	require_NoError(t, c.RemovePeer(u, ""))
+	c.Stop()
+	c, err = New(cfg.Certificate, nil, PeerStateFile(path))
+	require_NoError(t, err)
+	defer c.Stop()
+	require_Equal(t, len(c.GetPeers()), 0)
+}
+
+func TestPeerStateOrdering(t *testing.T) {
+	var s peerState
+	s.path = "unused"
+	s._peers = map[linkInfo]*PeerStateEntry{
+		{uri: "tcp://b:1"}: {Uptime: time.Hour},
+		{uri: "tcp://c:1"}: {Uptime: time.Hour, Failures: 3},
+	}
+	peers := []Peer{{URI: "tcp://a:1"}, {URI: "tcp://c:1"}, {URI: "tcp://b:1"}}
+	s.sortPeers(peers)
+	require_Equal(t, peers[0].URI, "tcp://b:1")
+	require_Equal(t, peers[1].URI, "tcp://c:1")
+	require_Equal(t, peers[2].URI, "tcp://a:1")
+}
+
+// orderTransport records the hosts that are dialled. Dials never complete,
+// so that each host is only dialled once.
+type orderTransport chan string
+
+func (o orderTransport) Dial(ctx context.Context, u *url.URL, opts TransportOptions) (net.Conn, error) {
+	o <- u.Host
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (o orderTransport) Listen(ctx context.Context, u *url.URL, opts TransportOptions) (net.Listener, error) {
+	return nil, fmt.Errorf("not listening")
+}
+
+// The most reliable peers are dialled first, with the others following in
+// order of their rank.
+func TestPeerStateDialOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	bs, err := json.Marshal(&peerStateFile{Peers: []*PeerStateEntry{
+		{URI: "order://b:1", Uptime: time.Hour},
+		{URI: "order://c:1", Uptime: time.Hour, Failures: 3},
+	}})
+	require_NoError(t, err)
+	require_NoError(t, os.WriteFile(path, bs, 0600))
+
+	dials := make(orderTransport, 16)
+	cfg := config.GenerateConfig()
+	c, err := New(cfg.Certificate, nil,
+		PeerStateFile(path),
+		CustomTransport{Scheme: "order", Transport: dials},
+		Peer{URI: "order://a:1"}, Peer{URI: "order://c:1"}, Peer{URI: "order://b:1"},
+	)
+	require_NoError(t, err)
+	defer c.Stop()
+	for _, host := range []string{"b:1", "c:1", "a:1"} {
+		select {
+		case dialled := <-dials:
+			require_Equal(t, dialled, host)
+		case <-time.After(3 * peerStateDialStagger):
+			t.Fatalf("%s was not dialled", host)
+		}
+	}
+}
+
+func TestPeerStateHistory(t *testing.T) {
+	var s peerState
+	s._peers = map[linkInfo]*PeerStateEntry{}
+	s._runtime = map[linkInfo]string{}
+	info := linkInfo{uri: "tcp://a:1"}
+	start := time.Now()
+	update := func(entry PeerStateEntry) *PeerStateEntry {
+		s._update(map[linkInfo]PeerStateEntry{info: entry})
+		return s._peers[info]
+	}
+
+	// Two failures, then a connection that stays up for an hour over
+	// several updates, which should only be counted once.
+	update(PeerStateEntry{LastError: "refused", LastErrorTime: start})
+	update(PeerStateEntry{LastError: "refused", LastErrorTime: start.Add(time.Second)})
+	up := start.Add(time.Minute)
+	update(PeerStateEntry{LastUp: up, Uptime: time.Minute * 30, up: up})
+	e := update(PeerStateEntry{LastUp: up, Uptime: time.Hour, up: up})
+	require_Equal(t, e.Uptime, time.Hour)
+	require_Equal(t, e.Failures, uint64(1))
+
+	// Another failure and a new connection add to the history rather than
+	// replacing it.
+	update(PeerStateEntry{LastError: "reset", LastErrorTime: start.Add(time.Hour * 2)})
+	require_Equal(t, s._peers[info].Failures, uint64(2))
+	up = start.Add(time.Hour * 3)
+	e = update(PeerStateEntry{LastUp: up, Uptime: time.Minute, up: up})
+	require_Equal(t, e.Uptime, time.Hour+time.Minute)
+	require_Equal(t, e.Failures, uint64(1))
+}
+
+// Nodes that don't prove possession of their key should still be able to
+// peer with us in legacy mode.
+func TestLegacyHandshake(t *testing.T) {
+	cfg := config.GenerateConfig()
+	c, err := New(cfg.Certificate, nil)
+	require_NoError(t, err)
+	defer c.Stop()
+	u, err := url.Parse("tcp://127.0.0.1:0")
+	require_NoError(t, err)
+	l, err := c.Listen(u, "")
+	require_NoError(t, err)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require_NoError(t, err)
+	defer conn.Close()
+	pk, sk, err := ed25519.GenerateKey(nil)
+	require_NoError(t, err)
+	legacy := version_metadata{
+		majorVer:  ProtocolVersionMajor,
+		minorVer:  ProtocolVersionMinorCompatible,
+		publicKey: pk,
+	}
+	bs, err := legacy.encode(sk, nil)
+	require_NoError(t, err)
+	_, err = conn.Write(bs)
+	require_NoError(t, err)
+	var remote version_metadata
+	require_NoError(t, remote.decode(conn, nil))
+	require_True(t, remote.check())
+	require_True(t, remote.supportsProof())
+
+	for i := 0; i < 20; i++ {
+		for _, p := range c.GetPeers() {
+			if p.Up && bytes.Equal(p.Key, pk) {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("legacy peer did not come up")
+}
+
+// Nodes that don't prove possession of their key must not be accepted when
+// AllowedPublicKeys is set, even if their key is allowed, since the key in
+// their metadata could have come from anyone.
+func TestLegacyHandshakeAllowedKeys(t *testing.T) {
+	pk, sk, err := ed25519.GenerateKey(nil)
+	require_NoError(t, err)
+	cfg := config.GenerateConfig()
+	c, err := New(cfg.Certificate, nil, AllowedPublicKey(pk))
+	require_NoError(t, err)
+	defer c.Stop()
+	u, err := url.Parse("tcp://127.0.0.1:0")
+	require_NoError(t, err)
+	l, err := c.Listen(u, "")
+	require_NoError(t, err)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require_NoError(t, err)
+	defer conn.Close()
+	legacy := version_metadata{
+		majorVer:  ProtocolVersionMajor,
+		minorVer:  ProtocolVersionMinorCompatible,
+		publicKey: pk,
+	}
+	bs, err := legacy.encode(sk, nil)
+	require_NoError(t, err)
+	_, err = conn.Write(bs)
+	require_NoError(t, err)
+	var remote version_metadata
+	require_NoError(t, remote.decode(conn, nil))
+
+	// The node should hang up rather than bring the peering up.
+	require_NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second*5)))
+	_, err = conn.Read(make([]byte, 1))
+	require_True(t, errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET))
+	require_Equal(t, len(c.GetPeers()), 0)
+}
+
+func TestEvents(t *testing.T) {
+	cfgA, cfgB := config.GenerateConfig(), config.GenerateConfig()
+	nodeA, err := New(cfgA.Certificate, nil)
+	require_NoError(t, err)
+	defer nodeA.Stop()
+	nodeB, err := New(cfgB.Certificate, nil)
+	require_NoError(t, err)
+	defer nodeB.Stop()
+
+	events := make(chan Event, 64)
+	unsubscribe := nodeA.Subscribe(func(ev Event) {
+		select {
+		case events <- ev:
+		default:
+		}
+	})
+	defer unsubscribe()
+	expect := func(typ EventType) Event {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ev := <-events:
+				if ev.Type == typ {
+					return ev
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %s event", typ)
+			}
+		}
+	}
+
+	u, err := url.Parse("tcp://127.0.0.1:0")
+	require_NoError(t, err)
+	l, err := nodeA.Listen(u, "")
+	require_NoError(t, err)
+	expect(EventListenerStart)
+
+	u, err = url.Parse("tcp://" + l.Addr().String())
+	require_NoError(t, err)
+	require_NoError(t, nodeB.CallPeer(u, ""))
+	ev := expect(EventPeerUp)
+	require_True(t, ev.Inbound)
+	require_True(t, bytes.Equal(ev.Key, nodeB.PublicKey()))
+
+	l.Cancel()
+	expect(EventListenerStop)
+	nodeB.Stop()
+	ev = expect(EventPeerDown)
+	require_True(t, bytes.Equal(ev.Key, nodeB.PublicKey()))
+}
+
+func TestRemoteAdmin(t *testing.T) {
+	nodeA, nodeB := CreateAndConnectTwo(t, false)
+	defer nodeA.Stop()
+	defer nodeB.Stop()
+	// Protocol traffic is only handled while something is reading.
+	for _, n := range []*Core{nodeA, nodeB} {
+		go func(n *Core) {
+			buf := make([]byte, 65535)
+			for {
+				if _, _, err := n.ReadFrom(buf); err != nil {
+					return
+				}
+			}
+		}(n)
+	}
+
+	operator := func(from ed25519.PublicKey) bool {
+		return from.Equal(nodeA.public)
+	}
+	nodeB.SetRemoteAdminHandler(operator, func(from ed25519.PublicKey, name string, args json.RawMessage) (interface{}, error) {
+		if !from.Equal(nodeA.public) {
+			return nil, fmt.Errorf("unexpected sender")
+		}
+		if name == "fail" {
+			return nil, fmt.Errorf("failed")
+		}
+		return map[string]string{"request": name, "arguments": string(args)}, nil
+	})
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+
+	res, err := nodeA.RemoteAdmin(nodeB.public, "echo", json.RawMessage(`{"a":"b"}`))
+	require_NoError(t, err)
+	require_Equal(t, string(res), `{"arguments":"{\"a\":\"b\"}","request":"echo"}`)
+
+	_, err = nodeA.RemoteAdmin(nodeB.public, "fail", nil)
+	require_True(t, err != nil && err.Error() == "failed")
+
+	// A request that has already been seen must not be handled again.
+	req := remoteAdminRequest{
+		Target: nodeB.public,
+		Time:   time.Now().UnixNano(),
+		Nonce:  make([]byte, remoteAdminNonceSize),
+	}
+	phony.Block(&nodeB.proto.remoteAdmin, func() {
+		require_NoError(t, nodeB.proto.remoteAdmin._checkReplay(&req))
+		require_True(t, nodeB.proto.remoteAdmin._checkReplay(&req) != nil)
+		req.Nonce[0]++
+		req.Time = time.Now().Add(-2 * remoteAdminMaxSkew).UnixNano()
+		require_True(t, nodeB.proto.remoteAdmin._checkReplay(&req) != nil)
+		req.Time = time.Now().UnixNano()
+		req.Target = nodeA.public
+		require_True(t, nodeB.proto.remoteAdmin._checkReplay(&req) != nil)
+	})
+
+	// Requests from nodes that aren't operators are dropped before their
+	// nonces are remembered.
+	pk, sk, err := ed25519.GenerateKey(nil)
+	require_NoError(t, err)
+	req.Target, req.Time = nodeB.public, time.Now().UnixNano()
+	req.Nonce[0]++
+	body, err := json.Marshal(req)
+	require_NoError(t, err)
+	bs := append(ed25519.Sign(sk, remoteAdminTranscript(body)), body...)
+	phony.Block(&nodeB.proto.remoteAdmin, func() {
+		nonces := len(nodeB.proto.remoteAdmin._nonces)
+		nodeB.proto.remoteAdmin._handleRequest(keyArray(pk), bs)
+		require_Equal(t, len(nodeB.proto.remoteAdmin._nonces), nonces)
+	})
+}
+
+func TestPathMTU(t *testing.T) {
+	nodeA, nodeB := CreateAndConnectTwo(t, false)
+	defer nodeA.Stop()
+	defer nodeB.Stop()
+	// Protocol traffic is only handled while something is reading.
+	for _, n := range []*Core{nodeA, nodeB} {
+		go func(n *Core) {
+			buf := make([]byte, 65535)
+			for {
+				if _, _, err := n.ReadFrom(buf); err != nil {
+					return
+				}
+			}
+		}(n)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+
+	// Until the probes have been acknowledged, the MTU of the core is assumed.
+	require_Equal(t, nodeA.PathMTU(nodeB.public), nodeA.MTU())
+	for i := 0; i < 50; i++ {
+		if _, learned := nodeA.proto.pathMTU.info(keyArray(nodeB.public)); learned {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	mtu, learned := nodeA.proto.pathMTU.info(keyArray(nodeB.public))
+	require_True(t, learned)
+	require_Equal(t, mtu, nodeA.MTU())
+
+	var found bool
+	for _, s := range nodeA.GetSessions() {
+		if s.Key.Equal(nodeB.public) {
+			found = true
+			require_True(t, s.PathMTULearned)
+			require_Equal(t, s.PathMTU, nodeA.MTU())
+		}
+	}
+	require_True(t, found)
+}
+
+// TestPathMTUDropped checks what is learned from a path that drops probes
+// above a size. The rounds of probes are played out by hand, as the remote
+// node would acknowledge them, with the timeouts brought forward.
+func TestPathMTUDropped(t *testing.T) {
+	nodeA, nodeB := CreateAndConnectTwo(t, false)
+	defer nodeA.Stop()
+	defer nodeB.Stop()
+	m := &nodeA.proto.pathMTU
+	pub, _, err := ed25519.GenerateKey(nil)
+	require_NoError(t, err)
+	key := keyArray(pub)
+
+	// round acknowledges the probes of the round in progress that get
+	// through, ends the round if that hasn't, and returns the probed sizes.
+	round := func(limit uint64) []uint64 {
+		m.mutex.Lock()
+		info := m.paths[key]
+		id, sizes := info.round, info.sizes
+		m.mutex.Unlock()
+		require_True(t, id != 0)
+		require_True(t, len(sizes) <= pathMTUProbesPerRound)
+		for _, size := range sizes {
+			if size <= limit {
+				bs := make([]byte, 8)
+				binary.BigEndian.PutUint32(bs, id)
+				binary.BigEndian.PutUint32(bs[4:], uint32(size))
+				m.handleAck(key, bs)
+			}
+		}
+		m.mutex.Lock()
+		if info.round == id {
+			m._finish(info)
+		}
+		m.mutex.Unlock()
+		return sizes
+	}
+	probe := func() {
+		m.mutex.Lock()
+		m.paths[key].probed = time.Now().Add(-pathMTURefresh - time.Second)
+		m.mutex.Unlock()
+		m.get(key)
+	}
+	check := func(mtu uint64, learned bool) {
+		t.Helper()
+		gotMTU, gotLearned := m.info(key)
+		require_Equal(t, gotMTU, mtu)
+		require_Equal(t, gotLearned, learned)
+	}
+
+	// The first rounds climb for as long as everything gets through, and a
+	// smaller MTU is only taken once the round that found it is repeated.
+	require_Equal(t, m.get(key), nodeA.MTU())
+	require_Equal(t, fmt.Sprint(round(9000)), "[1280 1500 4096]")
+	require_Equal(t, fmt.Sprint(round(9000)), "[9000 16384 32768]")
+	check(nodeA.MTU(), false)
+	require_Equal(t, fmt.Sprint(round(9000)), "[9000 16384 32768]")
+	check(9000, true)
+	require_Equal(t, m.get(key), uint64(9000))
+
+	// Later rounds only check the learned MTU, the smallest size and the next
+	// size up. Nothing getting through at all keeps the MTU.
+	probe()
+	require_Equal(t, fmt.Sprint(round(9000)), "[1280 9000 16384]")
+	check(9000, true)
+	probe()
+	round(0)
+	check(9000, true)
+
+	// A path that carries less than it used to falls back to the smallest
+	// size and then climbs again.
+	probe()
+	round(1500)
+	check(9000, true)
+	round(1500)
+	check(1280, true)
+	probe()
+	require_Equal(t, fmt.Sprint(round(1500)), "[1280 1500]")
+	require_Equal(t, fmt.Sprint(round(1500)), "[4096 9000 16384]")
+	check(1500, true)
+
+	// Acknowledgements of rounds that are over are ignored.
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint32(bs, m.nextID)
+	binary.BigEndian.PutUint32(bs[4:], 9000)
+	m.handleAck(key, bs)
+	check(1500, true)
+}
+
+// labelTransport carries links over TCP, with a label that must be given in
+// the URL and that is reported as metadata of the connections.
+type labelTransport struct{}
+
+type labelConn struct {
+	net.Conn
+	label string
+}
+
+func (c *labelConn) Metadata() map[string]string {
+	return map[string]string{"label": c.label}
+}
+
+type labelListener struct {
+	net.Listener
+	label string
+}
+
+func (l *labelListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &labelConn{Conn: conn, label: l.label}, nil
+}
+
+func (labelTransport) ParseOptions(u *url.URL) (any, error) {
+	label := u.Query().Get("label")
+	if label == "" {
+		return nil, fmt.Errorf("no label given")
+	}
+	return label, nil
+}
+
+func (labelTransport) Dial(ctx context.Context, u *url.URL, opts TransportOptions) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &labelConn{Conn: conn, label: opts.Options.(string)}, nil
+}
+
+func (labelTransport) Listen(ctx context.Context, u *url.URL, opts TransportOptions) (net.Listener, error) {
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &labelListener{Listener: listener, label: opts.Options.(string)}, nil
+}
+
+func TestCustomTransport(t *testing.T) {
+	cfgA, cfgB := config.GenerateConfig(), config.GenerateConfig()
+	require_NoError(t, cfgA.GenerateSelfSignedCertificate())
+	require_NoError(t, cfgB.GenerateSelfSignedCertificate())
+	logger := GetLoggerWithPrefix("", false)
+
+	// A transport given as an option can be used by the configured listeners.
+	nodeA, err := New(cfgA.Certificate, logger,
+		CustomTransport{Scheme: "Label", Transport: labelTransport{}},
+		ListenAddress("label://127.0.0.1:0?label=a"),
+	)
+	require_NoError(t, err)
+	defer nodeA.Stop()
+	listeners := nodeA.GetListeners()
+	require_Equal(t, len(listeners), 1)
+	require_Equal(t, listeners[0].Scheme, "label")
+
+	nodeB, err := New(cfgB.Certificate, logger)
+	require_NoError(t, err)
+	defer nodeB.Stop()
+	u, err := url.Parse("label://" + listeners[0].Address.String() + "?label=b")
+	require_NoError(t, err)
+	require_Equal[error](t, nodeB.CallPeer(u, ""), ErrLinkUnrecognisedSchema)
+	require_NoError(t, nodeB.RegisterTransport("label", labelTransport{}))
+	require_True(t, nodeB.RegisterTransport("label", labelTransport{}) != nil)
+	require_True(t, nodeB.RegisterTransport("tcp", labelTransport{}) != nil)
+
+	// Options are checked by the transport when the peer is added.
+	bad, err := url.Parse("label://" + listeners[0].Address.String())
+	require_NoError(t, err)
+	require_True(t, nodeB.CallPeer(bad, "") != nil)
+
+	require_NoError(t, nodeB.CallPeer(u, ""))
+	for _, node := range []struct {
+		core  *Core
+		label string
+	}{{nodeA, "a"}, {nodeB, "b"}} {
+		var peers []PeerInfo
+		for i := 0; i < 50; i++ {
+			if peers = node.core.GetPeers(); len(peers) == 1 && peers[0].Up {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		require_Equal(t, len(peers), 1)
+		require_True(t, peers[0].Up)
+		require_Equal(t, peers[0].Metadata["label"], node.label)
+	}
+}
+
+// socksServer starts a minimal SOCKS5 server without authentication, which
+// only supports BIND and UDP ASSOCIATE requests, and returns its address.
+func socksServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require_NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func serveSOCKS(conn net.Conn) {
+	defer conn.Close()
+	var hello [2]byte
+	if _, err := io.ReadFull(conn, hello[:]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, hello[1])); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksAuthNone}); err != nil {
+		return
+	}
+	var req [3]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return
+	}
+	if _, err := readSOCKSAddr(conn); err != nil {
+		return
+	}
+	reply := func(addr net.Addr) error {
+		a, err := parseSOCKSAddr(addr.String())
+		if err != nil {
+			return err
+		}
+		b, err := appendSOCKSAddr([]byte{socksVersion, 0, 0}, a)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Write(b)
+		return err
+	}
+	switch req[1] {
+	case socksCmdBind:
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return
+		}
+		defer listener.Close()
+		if reply(listener.Addr()) != nil {
+			return
+		}
+		peer, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer peer.Close()
+		if reply(peer.RemoteAddr()) != nil {
+			return
+		}
+		go func() {
+			_, _ = io.Copy(peer, conn)
+			_ = peer.Close()
+		}()
+		_, _ = io.Copy(conn, peer)
+	case socksCmdUDPAssociate:
+		relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return
+		}
+		defer relay.Close()
+		if reply(relay.LocalAddr()) != nil {
+			return
+		}
+		go func() {
+			var client *net.UDPAddr
+			buf := make([]byte, 65535)
+			for {
+				n, from, err := relay.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				if client == nil {
+					client = from
+				}
+				if from.String() == client.String() {
+					r := &sliceReader{b: buf[3:n]}
+					to, err := readSOCKSAddr(r)
+					if err != nil {
+						continue
+					}
+					if dst, err := net.ResolveUDPAddr("udp", to.String()); err == nil {
+						_, _ = relay.WriteToUDP(r.b, dst)
+					}
+				} else if a, err := parseSOCKSAddr(from.String()); err == nil {
+					if b, err := appendSOCKSAddr([]byte{0, 0, 0}, a); err == nil {
+						_, _ = relay.WriteToUDP(append(b, buf[:n]...), client)
+					}
+				}
+			}
+		}()
+		_, _ = io.Copy(io.Discard, conn)
+	default:
+		_, _ = conn.Write([]byte{socksVersion, 7, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	}
+}
+
+func TestSOCKS(t *testing.T) {
+	proxy := socksServer(t)
+	logger := GetLoggerWithPrefix("", false)
+	var nodes [3]*Core
+	for i := range nodes {
+		cfg := config.GenerateConfig()
+		require_NoError(t, cfg.GenerateSelfSignedCertificate())
+		var opts []SetupOption
+		switch i {
+		case 0:
+			opts = append(opts, ListenAddress("socks://"+proxy))
+		case 1:
+			opts = append(opts, ListenAddress("quic://127.0.0.1:0"))
+		}
+		node, err := New(cfg.Certificate, logger, opts...)
+		require_NoError(t, err)
+		defer node.Stop()
+		nodes[i] = node
+	}
+	waitPeers := func(node *Core, n int) {
+		t.Helper()
+		var up int
+		for i := 0; i < 50; i++ {
+			up = 0
+			for _, p := range node.GetPeers() {
+				if p.Up {
+					up++
+				}
+			}
+			if up == n {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Fatalf("expected %d peers to be up, not %d", n, up)
+	}
+
+	// The first node accepts a peering through the SOCKS server, which
+	// listens for it on another port, again after each peer connects.
+	listeners := nodes[0].GetListeners()
+	require_Equal(t, len(listeners), 1)
+	u, err := url.Parse("tcp://" + listeners[0].Address.String())
+	require_NoError(t, err)
+	require_NoError(t, nodes[1].CallPeer(u, ""))
+	waitPeers(nodes[0], 1)
+
+	// The third node peers over QUIC through the UDP relay of the server.
+	listeners = nodes[1].GetListeners()
+	require_Equal(t, len(listeners), 1)
+	u, err = url.Parse("socksquic://" + proxy + "/" + listeners[0].Address.String())
+	require_NoError(t, err)
+	require_NoError(t, nodes[2].CallPeer(u, ""))
+	waitPeers(nodes[2], 1)
+	waitPeers(nodes[1], 2)
+}
+
+// httpProxyServer starts a minimal HTTP proxy that only supports CONNECT
+// requests with the given Basic auth credentials, and returns its address.
+func httpProxyServer(t *testing.T, user, password string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require_NoError(t, err)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+			if r.Header.Get("Proxy-Authorization") != auth {
+				w.WriteHeader(http.StatusProxyAuthRequired)
+				return
+			}
+			if r.Method != http.MethodConnect {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			target, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer target.Close()
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+			_ = rw.Flush()
+			go func() {
+				_, _ = io.Copy(target, rw)
+				_ = target.Close()
+			}()
+			_, _ = io.Copy(conn, target)
+		}),
+	}
+	go server.Serve(listener) // nolint:errcheck
+	t.Cleanup(func() { _ = server.Close() })
+	return listener.Addr().String()
+}
+
+func TestHTTPProxy(t *testing.T) {
+	proxy := httpProxyServer(t, "user", "secret")
+	logger := GetLoggerWithPrefix("", false)
+	var nodes [3]*Core
+	for i := range nodes {
+		cfg := config.GenerateConfig()
+		require_NoError(t, cfg.GenerateSelfSignedCertificate())
+		var opts []SetupOption
+		if i == 0 {
+			opts = append(opts, ListenAddress("tcp://127.0.0.1:0"), ListenAddress("tls://127.0.0.1:0"))
+		}
+		node, err := New(cfg.Certificate, logger, opts...)
+		require_NoError(t, err)
+		defer node.Stop()
+		nodes[i] = node
+	}
+	listeners := make(map[string]string)
+	for _, listener := range nodes[0].GetListeners() {
+		listeners[listener.Scheme] = listener.Address.String()
+	}
+
+	// A target is needed, and is checked when the peer is added.
+	u, err := url.Parse("httpproxy://" + proxy)
+	require_NoError(t, err)
+	require_True(t, nodes[1].CallPeer(u, "") != nil)
+
+	// A wrong password is refused by the proxy.
+	u, err = url.Parse("httpproxy://user:wrong@" + proxy + "/" + listeners["tcp"])
+	require_NoError(t, err)
+	_, err = nodes[1].links._transports["httpproxy"].Dial(context.Background(), u, TransportOptions{
+		Options: linkHTTPProxyOptions{target: listeners["tcp"]},
+	})
+	require_True(t, err != nil)
+
+	for i, u := range []string{
+		"httpproxy://user:secret@" + proxy + "/" + listeners["tcp"],
+		"httpproxytls://user:secret@" + proxy + "/" + listeners["tls"],
+	} {
+		pu, err := url.Parse(u)
+		require_NoError(t, err)
+		require_NoError(t, nodes[i+1].CallPeer(pu, ""))
+	}
+	for i := 0; i < 50; i++ {
+		if len(nodes[0].GetPeers()) == 2 && len(nodes[1].GetPeers()) == 1 && len(nodes[2].GetPeers()) == 1 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require_Equal(t, len(nodes[0].GetPeers()), 2)
+	for _, node := range nodes[1:] {
+		peers := node.GetPeers()
+		require_Equal(t, len(peers), 1)
+		require_True(t, peers[0].Up)
+	}
+}
+
+func TestWebSocketOptions(t *testing.T) {
+	logger := GetLoggerWithPrefix("", false)
+	var nodes [2]*Core
+	for i := range nodes {
+		cfg := config.GenerateConfig()
+		require_NoError(t, cfg.GenerateSelfSignedCertificate())
+		var opts []SetupOption
+		if i == 0 {
+			opts = append(opts, ListenAddress("ws://127.0.0.1:0/peering/?origin=https://app.example&trustedproxy=127.0.0.0/8"))
+		}
+		node, err := New(cfg.Certificate, logger, opts...)
+		require_NoError(t, err)
+		defer node.Stop()
+		nodes[i] = node
+	}
+	addr := nodes[0].GetListeners()[0].Address.String()
+
+	// Only the configured path is served, with health checks below it.
+	for path, status := range map[string]int{
+		"/peering/health": http.StatusOK,
+		"/health":         http.StatusNotFound,
+		"/other":          http.StatusNotFound,
+	} {
+		resp, err := http.Get("http://" + addr + path)
+		require_NoError(t, err)
+		_ = resp.Body.Close()
+		require_Equal(t, resp.StatusCode, status)
+	}
+
+	// Browsers from other origins are refused.
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/peering", nil)
+	require_NoError(t, err)
+	req.Header.Set("Origin", "https://other.example")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := http.DefaultClient.Do(req)
+	require_NoError(t, err)
+	_ = resp.Body.Close()
+	require_Equal(t, resp.StatusCode, http.StatusForbidden)
+
+	// Headers and tokens are sent when dialling, rather than in the URL.
+	headers := make(chan *http.Request, 1)
+	recorder := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case headers <- r:
+			default:
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+		}),
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require_NoError(t, err)
+	go recorder.Serve(listener) // nolint:errcheck
+	defer recorder.Close()
+	u, err := url.Parse("ws://" + listener.Addr().String() + "/?token=secret&header=X-Test:%20value")
+	require_NoError(t, err)
+	require_NoError(t, nodes[1].CallPeer(u, ""))
+	select {
+	case r := <-headers:
+		require_Equal(t, r.Header.Get("Authorization"), "Bearer secret")
+		require_Equal(t, r.Header.Get("X-Test"), "value")
+		require_Equal(t, r.URL.RawQuery, "")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request received")
+	}
+	u, err = url.Parse("ws://" + addr + "/?header=bad")
+	require_NoError(t, err)
+	require_True(t, nodes[1].CallPeer(u, "") != nil)
+
+	// The client address given by a trusted reverse proxy is reported.
+	u, err = url.Parse("ws://" + addr + "/peering?header=X-Forwarded-For:192.0.2.1,%20127.0.0.2")
+	require_NoError(t, err)
+	require_NoError(t, nodes[1].CallPeer(u, ""))
+	var peers []PeerInfo
+	for i := 0; i < 50; i++ {
+		if peers = nodes[0].GetPeers(); len(peers) == 1 && peers[0].Up {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require_Equal(t, len(peers), 1)
+	require_True(t, strings.Contains(peers[0].URI, "192.0.2.1"))
+}
+
+type testAddHandler map[string]bool // Handler names, true if read-only
+
+func (h testAddHandler) AddHandler(name, desc string, args []string, handlerfunc AddHandlerFunc) error {
+	h[name] = false
+	return nil
+}
+
+type testAddReadOnlyHandler struct{ testAddHandler }
+
+func (h testAddReadOnlyHandler) AddReadOnlyHandler(name, desc string, args []string, handlerfunc AddHandlerFunc) error {
+	h.testAddHandler[name] = true
+	return nil
+}
+
+// Admin handlers that only implement AddHandler get every handler, and
+// those that also implement AddReadOnlyHandler are told which are read-only.
+func TestSetAdmin(t *testing.T) {
+	cfg := config.GenerateConfig()
+	c, err := New(cfg.Certificate, nil)
+	require_NoError(t, err)
+	defer c.Stop()
+
+	plain := testAddHandler{}
+	require_NoError(t, c.SetAdmin(plain))
+	require_True(t, len(plain) > 0)
+	for name, readOnly := range plain {
+		require_True(t, !readOnly && name != "")
+	}
+
+	ro := testAddReadOnlyHandler{testAddHandler{}}
+	require_NoError(t, c.SetAdmin(ro))
+	require_Equal(t, len(ro.testAddHandler), len(plain))
+	require_True(t, ro.testAddHandler["getNodeInfo"])
+	require_True(t, !ro.testAddHandler["clearPeerState"])
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"time"
+
+	"github.com/Arceliar/phony"
+)
+
+// How often sessions are checked for changes while there are subscribers.
+// Ironwood doesn't tell us when sessions open or close, so unlike the other
+// events these are found by comparing the sessions against the last check.
+const eventSessionInterval = time.Second
+
+type EventType string
+
+const (
+	EventPeerUp        EventType = "peer_up"
+	EventPeerDown      EventType = "peer_down"
+	EventSessionOpen   EventType = "session_open"
+	EventSessionClose  EventType = "session_close"
+	EventPathUpdate    EventType = "path_update"
+	EventListenerStart EventType = "listener_start"
+	EventListenerStop  EventType = "listener_stop"
+)
+
+// Event describes a change on the node, see Core.Subscribe.
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Key     ed25519.PublicKey // Remote node for peer, session and path events
+	URI     string            // Peering or listener URI
+	Inbound bool              // Whether a peering is inbound
+	Error   error             // Why a peering went down, if known
+}
+
+type eventSubscriber struct {
+	fn func(Event)
+}
+
+// events delivers events to subscribers in the order in which they happen.
+type events struct {
+	phony.Inbox
+	core         *Core
+	_subscribers map[*eventSubscriber]struct{}
+	_sessions    map[keyArray]struct{} // nil until the first check
+	_polling     bool
+}
+
+func (e *events) init(c *Core) {
+	e.core = c
+	e._subscribers = make(map[*eventSubscriber]struct{})
+}
+
+// emit sends the event to all current subscribers.
+func (e *events) emit(ev Event) {
+	ev.Time = time.Now()
+	e.Act(nil, func() {
+		e._emit(ev)
+	})
+}
+
+func (e *events) _emit(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	for sub := range e._subscribers {
+		sub.fn(ev)
+	}
+}
+
+func (e *events) subscribe(fn func(Event)) func() {
+	sub := &eventSubscriber{fn: fn}
+	phony.Block(e, func() {
+		e._subscribers[sub] = struct{}{}
+		if !e._polling {
+			e._polling = true
+			e._sessions = nil
+			e.Act(nil, e._pollSessions)
+		}
+	})
+	return func() {
+		phony.Block(e, func() {
+			delete(e._subscribers, sub)
+		})
+	}
+}
+
+// _pollSessions compares the open sessions against the last check and
+// emits events for any that have opened or closed since. It stops once
+// there are no subscribers left.
+func (e *events) _pollSessions() {
+	select {
+	case <-e.core.ctx.Done():
+		e._polling = false
+		return
+	default:
+	}
+	if len(e._subscribers) == 0 {
+		e._polling = false
+		return
+	}
+	go func() {
+		sessions := e.core.GetSessions()
+		e.Act(nil, func() {
+			current := make(map[keyArray]struct{}, len(sessions))
+			for _, s := range sessions {
+				var key keyArray
+				copy(key[:], s.Key)
+				current[key] = struct{}{}
+				if _, ok := e._sessions[key]; !ok && e._sessions != nil {
+					e._emit(Event{Type: EventSessionOpen, Key: s.Key})
+				}
+			}
+			for key := range e._sessions {
+				if _, ok := current[key]; !ok {
+					e._emit(Event{Type: EventSessionClose, Key: ed25519.PublicKey(append([]byte(nil), key[:]...))})
+				}
+			}
+			e._sessions = current
+			time.AfterFunc(eventSessionInterval, func() {
+				e.Act(nil, e._pollSessions)
+			})
+		})
+	}()
+}
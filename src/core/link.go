@@ -3,6 +3,9 @@ package core
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -12,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/Arceliar/phony"
@@ -32,22 +36,14 @@ const minimumBackoffLimit = time.Second * 30
 
 type links struct {
 	phony.Inbox
-	core  *Core
-	tcp   *linkTCP   // TCP interface support
-	tls   *linkTLS   // TLS interface support
-	unix  *linkUNIX  // UNIX interface support
-	socks *linkSOCKS // SOCKS interface support
-	quic  *linkQUIC  // QUIC interface support
-	ws    *linkWS    // WS interface support
-	wss   *linkWSS   // WSS interface support
+	core *Core
+	tcp  *linkTCP // TCP interface support, also used by other transports
+	tls  *linkTLS // TLS interface support, also used by other transports
 	// _links can only be modified safely from within the links actor
-	_links     map[linkInfo]*link // *link is nil if connection in progress
-	_listeners map[*Listener]context.CancelFunc
-}
-
-type linkProtocol interface {
-	dial(ctx context.Context, url *url.URL, info linkInfo, options linkOptions) (net.Conn, error)
-	listen(ctx context.Context, url *url.URL, sintf string) (net.Listener, error)
+	_links        map[linkInfo]*link   // *link is nil if connection in progress
+	_transports   map[string]Transport // By lower case scheme
+	_listeners    map[*Listener]context.CancelFunc
+	_dialFailures map[string]uint64 // Failed outbound connection attempts by error class
 }
 
 // linkInfo is used as a map key
@@ -72,32 +68,61 @@ type link struct {
 type linkOptions struct {
 	pinnedEd25519Keys map[keyArray]struct{}
 	priority          uint8
-	tlsSNI            string
 	password          []byte
 	maxBackoff        time.Duration
+	transport         any // Options parsed by the transport, if any
 }
 
 type Listener struct {
 	listener net.Listener
 	ctx      context.Context
 	Cancel   context.CancelFunc
+	uri      string // Listener URI without query options
+	scheme   string // Listener URI scheme, e.g. "tls"
+	sintf    string // Listener source interface, if any
+	local    bool   // Started with ListenLocal, e.g. multicast
+	options  linkOptions
+	limits   listenerLimits
+	accepted atomic.Uint64 // Number of accepted connections
 }
 
 func (l *Listener) Addr() net.Addr {
 	return l.listener.Addr()
 }
 
+// Done returns a channel that is closed when the listener stops.
+func (l *Listener) Done() <-chan struct{} {
+	return l.ctx.Done()
+}
+
 func (l *links) init(c *Core) error {
 	l.core = c
 	l.tcp = l.newLinkTCP()
 	l.tls = l.newLinkTLS(l.tcp)
-	l.unix = l.newLinkUNIX()
-	l.socks = l.newLinkSOCKS()
-	l.quic = l.newLinkQUIC()
-	l.ws = l.newLinkWS()
-	l.wss = l.newLinkWSS()
+	quic := l.newLinkQUIC()
+	socks := l.newLinkSOCKS(quic)
+	httpproxy := l.newLinkHTTPProxy()
 	l._links = make(map[linkInfo]*link)
 	l._listeners = make(map[*Listener]context.CancelFunc)
+	l._dialFailures = make(map[string]uint64)
+	l._transports = map[string]Transport{
+		"tcp":          l.tcp,
+		"tls":          l.tls,
+		"unix":         l.newLinkUNIX(),
+		"socks":        socks,
+		"sockstls":     socks,
+		"socksquic":    socks,
+		"httpproxy":    httpproxy,
+		"httpproxytls": httpproxy,
+		"quic":         quic,
+		"ws":           l.newLinkWS(),
+		"wss":          l.newLinkWSS(),
+	}
+	for _, t := range l.core.config.transports {
+		if err := l._registerTransport(t.Scheme, t.Transport); err != nil {
+			return err
+		}
+	}
 
 	l.Act(nil, l._updateAverages)
 	return nil
@@ -155,8 +180,21 @@ const ErrLinkUnrecognisedSchema = linkError("link schema unknown")
 const ErrLinkMaxBackoffInvalid = linkError("max backoff duration invalid")
 const ErrLinkSNINotSupported = linkError("SNI not supported on this link type")
 const ErrLinkNoSuitableIPs = linkError("peer has no suitable addresses")
+const ErrLinkListenerNotFound = linkError("listener not found")
+const ErrLinkMaxConnsInvalid = linkError("max connections value is invalid")
+const ErrLinkMaxPerIPInvalid = linkError("max connections per IP value is invalid")
+const ErrLinkAcceptRateInvalid = linkError("accept rate value is invalid")
+const ErrLinkListenerMaxConns = linkError("listener connection limit reached")
+const ErrLinkListenerMaxPerIP = linkError("listener per-IP connection limit reached")
+const ErrLinkListenerRateLimited = linkError("listener accept rate exceeded")
 
 func (l *links) add(u *url.URL, sintf string, linkType linkType) error {
+	return l.addAfter(u, sintf, linkType, 0)
+}
+
+// addAfter adds a link like add, but waits for the given delay before the
+// first connection attempt, unless the link is kicked in the meantime.
+func (l *links) addAfter(u *url.URL, sintf string, linkType linkType, delay time.Duration) error {
 	var retErr error
 	phony.Block(l, func() {
 		// Generate the link info and see whether we think we already
@@ -208,22 +246,13 @@ func (l *links) add(u *url.URL, sintf string, linkType linkType) error {
 			}
 			options.maxBackoff = d
 		}
-		// SNI headers must contain hostnames and not IP addresses, so we must make sure
-		// that we do not populate the SNI with an IP literal. We do this by splitting
-		// the host-port combo from the query option and then seeing if it parses to an
-		// IP address successfully or not.
-		if sni := u.Query().Get("sni"); sni != "" {
-			if net.ParseIP(sni) == nil {
-				options.tlsSNI = sni
-			}
-		}
-		// If the SNI is not configured still because the above failed then we'll try
-		// again but this time we'll use the host part of the peering URI instead.
-		if options.tlsSNI == "" {
-			if host, _, err := net.SplitHostPort(u.Host); err == nil && net.ParseIP(host) == nil {
-				options.tlsSNI = host
-			}
+		// The transport for the scheme parses any options of its own.
+		transport, toptions, err := l._transportFor(u)
+		if err != nil {
+			retErr = err
+			return
 		}
+		options.transport = toptions
 
 		// If we think we're already connected to this peer, load up
 		// the existing peer state. Try to kick the peer if possible,
@@ -300,6 +329,15 @@ func (l *links) add(u *url.URL, sintf string, linkType linkType) error {
 				}
 			})
 
+			if delay > 0 {
+				select {
+				case <-state.kick:
+				case <-state.ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+			}
+
 			// This loop will run each and every time we want to attempt
 			// a connection to this peer.
 			// TODO get rid of this loop, this is *exactly* what time.AfterFunc is for, we should just send a signal to the links actor to kick off a goroutine as needed
@@ -312,11 +350,20 @@ func (l *links) add(u *url.URL, sintf string, linkType linkType) error {
 				default:
 				}
 
-				conn, err := l.connect(state.ctx, u, info, options)
+				conn, err := transport.Dial(state.ctx, u, TransportOptions{
+					Interface: sintf,
+					Options:   options.transport,
+				})
 				if err != nil || conn == nil {
 					if err == nil && conn == nil {
 						l.core.log.Warnf("Link %q reached inconsistent error state", u.String())
 					}
+					if state.ctx.Err() == nil {
+						class := linkErrorClass(err)
+						phony.Block(l, func() {
+							l._dialFailures[class]++
+						})
+					}
 					if linkType == linkTypePersistent {
 						// If the link is a persistent configured peering,
 						// store information about the connection error so
@@ -365,7 +412,7 @@ func (l *links) add(u *url.URL, sintf string, linkType linkType) error {
 
 				// Give the connection to the handler. The handler will block
 				// for the lifetime of the connection.
-				switch err = l.handler(linkType, options, lc, resetBackoff, false); {
+				switch err = l.handler(info, linkType, options, lc, resetBackoff, false); {
 				case err == nil:
 				case errors.Is(err, io.EOF):
 				case errors.Is(err, net.ErrClosed):
@@ -420,6 +467,10 @@ func (l *links) remove(u *url.URL, sintf string, _ linkType) error {
 			if conn := state._conn; conn != nil {
 				retErr = conn.Close()
 			}
+			// Drop the link state now rather than waiting for the dial
+			// goroutine to notice the cancellation, so that the same
+			// peer can be added again straight away.
+			delete(l._links, info)
 			return
 		}
 
@@ -430,25 +481,20 @@ func (l *links) remove(u *url.URL, sintf string, _ linkType) error {
 
 func (l *links) listen(u *url.URL, sintf string, local bool) (*Listener, error) {
 	ctx, ctxcancel := context.WithCancel(l.core.ctx)
-	var protocol linkProtocol
-	switch strings.ToLower(u.Scheme) {
-	case "tcp":
-		protocol = l.tcp
-	case "tls":
-		protocol = l.tls
-	case "unix":
-		protocol = l.unix
-	case "quic":
-		protocol = l.quic
-	case "ws":
-		protocol = l.ws
-	case "wss":
-		protocol = l.wss
-	default:
+	var transport Transport
+	var toptions any
+	var err error
+	phony.Block(l, func() {
+		transport, toptions, err = l._transportFor(u)
+	})
+	if err != nil {
 		ctxcancel()
-		return nil, ErrLinkUnrecognisedSchema
+		return nil, err
 	}
-	listener, err := protocol.listen(ctx, u, sintf)
+	listener, err := transport.Listen(ctx, u, TransportOptions{
+		Interface: sintf,
+		Options:   toptions,
+	})
 	if err != nil {
 		ctxcancel()
 		return nil, err
@@ -460,26 +506,38 @@ func (l *links) listen(u *url.URL, sintf string, local bool) (*Listener, error)
 			l.core.log.Warnf("Error closing %s listener %s: %s", strings.ToUpper(u.Scheme), addr, err)
 		}
 	}
+	lu := urlForLinkInfo(*u)
 	li := &Listener{
 		listener: listener,
 		ctx:      ctx,
 		Cancel:   cancel,
+		uri:      lu.String(),
+		scheme:   strings.ToLower(u.Scheme),
+		sintf:    sintf,
+		local:    local,
 	}
 
-	var options linkOptions
+	options := linkOptions{transport: toptions}
 	if p := u.Query().Get("priority"); p != "" {
 		pi, err := strconv.ParseUint(p, 10, 8)
 		if err != nil {
+			cancel()
 			return nil, ErrLinkPriorityInvalid
 		}
 		options.priority = uint8(pi)
 	}
 	if p := u.Query().Get("password"); p != "" {
 		if len(p) > blake2b.Size {
+			cancel()
 			return nil, ErrLinkPasswordInvalid
 		}
 		options.password = []byte(p)
 	}
+	li.options = options
+	if err := li.limits.parse(u); err != nil {
+		cancel()
+		return nil, err
+	}
 
 	phony.Block(l, func() {
 		l._listeners[li] = cancel
@@ -487,17 +545,31 @@ func (l *links) listen(u *url.URL, sintf string, local bool) (*Listener, error)
 
 	go func() {
 		l.core.log.Infof("%s listener started on %s", strings.ToUpper(u.Scheme), addr)
+		// Report the bound address, as the configured port may be zero.
+		bound := fmt.Sprintf("%s://%s", li.scheme, addr)
+		l.core.events.emit(Event{Type: EventListenerStart, URI: bound})
 		defer phony.Block(l, func() {
 			cancel()
 			delete(l._listeners, li)
 			l.core.log.Infof("%s listener stopped on %s", strings.ToUpper(u.Scheme), addr)
+			l.core.events.emit(Event{Type: EventListenerStop, URI: bound})
 		})
 		for {
 			conn, err := li.listener.Accept()
 			if err != nil {
 				return
 			}
+			// Enforce the listener limits before spending any effort on
+			// the handshake.
+			release, err := li.limits.acquire(conn.RemoteAddr())
+			if err != nil {
+				l.core.log.Debugf("Rejected %s connection from %s: %s", strings.ToUpper(u.Scheme), conn.RemoteAddr(), err)
+				_ = conn.Close()
+				continue
+			}
+			li.accepted.Add(1)
 			go func(conn net.Conn) {
+				defer release()
 				defer conn.Close()
 
 				// In order to populate a somewhat sane looking connection
@@ -560,7 +632,7 @@ func (l *links) listen(u *url.URL, sintf string, local bool) (*Listener, error)
 
 				// Give the connection to the handler. The handler will block
 				// for the lifetime of the connection.
-				switch err = l.handler(linkTypeIncoming, options, lc, nil, local); {
+				switch err = l.handler(info, linkTypeIncoming, options, lc, nil, local); {
 				case err == nil:
 				case errors.Is(err, io.EOF):
 				case errors.Is(err, net.ErrClosed):
@@ -578,33 +650,71 @@ func (l *links) listen(u *url.URL, sintf string, local bool) (*Listener, error)
 	return li, nil
 }
 
-func (l *links) connect(ctx context.Context, u *url.URL, info linkInfo, options linkOptions) (net.Conn, error) {
-	var dialer linkProtocol
-	switch strings.ToLower(u.Scheme) {
-	case "tcp":
-		dialer = l.tcp
-	case "tls":
-		dialer = l.tls
-	case "socks", "sockstls":
-		dialer = l.socks
-	case "unix":
-		dialer = l.unix
-	case "quic":
-		dialer = l.quic
-	case "ws":
-		dialer = l.ws
-	case "wss":
-		dialer = l.wss
+// unlisten stops any non-local listeners that were started with the given
+// URI, or that are bound to the address given in the URI.
+func (l *links) unlisten(u *url.URL, sintf string) error {
+	lu := urlForLinkInfo(*u)
+	uri := lu.String()
+	var listeners []*Listener
+	phony.Block(l, func() {
+		for li := range l._listeners {
+			switch {
+			case li.local, li.sintf != sintf:
+				continue
+			case li.uri == uri:
+			case strings.EqualFold(u.Scheme, li.scheme) && u.Host == li.Addr().String():
+			default:
+				continue
+			}
+			listeners = append(listeners, li)
+		}
+	})
+	if len(listeners) == 0 {
+		return ErrLinkListenerNotFound
+	}
+	for _, li := range listeners {
+		li.Cancel()
+	}
+	return nil
+}
+
+// linkErrorClass sorts connection errors into broad classes, so that they
+// can be counted without creating a separate entry for every address.
+func linkErrorClass(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var linkErr linkError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	switch {
+	case err == nil:
+		return "unknown"
+	case errors.Is(err, ErrLinkNoSuitableIPs), errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return "unreachable"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &certErr), errors.As(err, &recordErr):
+		return "tls"
+	case errors.As(err, &linkErr):
+		return "config"
 	default:
-		return nil, ErrLinkUnrecognisedSchema
+		return "other"
 	}
-	return dialer.dial(ctx, u, info, options)
 }
 
-func (l *links) handler(linkType linkType, options linkOptions, conn net.Conn, success func(), local bool) error {
+func (l *links) handler(info linkInfo, linkType linkType, options linkOptions, conn net.Conn, success func(), local bool) error {
 	meta := version_getBaseMetadata()
 	meta.publicKey = l.core.public
 	meta.priority = options.priority
+	meta.nonce = make([]byte, metaNonceSize)
+	if _, err := rand.Read(meta.nonce); err != nil {
+		return fmt.Errorf("failed to generate handshake nonce: %w", err)
+	}
+	nonce := meta.nonce
 	metaBytes, err := meta.encode(l.core.secret, options.password)
 	if err != nil {
 		return fmt.Errorf("failed to generate handshake: %w", err)
@@ -631,32 +741,50 @@ func (l *links) handler(linkType linkType, options linkOptions, conn net.Conn, s
 			fmt.Sprintf("%d.%d", meta.majorVer, meta.minorVer),
 		)
 	}
+	// Pinned keys and AllowedPublicKeys are only meaningful if the remote
+	// side proves that it holds the key.
+	pinned := options.pinnedEd25519Keys
+	var allowed map[[32]byte]struct{}
+	if !local && linkType == linkTypeIncoming {
+		phony.Block(l.core, func() {
+			allowed = l.core.config._allowedPublicKeys
+		})
+	}
+	// If the remote side supports it, both sides now prove that they hold
+	// the private key for the public key in their metadata. Older nodes
+	// don't, so they are only accepted when no keys are enforced, as the
+	// key in their metadata is only as trustworthy as the password, if any.
+	if meta.supportsProof() {
+		if err := l.proveKey(conn, options.password, nonce, &meta); err != nil {
+			return err
+		}
+	} else {
+		if len(pinned) > 0 || len(allowed) > 0 {
+			return fmt.Errorf("remote node %s does not prove key possession, which is required by pinned keys or AllowedPublicKeys", conn.RemoteAddr())
+		}
+		l.core.log.Warnf("Remote node %s does not prove key possession, continuing in legacy mode", conn.RemoteAddr())
+	}
 	if err = conn.SetDeadline(time.Time{}); err != nil {
 		return fmt.Errorf("failed to clear handshake deadline: %w", err)
 	}
-	// Check if the remote side matches the keys we expected. This is a bit of a weak
-	// check - in future versions we really should check a signature or something like that.
-	if pinned := options.pinnedEd25519Keys; len(pinned) > 0 {
+	// Check if the remote side matches the keys we expected.
+	if len(pinned) > 0 {
 		var key keyArray
 		copy(key[:], meta.publicKey)
-		if _, allowed := pinned[key]; !allowed {
+		if _, ok := pinned[key]; !ok {
 			return fmt.Errorf("node public key that does not match pinned keys")
 		}
 	}
 	// Check if we're authorized to connect to this key / IP
-	if !local {
-		var allowed map[[32]byte]struct{}
-		phony.Block(l.core, func() {
-			allowed = l.core.config._allowedPublicKeys
-		})
-		isallowed := len(allowed) == 0
+	if len(allowed) > 0 {
+		isallowed := false
 		for k := range allowed {
 			if bytes.Equal(k[:], meta.publicKey) {
 				isallowed = true
 				break
 			}
 		}
-		if linkType == linkTypeIncoming && !isallowed {
+		if !isallowed {
 			return fmt.Errorf("node public key %q is not in AllowedPublicKeys", hex.EncodeToString(meta.publicKey))
 		}
 	}
@@ -677,8 +805,24 @@ func (l *links) handler(linkType linkType, options linkOptions, conn net.Conn, s
 	if success != nil {
 		success()
 	}
+	l.core.events.emit(Event{
+		Type:    EventPeerUp,
+		Key:     meta.publicKey,
+		URI:     info.uri,
+		Inbound: linkType == linkTypeIncoming,
+	})
 
 	err = l.core.HandleConn(meta.publicKey, conn, priority)
+	down := Event{
+		Type:    EventPeerDown,
+		Key:     meta.publicKey,
+		URI:     info.uri,
+		Inbound: linkType == linkTypeIncoming,
+	}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		down.Error = err
+	}
+	l.core.events.emit(down)
 	switch err {
 	case io.EOF, net.ErrClosed, nil:
 		l.core.log.Infof("Disconnected %s: %s, source %s",
@@ -690,6 +834,35 @@ func (l *links) handler(linkType linkType, options linkOptions, conn net.Conn, s
 	return err
 }
 
+// proveKey exchanges signatures over the handshake transcript with the
+// remote side, proving that both sides hold their private keys.
+func (l *links) proveKey(conn net.Conn, password, nonce []byte, remote *version_metadata) error {
+	exporter := version_tlsExporter(conn)
+	ours, err := version_proofTranscript(password, l.core.public, remote.publicKey, nonce, remote.nonce, exporter)
+	if err != nil {
+		return err
+	}
+	sig := ed25519.Sign(l.core.secret, ours)
+	n, err := conn.Write(sig)
+	switch {
+	case err != nil:
+		return fmt.Errorf("write handshake proof: %w", err)
+	case n != len(sig):
+		return fmt.Errorf("incomplete handshake proof send")
+	}
+	theirs, err := version_proofTranscript(password, remote.publicKey, l.core.public, remote.nonce, nonce, exporter)
+	if err != nil {
+		return err
+	}
+	if _, err = io.ReadFull(conn, sig); err != nil {
+		return fmt.Errorf("read handshake proof: %w", err)
+	}
+	if !ed25519.Verify(remote.publicKey, theirs, sig) {
+		return ErrHandshakeInvalidProof
+	}
+	return nil
+}
+
 func (l *links) findSuitableIP(url *url.URL, fn func(hostname string, ip net.IP, port int) (net.Conn, error)) (net.Conn, error) {
 	host, p, err := net.SplitHostPort(url.Host)
 	if err != nil {
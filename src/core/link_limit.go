@@ -0,0 +1,135 @@
+package core
+
+import (
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// listenerLimits restricts the inbound connections that a listener will
+// hand over to the link handler, so that a single remote host can't make
+// us perform lots of handshakes. The limits are set with the "maxconns",
+// "maxperip" and "acceptrate" listener URL options.
+type listenerLimits struct {
+	maxConns      uint64  // Maximum number of concurrent connections, 0 for no limit
+	maxPerIP      uint64  // Maximum concurrent connections per remote IP, 0 for no limit
+	rate          float64 // Maximum new connections per second, 0 for no limit
+	mutex         sync.Mutex
+	conns         uint64
+	perIP         map[netip.Addr]uint64
+	tokens        float64
+	last          time.Time
+	rejectedConns atomic.Uint64 // Rejected because of maxconns
+	rejectedPerIP atomic.Uint64 // Rejected because of maxperip
+	rejectedRate  atomic.Uint64 // Rejected because of acceptrate
+}
+
+func (l *listenerLimits) parse(u *url.URL) error {
+	if p := u.Query().Get("maxconns"); p != "" {
+		v, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return ErrLinkMaxConnsInvalid
+		}
+		l.maxConns = v
+	}
+	if p := u.Query().Get("maxperip"); p != "" {
+		v, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return ErrLinkMaxPerIPInvalid
+		}
+		l.maxPerIP = v
+	}
+	if p := u.Query().Get("acceptrate"); p != "" {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+			return ErrLinkAcceptRateInvalid
+		}
+		l.rate = v
+	}
+	l.perIP = make(map[netip.Addr]uint64)
+	l.tokens = l.burst()
+	l.last = time.Now()
+	return nil
+}
+
+// burst is the number of connections that can be accepted at once after
+// a quiet period when an accept rate is set.
+func (l *listenerLimits) burst() float64 {
+	return math.Max(1, math.Ceil(l.rate))
+}
+
+// acquire checks whether a new connection from the given remote address
+// is allowed. If it is, the returned function must be called once the
+// connection has closed.
+func (l *listenerLimits) acquire(remote net.Addr) (func(), error) {
+	ip, hasIP := remoteIP(remote)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	// The connection limits are checked first, so that connections which
+	// they reject don't use up the accept rate for everyone else.
+	if l.maxConns > 0 && l.conns >= l.maxConns {
+		l.rejectedConns.Add(1)
+		return nil, ErrLinkListenerMaxConns
+	}
+	if l.maxPerIP > 0 && hasIP && l.perIP[ip] >= l.maxPerIP {
+		l.rejectedPerIP.Add(1)
+		return nil, ErrLinkListenerMaxPerIP
+	}
+	if l.rate > 0 {
+		now := time.Now()
+		l.tokens = math.Min(l.burst(), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		if l.tokens < 1 {
+			l.rejectedRate.Add(1)
+			return nil, ErrLinkListenerRateLimited
+		}
+		l.tokens--
+	}
+	l.conns++
+	if hasIP {
+		l.perIP[ip]++
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			l.conns--
+			if hasIP {
+				if l.perIP[ip]--; l.perIP[ip] == 0 {
+					delete(l.perIP, ip)
+				}
+			}
+		})
+	}, nil
+}
+
+// active returns the number of connections currently counted against
+// the limits.
+func (l *listenerLimits) active() uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.conns
+}
+
+// remoteIP returns the IP address of a remote connection address, if it
+// has one, e.g. not for UNIX sockets.
+func remoteIP(addr net.Addr) (netip.Addr, bool) {
+	if addr == nil {
+		return netip.Addr{}, false
+	}
+	if a, ok := addr.(interface{ AddrPort() netip.AddrPort }); ok {
+		ip := a.AddrPort().Addr()
+		return ip.Unmap().WithZone(""), ip.IsValid()
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ap.Addr().Unmap().WithZone(""), true
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestListenerLimits(t *testing.T) {
+	u, err := url.Parse("tcp://[::]:0?maxconns=3&maxperip=2&acceptrate=100")
+	require_NoError(t, err)
+	var l listenerLimits
+	require_NoError(t, l.parse(u))
+
+	a := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}
+	b := &net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 2}
+	c := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 3}
+
+	releaseA, err := l.acquire(a)
+	require_NoError(t, err)
+	_, err = l.acquire(b)
+	require_NoError(t, err)
+
+	// IPv4-mapped addresses count towards the same IP.
+	_, err = l.acquire(a)
+	require_Equal(t, err, error(ErrLinkListenerMaxPerIP))
+	require_Equal(t, l.rejectedPerIP.Load(), 1)
+
+	_, err = l.acquire(c)
+	require_NoError(t, err)
+	_, err = l.acquire(&net.TCPAddr{IP: net.ParseIP("192.0.2.3")})
+	require_Equal(t, err, error(ErrLinkListenerMaxConns))
+	require_Equal(t, l.rejectedConns.Load(), 1)
+
+	// Releasing more than once must only free one slot.
+	releaseA()
+	releaseA()
+	require_Equal(t, l.active(), 2)
+	_, err = l.acquire(a)
+	require_NoError(t, err)
+}
+
+func TestListenerAcceptRate(t *testing.T) {
+	u, err := url.Parse("tcp://[::]:0?acceptrate=0.001")
+	require_NoError(t, err)
+	var l listenerLimits
+	require_NoError(t, l.parse(u))
+
+	_, err = l.acquire(&net.UnixAddr{Name: "@test"})
+	require_NoError(t, err)
+	_, err = l.acquire(&net.UnixAddr{Name: "@test"})
+	require_Equal(t, err, error(ErrLinkListenerRateLimited))
+	require_Equal(t, l.rejectedRate.Load(), 1)
+
+	for _, opt := range []string{"maxconns=-1", "maxperip=x", "acceptrate=-1"} {
+		u, err := url.Parse("tcp://[::]:0?" + opt)
+		require_NoError(t, err)
+		var l listenerLimits
+		require_True(t, l.parse(u) != nil)
+	}
+}
+
+func TestListenerLimitsKeepRate(t *testing.T) {
+	u, err := url.Parse("tcp://[::]:0?maxperip=1&acceptrate=2")
+	require_NoError(t, err)
+	var l listenerLimits
+	require_NoError(t, l.parse(u))
+
+	a := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}
+	_, err = l.acquire(a)
+	require_NoError(t, err)
+
+	// Connections rejected by the other limits don't take an accept token.
+	for i := 0; i < 3; i++ {
+		_, err = l.acquire(a)
+		require_Equal(t, err, error(ErrLinkListenerMaxPerIP))
+	}
+	require_Equal(t, l.rejectedRate.Load(), 0)
+	_, err = l.acquire(&net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 2})
+	require_NoError(t, err)
+	_, err = l.acquire(&net.TCPAddr{IP: net.ParseIP("192.0.2.3"), Port: 3})
+	require_Equal(t, err, error(ErrLinkListenerRateLimited))
+}
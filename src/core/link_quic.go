@@ -50,7 +50,7 @@ func (l *links) newLinkQUIC() *linkQUIC {
 	return lt
 }
 
-func (l *linkQUIC) dial(ctx context.Context, url *url.URL, info linkInfo, options linkOptions) (net.Conn, error) {
+func (l *linkQUIC) Dial(ctx context.Context, url *url.URL, _ TransportOptions) (net.Conn, error) {
 	tlsconfig := l.tlsconfig.Clone()
 	return l.links.findSuitableIP(url, func(hostname string, ip net.IP, port int) (net.Conn, error) {
 		tlsconfig.ServerName = hostname
@@ -72,7 +72,7 @@ func (l *linkQUIC) dial(ctx context.Context, url *url.URL, info linkInfo, option
 	})
 }
 
-func (l *linkQUIC) listen(ctx context.Context, url *url.URL, _ string) (net.Listener, error) {
+func (l *linkQUIC) Listen(ctx context.Context, url *url.URL, _ TransportOptions) (net.Listener, error) {
 	ql, err := quic.ListenAddr(url.Host, l.tlsconfig, l.quicconfig)
 	if err != nil {
 		return nil, err
@@ -3,40 +3,59 @@ package core
 import (
 	"context"
 	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/quic-go/quic-go"
 	"golang.org/x/net/proxy"
 )
 
+// How long to wait before asking the SOCKS server to bind again, after
+// failing to.
+const socksRebindDelay = time.Second * 5
+
+// How long the SOCKS server has to answer a handshake or request, except for
+// the second reply to a BIND request, which only comes when a peer connects.
+const socksTimeout = time.Second * 10
+
 type linkSOCKS struct {
 	*links
+	quic *linkQUIC // For "socksquic"
 }
 
-func (l *links) newLinkSOCKS() *linkSOCKS {
+func (l *links) newLinkSOCKS(quic *linkQUIC) *linkSOCKS {
 	lt := &linkSOCKS{
 		links: l,
+		quic:  quic,
 	}
 	return lt
 }
 
-func (l *linkSOCKS) dial(_ context.Context, url *url.URL, info linkInfo, options linkOptions) (net.Conn, error) {
-	var proxyAuth *proxy.Auth
-	if url.User != nil && url.User.Username() != "" {
-		proxyAuth = &proxy.Auth{
-			User: url.User.Username(),
-		}
-		proxyAuth.Password, _ = url.User.Password()
+// ParseOptions parses the SNI from the URL, which is used with "sockstls".
+func (l *linkSOCKS) ParseOptions(url *url.URL) (any, error) {
+	return l.tls.ParseOptions(url)
+}
+
+func (l *linkSOCKS) Dial(ctx context.Context, url *url.URL, opts TransportOptions) (net.Conn, error) {
+	if url.Scheme == "socksquic" {
+		return l.dialQUIC(ctx, url, opts)
 	}
+	proxyAuth := socksAuth(url)
 	tlsconfig := l.tls.config.Clone()
 	return l.links.findSuitableIP(url, func(hostname string, ip net.IP, port int) (net.Conn, error) {
 		hostport := net.JoinHostPort(ip.String(), fmt.Sprintf("%d", port))
 		dialer, err := l.tcp.dialerFor(&net.TCPAddr{
 			IP:   ip,
 			Port: port,
-		}, info.sintf)
+		}, opts.Interface)
 		if err != nil {
 			return nil, err
 		}
@@ -53,8 +72,8 @@ func (l *linkSOCKS) dial(_ context.Context, url *url.URL, info linkInfo, options
 			tlsconfig.ServerName = hostname
 			tlsconfig.MinVersion = tls.VersionTLS12
 			tlsconfig.MaxVersion = tls.VersionTLS13
-			if sni := options.tlsSNI; sni != "" {
-				tlsconfig.ServerName = sni
+			if options, ok := opts.Options.(linkTLSOptions); ok && options.sni != "" {
+				tlsconfig.ServerName = options.sni
 			}
 			conn = tls.Client(conn, tlsconfig)
 		}
@@ -62,6 +81,547 @@ func (l *linkSOCKS) dial(_ context.Context, url *url.URL, info linkInfo, options
 	})
 }
 
-func (l *linkSOCKS) listen(ctx context.Context, url *url.URL, _ string) (net.Listener, error) {
-	return nil, fmt.Errorf("SOCKS listener not supported")
+// dialQUIC connects to a QUIC listener through the UDP relay of the SOCKS
+// server, which is set up with a UDP ASSOCIATE request. The association
+// lasts for as long as the TCP connection that requested it.
+func (l *linkSOCKS) dialQUIC(ctx context.Context, url *url.URL, opts TransportOptions) (net.Conn, error) {
+	target, err := parseSOCKSAddr(strings.Split(strings.Trim(url.Path, "/"), "/")[0])
+	if err != nil {
+		return nil, err
+	}
+	tlsconfig := l.quic.tlsconfig.Clone()
+	tlsconfig.MinVersion = tls.VersionTLS12
+	tlsconfig.MaxVersion = tls.VersionTLS13
+	if net.ParseIP(target.host) == nil {
+		tlsconfig.ServerName = target.host
+	}
+	return l.dialProxy(ctx, url, opts, func(ip net.IP, control net.Conn) (net.Conn, error) {
+		relay, err := socksRequest(control, socksCmdUDPAssociate, &socksAddr{host: "0.0.0.0"})
+		if err != nil {
+			return nil, err
+		}
+		// The relay is usually on the same host as the server, which it may
+		// leave us to assume by not giving an address.
+		relayaddr, err := net.ResolveUDPAddr("udp", relay.String())
+		if err != nil {
+			return nil, err
+		}
+		if relay.unspecified() {
+			relayaddr.IP = ip
+		}
+		udpconn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			return nil, err
+		}
+		pc := &socksPacketConn{
+			conn:    udpconn,
+			relay:   relayaddr,
+			control: control,
+		}
+		go func() {
+			_, _ = io.Copy(io.Discard, control)
+			_ = pc.Close()
+		}()
+		qc, err := quic.Dial(ctx, pc, target, tlsconfig, l.quic.quicconfig)
+		if err != nil {
+			_ = pc.Close()
+			return nil, err
+		}
+		qs, err := qc.OpenStreamSync(ctx)
+		if err != nil {
+			_ = qc.CloseWithError(1, fmt.Sprintf("stream error: %s", err))
+			_ = pc.Close()
+			return nil, err
+		}
+		return &linkSOCKSQUICStream{
+			linkQUICStream: &linkQUICStream{
+				Connection: qc,
+				Stream:     qs,
+			},
+			pc: pc,
+		}, nil
+	})
+}
+
+// dialProxy connects and authenticates to the SOCKS server given by the URL,
+// and then passes the connection to fn, which makes a request with it. The
+// connection is closed if fn fails.
+func (l *linkSOCKS) dialProxy(ctx context.Context, url *url.URL, opts TransportOptions, fn func(ip net.IP, conn net.Conn) (net.Conn, error)) (net.Conn, error) {
+	proxyAuth := socksAuth(url)
+	return l.links.findSuitableIP(url, func(hostname string, ip net.IP, port int) (net.Conn, error) {
+		addr := &net.TCPAddr{
+			IP:   ip,
+			Port: port,
+		}
+		dialer, err := l.tcp.dialerFor(addr, opts.Interface)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", addr.String())
+		if err != nil {
+			return nil, err
+		}
+		if err = socksHandshake(conn, proxyAuth); err == nil {
+			var c net.Conn
+			if c, err = fn(ip, conn); err == nil {
+				return c, nil
+			}
+		}
+		_ = conn.Close()
+		return nil, err
+	})
+}
+
+// Listen accepts connections that are relayed by the SOCKS server, for nodes
+// that can only reach the outside world through one. The server is asked to
+// listen with a BIND request, and then again each time that a peer connects.
+// The address in the path of the URL, if any, is sent with each request:
+// servers usually only let a peer from that address connect, although one
+// that is set up to relay for us may take it as the address to listen on.
+// With "sockstls", peers are expected to connect with TLS.
+func (l *linkSOCKS) Listen(ctx context.Context, url *url.URL, opts TransportOptions) (net.Listener, error) {
+	if url.Scheme == "socksquic" {
+		return nil, fmt.Errorf("SOCKS QUIC listener not supported")
+	}
+	expect := &socksAddr{host: "0.0.0.0"}
+	if path := strings.Split(strings.Trim(url.Path, "/"), "/")[0]; path != "" {
+		var err error
+		if expect, err = parseSOCKSAddr(path); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	listener := &linkSOCKSListener{
+		linkSOCKS: l,
+		url:       url,
+		opts:      opts,
+		expect:    expect,
+		ctx:       ctx,
+		cancel:    cancel,
+		ch:        make(chan net.Conn),
+	}
+	conn, err := listener.bind()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go listener.run(conn)
+	return listener, nil
+}
+
+type linkSOCKSListener struct {
+	*linkSOCKS
+	url    *url.URL
+	opts   TransportOptions
+	expect *socksAddr // Sent with each BIND request
+	ctx    context.Context
+	cancel context.CancelFunc
+	ch     chan net.Conn
+	mutex  sync.Mutex
+	addr   net.Addr // Where the server is listening for us, most recently
+}
+
+// bind asks the SOCKS server to listen for a peer, returning the connection
+// to the server that the peer will be relayed over once it connects.
+func (l *linkSOCKSListener) bind() (net.Conn, error) {
+	var addr net.Addr
+	conn, err := l.dialProxy(l.ctx, l.url, l.opts, func(ip net.IP, conn net.Conn) (net.Conn, error) {
+		bound, err := socksRequest(conn, socksCmdBind, l.expect)
+		if err != nil {
+			return nil, err
+		}
+		// As with UDP relays, the server may leave us to assume that it is
+		// listening on its own address.
+		if addr = bound.addr(); bound.unspecified() {
+			addr = &net.TCPAddr{IP: ip, Port: bound.port}
+		}
+		return conn, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.mutex.Lock()
+	previous := l.addr
+	l.addr = addr
+	l.mutex.Unlock()
+	if previous != nil && previous.String() != addr.String() {
+		l.core.log.Infof("%s listener now bound on %s", strings.ToUpper(l.url.Scheme), addr)
+	}
+	return conn, nil
+}
+
+// run waits for each peer to connect, asking the SOCKS server to listen for
+// the next one straight away, until the listener is closed.
+func (l *linkSOCKSListener) run(conn net.Conn) {
+	defer l.cancel()
+	for {
+		if conn != nil {
+			stop := context.AfterFunc(l.ctx, func() { _ = conn.Close() })
+			remote, err := socksReply(conn)
+			stop()
+			if err == nil {
+				var c net.Conn = &socksConn{Conn: conn, remote: remote.addr()}
+				if l.url.Scheme == "sockstls" {
+					c = tls.Server(c, l.tls.config)
+				}
+				select {
+				case l.ch <- c:
+				case <-l.ctx.Done():
+					_ = c.Close()
+					return
+				}
+			} else {
+				_ = conn.Close()
+				if l.ctx.Err() != nil {
+					return
+				}
+				l.core.log.Debugf("%s listener on %s reported error: %s", strings.ToUpper(l.url.Scheme), l.Addr(), err)
+			}
+		}
+		var err error
+		if conn, err = l.bind(); err != nil {
+			if l.ctx.Err() != nil {
+				return
+			}
+			l.core.log.Debugf("%s listener on %s failed to bind: %s", strings.ToUpper(l.url.Scheme), l.Addr(), err)
+			select {
+			case <-time.After(socksRebindDelay):
+			case <-l.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (l *linkSOCKSListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.ch:
+		return conn, nil
+	case <-l.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *linkSOCKSListener) Close() error {
+	l.cancel()
+	return nil
+}
+
+// Addr returns the address that the SOCKS server is listening on for us.
+func (l *linkSOCKSListener) Addr() net.Addr {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.addr
+}
+
+// socksConn is a connection relayed by a SOCKS server, which reports the
+// address of the peer rather than that of the server.
+type socksConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *socksConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+type linkSOCKSQUICStream struct {
+	*linkQUICStream
+	pc *socksPacketConn
+}
+
+func (s *linkSOCKSQUICStream) Close() error {
+	err := s.Stream.Close()
+	_ = s.Connection.CloseWithError(0, "")
+	_ = s.pc.Close()
+	return err
+}
+
+// socksPacketConn sends and receives datagrams through the UDP relay of a
+// SOCKS server. It deliberately doesn't expose the UDP socket underneath, so
+// that QUIC doesn't bypass the headers that the relay needs.
+type socksPacketConn struct {
+	conn    *net.UDPConn
+	relay   *net.UDPAddr
+	control net.Conn // Closing it ends the association
+	mutex   sync.Mutex
+	buf     [65535]byte // For reads, protected by mutex
+	once    sync.Once
+}
+
+func (c *socksPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for {
+		n, from, err := c.conn.ReadFromUDP(c.buf[:])
+		if err != nil {
+			return 0, nil, err
+		}
+		if !from.IP.Equal(c.relay.IP) || from.Port != c.relay.Port {
+			continue
+		}
+		// Fragmented datagrams are dropped, as allowed by RFC 1928.
+		packet := c.buf[:n]
+		if len(packet) < 4 || packet[2] != 0 {
+			continue
+		}
+		r := &sliceReader{b: packet[3:]}
+		addr, err := readSOCKSAddr(r)
+		if err != nil {
+			continue
+		}
+		return copy(b, r.b), addr.addr(), nil
+	}
+}
+
+func (c *socksPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	to, err := parseSOCKSAddr(addr.String())
+	if err != nil {
+		return 0, err
+	}
+	packet, err := appendSOCKSAddr([]byte{0, 0, 0}, to)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.conn.WriteToUDP(append(packet, b...), c.relay); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *socksPacketConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		err = c.conn.Close()
+		_ = c.control.Close()
+	})
+	return err
+}
+
+func (c *socksPacketConn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *socksPacketConn) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *socksPacketConn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *socksPacketConn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+func (c *socksPacketConn) SetReadBuffer(bytes int) error      { return c.conn.SetReadBuffer(bytes) }
+func (c *socksPacketConn) SetWriteBuffer(bytes int) error     { return c.conn.SetWriteBuffer(bytes) }
+
+type sliceReader struct {
+	b []byte
+}
+
+func (r *sliceReader) Read(b []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
+
+// The parts of SOCKS5 (RFC 1928 and RFC 1929) that golang.org/x/net/proxy
+// doesn't provide, which only supports CONNECT requests.
+const (
+	socksVersion          = 5
+	socksCmdBind          = 2
+	socksCmdUDPAssociate  = 3
+	socksAuthNone         = 0
+	socksAuthPassword     = 2
+	socksAuthNoAcceptable = 0xff
+	socksAddrIPv4         = 1
+	socksAddrDomain       = 3
+	socksAddrIPv6         = 4
+)
+
+var socksReplies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socksAuth returns the credentials for the SOCKS server from the URL, if
+// there are any.
+func socksAuth(url *url.URL) *proxy.Auth {
+	if url.User == nil || url.User.Username() == "" {
+		return nil
+	}
+	auth := &proxy.Auth{
+		User: url.User.Username(),
+	}
+	auth.Password, _ = url.User.Password()
+	return auth
+}
+
+// socksAddr is an address in a SOCKS request or reply, which may have a
+// hostname for the server to resolve.
+type socksAddr struct {
+	host string
+	port int
+}
+
+func parseSOCKSAddr(hostport string) (*socksAddr, error) {
+	host, p, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", p)
+	}
+	return &socksAddr{host: host, port: int(port)}, nil
+}
+
+func (a *socksAddr) Network() string { return "socks" }
+func (a *socksAddr) String() string  { return net.JoinHostPort(a.host, strconv.Itoa(a.port)) }
+
+// addr returns a TCP address if the host is an IP address.
+func (a *socksAddr) addr() net.Addr {
+	if ip := net.ParseIP(a.host); ip != nil {
+		return &net.TCPAddr{IP: ip, Port: a.port}
+	}
+	return a
+}
+
+// unspecified returns true if the address is 0.0.0.0 or ::.
+func (a *socksAddr) unspecified() bool {
+	ip := net.ParseIP(a.host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+func appendSOCKSAddr(b []byte, a *socksAddr) ([]byte, error) {
+	if ip := net.ParseIP(a.host); ip == nil {
+		if len(a.host) > 255 {
+			return nil, fmt.Errorf("hostname %q is too long", a.host)
+		}
+		b = append(b, socksAddrDomain, byte(len(a.host)))
+		b = append(b, a.host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(b, socksAddrIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, socksAddrIPv6)
+		b = append(b, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(a.port)), nil
+}
+
+func readSOCKSAddr(r io.Reader) (*socksAddr, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return nil, err
+	}
+	var host []byte
+	switch atyp[0] {
+	case socksAddrIPv4:
+		host = make([]byte, net.IPv4len)
+	case socksAddrIPv6:
+		host = make([]byte, net.IPv6len)
+	case socksAddrDomain:
+		var length [1]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return nil, err
+		}
+		host = make([]byte, length[0])
+	default:
+		return nil, fmt.Errorf("unknown SOCKS address type %d", atyp[0])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, host); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return nil, err
+	}
+	a := &socksAddr{port: int(binary.BigEndian.Uint16(port[:]))}
+	if atyp[0] == socksAddrDomain {
+		a.host = string(host)
+	} else {
+		a.host = net.IP(host).String()
+	}
+	return a, nil
+}
+
+// socksHandshake chooses an authentication method with the SOCKS server and
+// authenticates, if the server asks for credentials.
+func socksHandshake(conn net.Conn, auth *proxy.Auth) error {
+	_ = conn.SetDeadline(time.Now().Add(socksTimeout))
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	methods := []byte{socksAuthNone}
+	if auth != nil {
+		methods = append(methods, socksAuthPassword)
+	}
+	if _, err := conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+	switch {
+	case reply[1] == socksAuthNone:
+		return nil
+	case reply[1] == socksAuthPassword && auth != nil:
+		if len(auth.User) > 255 || len(auth.Password) > 255 {
+			return errors.New("SOCKS username or password is too long")
+		}
+		req := []byte{1, byte(len(auth.User))}
+		req = append(req, auth.User...)
+		req = append(req, byte(len(auth.Password)))
+		req = append(req, auth.Password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("SOCKS username or password rejected")
+		}
+		return nil
+	case reply[1] == socksAuthNoAcceptable:
+		return errors.New("no acceptable SOCKS authentication methods")
+	default:
+		return fmt.Errorf("unsupported SOCKS authentication method %d", reply[1])
+	}
+}
+
+// socksRequest sends a request to the SOCKS server and returns the address
+// from its reply.
+func socksRequest(conn net.Conn, cmd byte, addr *socksAddr) (*socksAddr, error) {
+	_ = conn.SetDeadline(time.Now().Add(socksTimeout))
+	defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	req, err := appendSOCKSAddr([]byte{socksVersion, cmd, 0}, addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	return socksReply(conn)
+}
+
+// socksReply reads a reply from the SOCKS server, returning the address in
+// it or the error that it reports.
+func socksReply(conn net.Conn) (*socksAddr, error) {
+	var reply [3]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return nil, err
+	}
+	if reply[0] != socksVersion {
+		return nil, fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+	if reply[1] != 0 {
+		if msg, ok := socksReplies[reply[1]]; ok {
+			return nil, errors.New(msg)
+		}
+		return nil, fmt.Errorf("unknown SOCKS error %d", reply[1])
+	}
+	return readSOCKSAddr(conn)
 }
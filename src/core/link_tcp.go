@@ -27,13 +27,13 @@ func (l *links) newLinkTCP() *linkTCP {
 	return lt
 }
 
-func (l *linkTCP) dial(ctx context.Context, url *url.URL, info linkInfo, options linkOptions) (net.Conn, error) {
+func (l *linkTCP) Dial(ctx context.Context, url *url.URL, opts TransportOptions) (net.Conn, error) {
 	return l.links.findSuitableIP(url, func(hostname string, ip net.IP, port int) (net.Conn, error) {
 		addr := &net.TCPAddr{
 			IP:   ip,
 			Port: port,
 		}
-		dialer, err := l.tcp.dialerFor(addr, info.sintf)
+		dialer, err := l.tcp.dialerFor(addr, opts.Interface)
 		if err != nil {
 			return nil, err
 		}
@@ -41,9 +41,9 @@ func (l *linkTCP) dial(ctx context.Context, url *url.URL, info linkInfo, options
 	})
 }
 
-func (l *linkTCP) listen(ctx context.Context, url *url.URL, sintf string) (net.Listener, error) {
+func (l *linkTCP) Listen(ctx context.Context, url *url.URL, opts TransportOptions) (net.Listener, error) {
 	hostport := url.Host
-	if sintf != "" {
+	if sintf := opts.Interface; sintf != "" {
 		if host, port, err := net.SplitHostPort(hostport); err == nil {
 			hostport = fmt.Sprintf("[%s%%%s]:%s", host, sintf, port)
 		}
@@ -31,20 +31,42 @@ func (l *links) newLinkTLS(tcp *linkTCP) *linkTLS {
 	return lt
 }
 
-func (l *linkTLS) dial(ctx context.Context, url *url.URL, info linkInfo, options linkOptions) (net.Conn, error) {
+// linkTLSOptions are the options of the transports that use TLS.
+type linkTLSOptions struct {
+	sni string
+}
+
+// ParseOptions parses the SNI from the URL. SNI headers must contain
+// hostnames and not IP addresses, so an IP literal given with the "sni"
+// option is ignored. Without one, the host part of the URL is used, if it is
+// a hostname.
+func (l *linkTLS) ParseOptions(url *url.URL) (any, error) {
+	var options linkTLSOptions
+	if sni := url.Query().Get("sni"); sni != "" && net.ParseIP(sni) == nil {
+		options.sni = sni
+	}
+	if options.sni == "" {
+		if host, _, err := net.SplitHostPort(url.Host); err == nil && net.ParseIP(host) == nil {
+			options.sni = host
+		}
+	}
+	return options, nil
+}
+
+func (l *linkTLS) Dial(ctx context.Context, url *url.URL, opts TransportOptions) (net.Conn, error) {
 	tlsconfig := l.config.Clone()
 	return l.links.findSuitableIP(url, func(hostname string, ip net.IP, port int) (net.Conn, error) {
 		tlsconfig.ServerName = hostname
 		tlsconfig.MinVersion = tls.VersionTLS12
 		tlsconfig.MaxVersion = tls.VersionTLS13
-		if sni := options.tlsSNI; sni != "" {
-			tlsconfig.ServerName = sni
+		if options, ok := opts.Options.(linkTLSOptions); ok && options.sni != "" {
+			tlsconfig.ServerName = options.sni
 		}
 		addr := &net.TCPAddr{
 			IP:   ip,
 			Port: port,
 		}
-		dialer, err := l.tcp.dialerFor(addr, info.sintf)
+		dialer, err := l.tcp.dialerFor(addr, opts.Interface)
 		if err != nil {
 			return nil, err
 		}
@@ -56,9 +78,9 @@ func (l *linkTLS) dial(ctx context.Context, url *url.URL, info linkInfo, options
 	})
 }
 
-func (l *linkTLS) listen(ctx context.Context, url *url.URL, sintf string) (net.Listener, error) {
+func (l *linkTLS) Listen(ctx context.Context, url *url.URL, opts TransportOptions) (net.Listener, error) {
 	hostport := url.Host
-	if sintf != "" {
+	if sintf := opts.Interface; sintf != "" {
 		if host, port, err := net.SplitHostPort(hostport); err == nil {
 			hostport = fmt.Sprintf("[%s%%%s]:%s", host, sintf, port)
 		}
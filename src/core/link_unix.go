@@ -30,7 +30,7 @@ func (l *links) newLinkUNIX() *linkUNIX {
 	return lt
 }
 
-func (l *linkUNIX) dial(ctx context.Context, url *url.URL, info linkInfo, options linkOptions) (net.Conn, error) {
+func (l *linkUNIX) Dial(ctx context.Context, url *url.URL, _ TransportOptions) (net.Conn, error) {
 	addr, err := net.ResolveUnixAddr("unix", url.Path)
 	if err != nil {
 		return nil, err
@@ -38,6 +38,6 @@ func (l *linkUNIX) dial(ctx context.Context, url *url.URL, info linkInfo, option
 	return l.dialer.DialContext(ctx, "unix", addr.String())
 }
 
-func (l *linkUNIX) listen(ctx context.Context, url *url.URL, _ string) (net.Listener, error) {
+func (l *linkUNIX) Listen(ctx context.Context, url *url.URL, _ TransportOptions) (net.Listener, error) {
 	return l.listener.Listen(ctx, "unix", url.Path)
 }
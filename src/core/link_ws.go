@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/Arceliar/phony"
 	"github.com/coder/websocket"
+	"golang.org/x/net/http/httpguts"
 )
 
 type linkWS struct {
@@ -20,6 +23,23 @@ type linkWS struct {
 
 type linkWSConn struct {
 	net.Conn
+	remote net.Addr // Reported by a trusted reverse proxy, if any
+}
+
+func (c *linkWSConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// linkWSOptions are the options of the WebSocket transports, which are
+// given in the query of the URL. Listeners take the allowed origins and
+// trusted proxies, while peers take the headers and token.
+type linkWSOptions struct {
+	origins []string       // Allowed Origin headers, as "scheme://host", or "*"
+	trusted []netip.Prefix // Reverse proxies trusted to report client addresses
+	header  http.Header    // Sent with the handshake when dialling
 }
 
 type linkWSListener struct {
@@ -30,8 +50,10 @@ type linkWSListener struct {
 }
 
 type wsServer struct {
-	ch  chan *linkWSConn
-	ctx context.Context
+	ch      chan *linkWSConn
+	ctx     context.Context
+	path    string // Without a trailing slash, or empty to accept any path
+	options linkWSOptions
 }
 
 func (l *linkWSListener) Accept() (net.Conn, error) {
@@ -54,15 +76,33 @@ func (l *linkWSListener) Close() error {
 }
 
 func (s *wsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path == "/health" || r.URL.Path == "/healthz" {
+	switch r.URL.Path {
+	case s.path + "/health", s.path + "/healthz":
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("OK"))
 		return
+	case s.path, s.path + "/":
+	default:
+		if s.path != "" {
+			http.NotFound(w, r)
+			return
+		}
 	}
 
-	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+	// Without a list of allowed origins, the Origin header of browsers must
+	// match the Host header.
+	acceptOptions := &websocket.AcceptOptions{
 		Subprotocols: []string{"ruv-ws"},
-	})
+	}
+	if len(s.options.origins) > 0 {
+		if !s.originAllowed(r.Header.Get("Origin")) {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		acceptOptions.InsecureSkipVerify = true
+	}
+
+	c, err := websocket.Accept(w, r, acceptOptions)
 	if err != nil {
 		return
 	}
@@ -73,8 +113,167 @@ func (s *wsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.ch <- &linkWSConn{
-		Conn: websocket.NetConn(s.ctx, c, websocket.MessageBinary),
+		Conn:   websocket.NetConn(s.ctx, c, websocket.MessageBinary),
+		remote: s.forwardedFor(r),
+	}
+}
+
+// originAllowed returns true if the Origin header is in the list of allowed
+// origins. Requests from other nodes don't have one.
+func (s *wsServer) originAllowed(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range s.options.origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the address of the client that a trusted reverse
+// proxy reports in the Forwarded or X-Forwarded-For header, or nil if the
+// request didn't come from one. Proxies append to the list of addresses, so
+// it is read from the end, skipping those of other trusted proxies.
+func (s *wsServer) forwardedFor(r *http.Request) net.Addr {
+	if !s.trusted(r.RemoteAddr) {
+		return nil
+	}
+	var forwarded []string
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					k, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					if strings.EqualFold(k, "for") {
+						forwarded = append(forwarded, strings.Trim(v, `"`))
+					}
+				}
+			}
+		}
+	} else {
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(value, ",") {
+				forwarded = append(forwarded, strings.TrimSpace(addr))
+			}
+		}
+	}
+	var client *net.TCPAddr
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := parseForwardedAddr(forwarded[i])
+		if addr == nil {
+			// e.g. "unknown" or an obfuscated identifier
+			break
+		}
+		client = addr
+		if !s.trusted(addr.String()) {
+			break
+		}
+	}
+	if client == nil {
+		return nil
+	}
+	// Without a port, the one that the proxy connected from keeps the links
+	// of clients behind the same address apart.
+	if client.Port == 0 {
+		if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+			client.Port = int(ap.Port())
+		}
 	}
+	return client
+}
+
+// trusted returns true if the address is that of a trusted reverse proxy.
+func (s *wsServer) trusted(addr string) bool {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range s.options.trusted {
+		if prefix.Contains(ap.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwardedAddr parses an address from a Forwarded or X-Forwarded-For
+// header, which may or may not have a port.
+func parseForwardedAddr(s string) *net.TCPAddr {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return net.TCPAddrFromAddrPort(ap)
+	}
+	if a, err := netip.ParseAddr(strings.Trim(s, "[]")); err == nil {
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(a, 0))
+	}
+	return nil
+}
+
+// parseLinkWSOptions parses the options of the WebSocket transports:
+//
+//   - origin: allowed Origin header, e.g. https://example.com, or * for any
+//   - trustedproxy: address or prefix of a trusted reverse proxy
+//   - header: request header to send, e.g. X-Api-Key:value
+//   - token: bearer token to send in the Authorization header
+//
+// Each may be given more than once, and origins may also be separated by
+// commas.
+func parseLinkWSOptions(u *url.URL) (linkWSOptions, error) {
+	query := u.Query()
+	options := linkWSOptions{
+		header: make(http.Header),
+	}
+	for _, value := range query["origin"] {
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin == "*" {
+				options.origins = append(options.origins, origin)
+				continue
+			}
+			ou, err := url.Parse(origin)
+			if err != nil || ou.Scheme == "" || ou.Host == "" {
+				return options, fmt.Errorf("invalid origin %q", origin)
+			}
+			options.origins = append(options.origins, ou.Scheme+"://"+ou.Host)
+		}
+	}
+	for _, value := range query["trustedproxy"] {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(value)
+			if aerr != nil {
+				return options, fmt.Errorf("invalid trusted proxy %q", value)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		options.trusted = append(options.trusted, prefix.Masked())
+	}
+	for _, value := range query["header"] {
+		name, v, ok := strings.Cut(value, ":")
+		name, v = strings.TrimSpace(name), strings.TrimSpace(v)
+		if !ok || !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(v) {
+			return options, fmt.Errorf("invalid header %q", value)
+		}
+		options.header.Add(name, v)
+	}
+	if token := query.Get("token"); token != "" {
+		if !httpguts.ValidHeaderFieldValue(token) {
+			return options, fmt.Errorf("invalid token")
+		}
+		options.header.Set("Authorization", "Bearer "+token)
+	}
+	return options, nil
+}
+
+// wsDialURL returns the URL to request when dialling, without the options
+// that are sent as headers instead.
+func wsDialURL(u *url.URL) string {
+	du := *u
+	query := du.Query()
+	query.Del("header")
+	query.Del("token")
+	du.RawQuery = query.Encode()
+	return du.String()
 }
 
 func (l *links) newLinkWS() *linkWS {
@@ -87,7 +286,25 @@ func (l *links) newLinkWS() *linkWS {
 	return lt
 }
 
-func (l *linkWS) dial(ctx context.Context, url *url.URL, info linkInfo, options linkOptions) (net.Conn, error) {
+// ParseOptions parses the options of the WebSocket transports, see
+// parseLinkWSOptions.
+func (l *linkWS) ParseOptions(url *url.URL) (any, error) {
+	return parseLinkWSOptions(url)
+}
+
+func (l *linkWS) Dial(ctx context.Context, url *url.URL, opts TransportOptions) (net.Conn, error) {
+	options, _ := opts.Options.(linkWSOptions)
+	// Behind an HTTP proxy, the proxy resolves the hostname, which we may not
+	// be able to.
+	proxy, err := httpProxyFromEnvironment(url)
+	if err != nil {
+		return nil, err
+	}
+	if proxy != nil {
+		return l.dial(ctx, url, url.Hostname(), options.header, func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return l.links.dialHTTPProxy(ctx, proxy, url.Host, opts.Interface)
+		})
+	}
 	return l.links.findSuitableIP(url, func(hostname string, ip net.IP, port int) (net.Conn, error) {
 		u := *url
 		u.Host = net.JoinHostPort(ip.String(), fmt.Sprintf("%d", port))
@@ -95,31 +312,38 @@ func (l *linkWS) dial(ctx context.Context, url *url.URL, info linkInfo, options
 			IP:   ip,
 			Port: port,
 		}
-		dialer, err := l.tcp.dialerFor(addr, info.sintf)
+		dialer, err := l.tcp.dialerFor(addr, opts.Interface)
 		if err != nil {
 			return nil, err
 		}
-		wsconn, _, err := websocket.Dial(ctx, u.String(), &websocket.DialOptions{
-			HTTPClient: &http.Client{
-				Transport: &http.Transport{
-					Proxy:       http.ProxyFromEnvironment,
-					Dial:        dialer.Dial,
-					DialContext: dialer.DialContext,
-				},
+		return l.dial(ctx, &u, hostname, options.header, dialer.DialContext)
+	})
+}
+
+func (l *linkWS) dial(ctx context.Context, u *url.URL, hostname string, header http.Header, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	wsconn, _, err := websocket.Dial(ctx, wsDialURL(u), &websocket.DialOptions{
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: dial,
 			},
-			Subprotocols: []string{"ruv-ws"},
-			Host:         hostname,
-		})
-		if err != nil {
-			return nil, err
-		}
-		return &linkWSConn{
-			Conn: websocket.NetConn(ctx, wsconn, websocket.MessageBinary),
-		}, nil
+		},
+		HTTPHeader:   header,
+		Subprotocols: []string{"ruv-ws"},
+		Host:         hostname,
 	})
+	if err != nil {
+		return nil, err
+	}
+	return &linkWSConn{
+		Conn: websocket.NetConn(ctx, wsconn, websocket.MessageBinary),
+	}, nil
 }
 
-func (l *linkWS) listen(ctx context.Context, url *url.URL, _ string) (net.Listener, error) {
+// Listen accepts WebSocket connections on the path of the URL, if one is
+// given, or on any path otherwise. Health checks are answered on "/health"
+// and "/healthz" below it.
+func (l *linkWS) Listen(ctx context.Context, url *url.URL, opts TransportOptions) (net.Listener, error) {
+	options, _ := opts.Options.(linkWSOptions)
 	nl, err := l.listenconfig.Listen(ctx, "tcp", url.Host)
 	if err != nil {
 		return nil, err
@@ -129,8 +353,10 @@ func (l *linkWS) listen(ctx context.Context, url *url.URL, _ string) (net.Listen
 
 	httpServer := &http.Server{
 		Handler: &wsServer{
-			ch:  ch,
-			ctx: ctx,
+			ch:      ch,
+			ctx:     ctx,
+			path:    strings.TrimSuffix(url.Path, "/"),
+			options: options,
 		},
 		BaseContext:  func(_ net.Listener) context.Context { return ctx },
 		ReadTimeout:  time.Second * 10,
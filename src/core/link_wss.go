@@ -30,43 +30,64 @@ func (l *links) newLinkWSS() *linkWSS {
 	return lwss
 }
 
-func (l *linkWSS) dial(ctx context.Context, url *url.URL, info linkInfo, options linkOptions) (net.Conn, error) {
-	tlsconfig := l.tlsconfig.Clone()
+// ParseOptions parses the options of the WebSocket transports, see
+// parseLinkWSOptions.
+func (l *linkWSS) ParseOptions(url *url.URL) (any, error) {
+	return parseLinkWSOptions(url)
+}
+
+func (l *linkWSS) Dial(ctx context.Context, url *url.URL, opts TransportOptions) (net.Conn, error) {
+	options, _ := opts.Options.(linkWSOptions)
+	// Behind an HTTP proxy, the proxy resolves the hostname, which we may not
+	// be able to.
+	proxy, err := httpProxyFromEnvironment(url)
+	if err != nil {
+		return nil, err
+	}
+	if proxy != nil {
+		return l.dial(ctx, url, url.Hostname(), options.header, func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return l.links.dialHTTPProxy(ctx, proxy, url.Host, opts.Interface)
+		})
+	}
 	return l.links.findSuitableIP(url, func(hostname string, ip net.IP, port int) (net.Conn, error) {
-		tlsconfig.ServerName = hostname
-		tlsconfig.MinVersion = tls.VersionTLS12
-		tlsconfig.MaxVersion = tls.VersionTLS13
 		u := *url
 		u.Host = net.JoinHostPort(ip.String(), fmt.Sprintf("%d", port))
 		addr := &net.TCPAddr{
 			IP:   ip,
 			Port: port,
 		}
-		dialer, err := l.tcp.dialerFor(addr, info.sintf)
+		dialer, err := l.tcp.dialerFor(addr, opts.Interface)
 		if err != nil {
 			return nil, err
 		}
-		wsconn, _, err := websocket.Dial(ctx, u.String(), &websocket.DialOptions{
-			HTTPClient: &http.Client{
-				Transport: &http.Transport{
-					Proxy:           http.ProxyFromEnvironment,
-					Dial:            dialer.Dial,
-					DialContext:     dialer.DialContext,
-					TLSClientConfig: tlsconfig,
-				},
+		return l.dial(ctx, &u, hostname, options.header, dialer.DialContext)
+	})
+}
+
+func (l *linkWSS) dial(ctx context.Context, u *url.URL, hostname string, header http.Header, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	tlsconfig := l.tlsconfig.Clone()
+	tlsconfig.ServerName = hostname
+	tlsconfig.MinVersion = tls.VersionTLS12
+	tlsconfig.MaxVersion = tls.VersionTLS13
+	wsconn, _, err := websocket.Dial(ctx, wsDialURL(u), &websocket.DialOptions{
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext:     dial,
+				TLSClientConfig: tlsconfig,
 			},
-			Subprotocols: []string{"ruv-ws"},
-			Host:         hostname,
-		})
-		if err != nil {
-			return nil, err
-		}
-		return &linkWSSConn{
-			Conn: websocket.NetConn(ctx, wsconn, websocket.MessageBinary),
-		}, nil
+		},
+		HTTPHeader:   header,
+		Subprotocols: []string{"ruv-ws"},
+		Host:         hostname,
 	})
+	if err != nil {
+		return nil, err
+	}
+	return &linkWSSConn{
+		Conn: websocket.NetConn(ctx, wsconn, websocket.MessageBinary),
+	}, nil
 }
 
-func (l *linkWSS) listen(ctx context.Context, url *url.URL, _ string) (net.Listener, error) {
+func (l *linkWSS) Listen(ctx context.Context, url *url.URL, _ TransportOptions) (net.Listener, error) {
 	return nil, fmt.Errorf("WSS listener not supported, use WS listener behind reverse proxy instead")
 }
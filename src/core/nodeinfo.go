@@ -113,6 +113,22 @@ func (m *nodeinfo) _sendReq(key keyArray, callback func(nodeinfo json.RawMessage
 	_, _ = m.proto.core.PacketConn.WriteTo([]byte{typeSessionProto, typeProtoNodeInfoRequest}, iwt.Addr(key[:]))
 }
 
+// getNodeInfo requests the nodeinfo of a remote node and waits for it.
+func (m *nodeinfo) getNodeInfo(key keyArray, timeout time.Duration) (json.RawMessage, error) {
+	ch := make(chan []byte, 1)
+	m.sendReq(nil, key, func(info json.RawMessage) {
+		ch <- info
+	})
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil, errors.New("Timed out waiting for response")
+	case info := <-ch:
+		return info, nil
+	}
+}
+
 func (m *nodeinfo) handleReq(from phony.Actor, key keyArray) {
 	m.Act(from, func() {
 		m._sendRes(key)
@@ -152,22 +168,14 @@ func (m *nodeinfo) nodeInfoAdminHandler(in json.RawMessage) (interface{}, error)
 		return nil, fmt.Errorf("Failed to decode public key: %w", err)
 	}
 	copy(key[:], kbs)
-	ch := make(chan []byte, 1)
-	m.sendReq(nil, key, func(info json.RawMessage) {
-		ch <- info
-	})
-	timer := time.NewTimer(6 * time.Second)
-	defer timer.Stop()
-	select {
-	case <-timer.C:
-		return nil, errors.New("Timed out waiting for response")
-	case info := <-ch:
-		var msg json.RawMessage
-		if err := msg.UnmarshalJSON(info); err != nil {
-			return nil, err
-		}
-		key := hex.EncodeToString(kbs[:])
-		res := GetNodeInfoResponse{key: msg}
-		return res, nil
+	info, err := m.getNodeInfo(key, 6*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	var msg json.RawMessage
+	if err := msg.UnmarshalJSON(info); err != nil {
+		return nil, err
 	}
+	res := GetNodeInfoResponse{hex.EncodeToString(kbs[:]): msg}
+	return res, nil
 }
@@ -2,27 +2,11 @@ package core
 
 import (
 	"crypto/ed25519"
-	"fmt"
 	"net"
-	"net/url"
 )
 
 func (c *Core) _applyOption(opt SetupOption) (err error) {
 	switch v := opt.(type) {
-	case Peer:
-		u, err := url.Parse(v.URI)
-		if err != nil {
-			return fmt.Errorf("unable to parse peering URI: %w", err)
-		}
-		err = c.links.add(u, v.SourceInterface, linkTypePersistent)
-		switch err {
-		case ErrLinkAlreadyConfigured:
-			// Don't return this error, otherwise we'll panic at startup
-			// if there are multiple of the same peer configured
-			return nil
-		default:
-			return err
-		}
 	case ListenAddress:
 		c.config._listeners[v] = struct{}{}
 	case PeerFilter:
@@ -35,6 +19,10 @@ func (c *Core) _applyOption(opt SetupOption) (err error) {
 		pk := [32]byte{}
 		copy(pk[:], v)
 		c.config._allowedPublicKeys[pk] = struct{}{}
+	case PeerStateFile:
+		c.config.peerStateFile = v
+	case CustomTransport:
+		c.config.transports = append(c.config.transports, v)
 	}
 	return
 }
@@ -52,6 +40,14 @@ type NodeInfo map[string]interface{}
 type NodeInfoPrivacy bool
 type AllowedPublicKey ed25519.PublicKey
 type PeerFilter func(net.IP) bool
+type PeerStateFile string
+
+// CustomTransport registers a Transport when the node is created, so that
+// the configured peers and listeners can use it. See Core.RegisterTransport.
+type CustomTransport struct {
+	Scheme    string
+	Transport Transport
+}
 
 func (a ListenAddress) isSetupOption()    {}
 func (a Peer) isSetupOption()             {}
@@ -59,3 +55,5 @@ func (a NodeInfo) isSetupOption()         {}
 func (a NodeInfoPrivacy) isSetupOption()  {}
 func (a AllowedPublicKey) isSetupOption() {}
 func (a PeerFilter) isSetupOption()       {}
+func (a PeerStateFile) isSetupOption()    {}
+func (a CustomTransport) isSetupOption()  {}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	iwt "github.com/Arceliar/ironwood/types"
+)
+
+// Path MTU discovery. Links carry packets of any size up to the MTU of the
+// core, but something along a path, e.g. a WebSocket proxy or a SOCKS server,
+// may silently drop large ones. Neither links nor the nodes along a path know
+// or report such limits, so they can't be learned from them. Instead, each
+// path that traffic is sent on is probed end to end, and the largest probe
+// that the remote node acknowledges is the MTU of the path. Until then, and
+// for remote nodes that don't answer probes at all, the MTU of the core is
+// assumed.
+//
+// To keep the probes cheap, each round only sends a few sizes. The first
+// rounds climb from the smallest size for as long as everything gets through,
+// so large probes are only sent on paths that carry them. Later rounds only
+// check the learned MTU, the smallest size and the next size up.
+
+const (
+	pathMTUProbeTimeout   = 3 * time.Second  // How long to wait for acknowledgements
+	pathMTURefresh        = 10 * time.Minute // How often a path in use is probed again
+	pathMTUExpiry         = time.Hour        // When an unused path is forgotten
+	pathMTUProbesPerRound = 3                // How many sizes a round climbs by
+)
+
+// The sizes that are probed, smallest first. Those above the MTU of the core
+// are left out and the MTU of the core itself is always the largest.
+var pathMTUSizes = []uint64{1280, 1500, 4096, 9000, 16384, 32768}
+
+// pathMTU is not an actor, unlike the rest of the protocol handler, as it is
+// asked for the MTU of a path for every packet that is sent.
+type pathMTU struct {
+	proto  *protoHandler
+	mutex  sync.Mutex
+	paths  map[keyArray]*pathInfo
+	nextID uint32
+}
+
+type pathInfo struct {
+	key     keyArray
+	mtu     uint64    // Of IP packets, like Core.MTU
+	learned bool      // Whether the MTU has been acknowledged by the remote node
+	probed  time.Time // When the last round of probes was sent
+	used    time.Time
+	round   uint32   // ID of the round of probes in progress, or zero
+	sizes   []uint64 // Sizes probed in that round, smallest first
+	floor   uint64   // Largest size known to get through before that round
+	best    uint64   // Largest size acknowledged so far, at least floor
+	confirm bool     // Whether that round confirms a smaller MTU
+}
+
+func (m *pathMTU) init(proto *protoHandler) {
+	m.proto = proto
+	m.paths = make(map[keyArray]*pathInfo)
+}
+
+// get returns the MTU of the path to the key, starting a round of probes if
+// the path is new or hasn't been probed for a while.
+func (m *pathMTU) get(key keyArray) uint64 {
+	now := time.Now()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	info := m.paths[key]
+	if info == nil {
+		for k, i := range m.paths {
+			if now.Sub(i.used) > pathMTUExpiry {
+				delete(m.paths, k)
+			}
+		}
+		info = &pathInfo{key: key, mtu: m.proto.core.MTU()}
+		m.paths[key] = info
+	}
+	info.used = now
+	if info.round == 0 && (info.probed.IsZero() || now.Sub(info.probed) > pathMTURefresh) {
+		sizes := m.sizes()
+		if !info.learned {
+			m._probe(info, pathMTUAbove(sizes, 0, pathMTUProbesPerRound), 0, now)
+		} else {
+			// The smallest size is included in case the learned MTU no
+			// longer gets through, and the next size up in case more does.
+			refresh := []uint64{sizes[0]}
+			if info.mtu > sizes[0] {
+				refresh = append(refresh, info.mtu)
+			}
+			refresh = append(refresh, pathMTUAbove(sizes, info.mtu, 1)...)
+			m._probe(info, refresh, 0, now)
+		}
+	}
+	return info.mtu
+}
+
+// info returns what is known about the path to the key, without probing it.
+func (m *pathMTU) info(key keyArray) (mtu uint64, learned bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if info := m.paths[key]; info != nil {
+		return info.mtu, info.learned
+	}
+	return m.proto.core.MTU(), false
+}
+
+// sizes returns the sizes that may be probed, smallest first.
+func (m *pathMTU) sizes() []uint64 {
+	largest := m.proto.core.MTU()
+	var sizes []uint64
+	for _, size := range pathMTUSizes {
+		if size < largest {
+			sizes = append(sizes, size)
+		}
+	}
+	return append(sizes, largest)
+}
+
+// pathMTUAbove returns up to n of the sizes that are larger than size.
+func pathMTUAbove(sizes []uint64, size uint64, n int) []uint64 {
+	var above []uint64
+	for _, s := range sizes {
+		if s > size && len(above) < n {
+			above = append(above, s)
+		}
+	}
+	return above
+}
+
+// _probe sends a round of probes of the given sizes, which ends when the
+// largest one has been acknowledged or when the timeout has passed. Must be
+// called with the mutex held.
+func (m *pathMTU) _probe(info *pathInfo, sizes []uint64, floor uint64, now time.Time) {
+	m.nextID++
+	if m.nextID == 0 {
+		m.nextID++
+	}
+	id := m.nextID
+	info.round, info.sizes, info.floor, info.best, info.probed = id, sizes, floor, floor, now
+	go func() {
+		for _, size := range sizes {
+			// The probe is as big as a traffic packet that holds an IP packet
+			// of the given size.
+			bs := make([]byte, 1+size)
+			bs[0], bs[1] = typeSessionProto, typeProtoPathMTUProbe
+			binary.BigEndian.PutUint32(bs[2:], id)
+			_, _ = m.proto.core.PacketConn.WriteTo(bs, iwt.Addr(info.key[:]))
+		}
+	}()
+	time.AfterFunc(pathMTUProbeTimeout, func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if info.round == id {
+			m._finish(info)
+		}
+	})
+}
+
+// _finish ends the round of probes. If everything got through, the next
+// round carries on with larger sizes. If nothing was acknowledged, the remote
+// node may not answer probes or the path may be down, so the MTU is kept. A
+// smaller MTU is only taken after the round has been repeated, as probes are
+// also lost for other reasons, e.g. all but the last one are dropped while
+// the session with the remote node is being set up.
+func (m *pathMTU) _finish(info *pathInfo) {
+	if top := info.sizes[len(info.sizes)-1]; info.best == top {
+		if next := pathMTUAbove(m.sizes(), top, pathMTUProbesPerRound); len(next) > 0 {
+			info.confirm = false
+			m._probe(info, next, top, time.Now())
+			return
+		}
+	}
+	if info.best != 0 && info.best < info.mtu && !info.confirm {
+		info.confirm = true
+		m._probe(info, info.sizes, info.floor, time.Now())
+		return
+	}
+	info.confirm = false
+	if info.best != 0 {
+		if info.best != info.mtu {
+			m.proto.core.log.Debugf("Path MTU changed from %d to %d", info.mtu, info.best)
+		}
+		info.mtu, info.learned = info.best, true
+	}
+	info.round = 0
+}
+
+func (m *pathMTU) handleProbe(key keyArray, bs []byte) {
+	if len(bs) < 4 {
+		return
+	}
+	res := make([]byte, 2+8)
+	res[0], res[1] = typeSessionProto, typeProtoPathMTUAck
+	copy(res[2:6], bs[:4])
+	// Both type bytes have been removed from the probe, one of which stands
+	// in for the type of a traffic packet.
+	binary.BigEndian.PutUint32(res[6:], uint32(len(bs)+1))
+	_, _ = m.proto.core.PacketConn.WriteTo(res, iwt.Addr(key[:]))
+}
+
+func (m *pathMTU) handleAck(key keyArray, bs []byte) {
+	if len(bs) < 8 {
+		return
+	}
+	id := binary.BigEndian.Uint32(bs)
+	size := uint64(binary.BigEndian.Uint32(bs[4:]))
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	info := m.paths[key]
+	if info == nil || info.round != id || size > m.proto.core.MTU() {
+		return
+	}
+	if size > info.best {
+		info.best = size
+	}
+	if size == info.sizes[len(info.sizes)-1] {
+		m._finish(info) // Nothing bigger was probed
+	}
+}
+
+// PathMTU returns the largest IP packet that is known to get through to the
+// node with the given key, which is at most MTU. Paths are probed in the
+// background while traffic is sent on them, so this is cheap to call for
+// every packet.
+func (c *Core) PathMTU(key ed25519.PublicKey) uint64 {
+	var k keyArray
+	copy(k[:], key)
+	return c.proto.pathMTU.get(k)
+}
@@ -0,0 +1,390 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Arceliar/phony"
+)
+
+// How often the peer state is written to disk while the node is running.
+const peerStateInterval = time.Second * 30
+
+// How far apart the persistent peers are dialled at startup, in order of
+// their rank, so that the most reliable peers get the first chance to come
+// up, and the longest that any peer waits.
+const (
+	peerStateDialStagger  = time.Second
+	peerStateDialMaxDelay = time.Second * 10
+)
+
+// PeerStateEntry records what we have learned about a persistent peering,
+// either from the configuration or added at runtime through AddPeer.
+type PeerStateEntry struct {
+	URI           string        `json:"uri"`
+	Interface     string        `json:"interface,omitempty"`
+	Runtime       bool          `json:"runtime,omitempty"`
+	LastError     string        `json:"last_error,omitempty"`
+	LastErrorTime time.Time     `json:"last_error_time"`
+	LastUp        time.Time     `json:"last_up"`
+	Uptime        time.Duration `json:"uptime,omitempty"`   // Total across all connections
+	Failures      uint64        `json:"failures,omitempty"` // Halved on each new connection
+	up            time.Time     // When the current connection came up
+	counted       time.Duration // Uptime of the current connection already added
+}
+
+// reliability returns a score that is used to sort peers, so that peers
+// which have stayed up for longest with the fewest failures are tried first.
+func (e *PeerStateEntry) reliability() float64 {
+	if e == nil {
+		return 0
+	}
+	return e.Uptime.Seconds() / float64(1+e.Failures)
+}
+
+type peerStateFile struct {
+	Peers []*PeerStateEntry `json:"peers"`
+}
+
+// peerState keeps track of peers that were added at runtime and of the
+// connection history of persistent peers, and periodically writes them to
+// the peer state file so that they can be restored after a restart.
+type peerState struct {
+	phony.Inbox
+	core     *Core
+	path     string
+	_peers   map[linkInfo]*PeerStateEntry
+	_runtime map[linkInfo]string // Full URIs of runtime peers, with options
+}
+
+func (s *peerState) init(c *Core, path string) error {
+	s.core = c
+	s.path = path
+	s._peers = make(map[linkInfo]*PeerStateEntry)
+	s._runtime = make(map[linkInfo]string)
+	if s.path == "" {
+		return nil
+	}
+	f, err := os.ReadFile(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to read peer state: %w", err)
+	}
+	var state peerStateFile
+	if err := json.Unmarshal(f, &state); err != nil {
+		return fmt.Errorf("failed to parse peer state: %w", err)
+	}
+	for _, entry := range state.Peers {
+		u, err := url.Parse(entry.URI)
+		if err != nil {
+			continue
+		}
+		lu := urlForLinkInfo(*u)
+		info := linkInfo{
+			uri:   lu.String(),
+			sintf: entry.Interface,
+		}
+		s._peers[info] = entry
+		if entry.Runtime {
+			s._runtime[info] = entry.URI
+		}
+	}
+	return nil
+}
+
+func (s *peerState) enabled() bool {
+	return s.path != ""
+}
+
+// sortPeers orders the given peers so that the most reliable peers, based
+// on the stored history, come first. Peers with no history keep their order.
+func (s *peerState) sortPeers(peers []Peer) {
+	if !s.enabled() {
+		return
+	}
+	phony.Block(s, func() {
+		score := func(p Peer) float64 {
+			u, err := url.Parse(p.URI)
+			if err != nil {
+				return 0
+			}
+			lu := urlForLinkInfo(*u)
+			return s._peers[linkInfo{uri: lu.String(), sintf: p.SourceInterface}].reliability()
+		}
+		slices.SortStableFunc(peers, func(a, b Peer) int {
+			sa, sb := score(a), score(b)
+			switch {
+			case sa > sb:
+				return -1
+			case sa < sb:
+				return 1
+			default:
+				return 0
+			}
+		})
+	})
+}
+
+// dialDelay returns how long the peer of the given rank, as sorted by
+// sortPeers, waits before it is first dialled.
+func (s *peerState) dialDelay(rank int) time.Duration {
+	if !s.enabled() {
+		return 0
+	}
+	return min(time.Duration(rank)*peerStateDialStagger, peerStateDialMaxDelay)
+}
+
+// runtimePeers returns the peers that were added at runtime before the
+// last shutdown, most reliable first.
+func (s *peerState) runtimePeers() []Peer {
+	var peers []Peer
+	phony.Block(s, func() {
+		for info, uri := range s._runtime {
+			peers = append(peers, Peer{URI: uri, SourceInterface: info.sintf})
+		}
+	})
+	slices.SortStableFunc(peers, func(a, b Peer) int {
+		return strings.Compare(a.URI, b.URI)
+	})
+	s.sortPeers(peers)
+	return peers
+}
+
+func (s *peerState) addRuntime(u *url.URL, sintf string) {
+	if !s.enabled() {
+		return
+	}
+	lu := urlForLinkInfo(*u)
+	info := linkInfo{uri: lu.String(), sintf: sintf}
+	uri := u.String()
+	s.Act(nil, func() {
+		s._runtime[info] = uri
+	})
+}
+
+func (s *peerState) removeRuntime(u *url.URL, sintf string) {
+	if !s.enabled() {
+		return
+	}
+	lu := urlForLinkInfo(*u)
+	info := linkInfo{uri: lu.String(), sintf: sintf}
+	s.Act(nil, func() {
+		delete(s._runtime, info)
+		delete(s._peers, info)
+	})
+}
+
+// start begins periodically writing the peer state to disk.
+func (s *peerState) start() {
+	if !s.enabled() {
+		return
+	}
+	time.AfterFunc(peerStateInterval, func() {
+		select {
+		case <-s.core.ctx.Done():
+			return
+		default:
+		}
+		if err := s.save(); err != nil {
+			s.core.log.Warnln("Failed to save peer state:", err)
+		}
+		s.start()
+	})
+}
+
+// _update adds the current link states to the stored history. Uptime is
+// accumulated across connections and every new error counts as a failure.
+// Only persistent links are tracked, so peers which are no longer
+// configured are dropped.
+func (s *peerState) _update(links map[linkInfo]PeerStateEntry) {
+	peers := make(map[linkInfo]*PeerStateEntry, len(links))
+	for info, current := range links {
+		entry := s._peers[info]
+		if entry == nil {
+			entry = &PeerStateEntry{}
+		}
+		entry.URI = info.uri
+		entry.Interface = info.sintf
+		entry.Runtime = false
+		if uri, ok := s._runtime[info]; ok {
+			entry.URI = uri
+			entry.Runtime = true
+		}
+		if !current.up.IsZero() {
+			if !entry.up.Equal(current.up) {
+				// A new connection has come up since the last update, so
+				// older failures count for less.
+				entry.up, entry.counted = current.up, 0
+				entry.Failures /= 2
+			}
+			entry.LastUp = current.LastUp
+			entry.Uptime += current.Uptime - entry.counted
+			entry.counted = current.Uptime
+		}
+		if current.LastErrorTime.After(entry.LastErrorTime) {
+			entry.LastError = current.LastError
+			entry.LastErrorTime = current.LastErrorTime
+			entry.Failures++
+		}
+		peers[info] = entry
+	}
+	s._peers = peers
+}
+
+func (s *peerState) snapshot() {
+	links := map[linkInfo]PeerStateEntry{}
+	now := time.Now()
+	phony.Block(&s.core.links, func() {
+		for info, state := range s.core.links._links {
+			if state == nil || state.linkType != linkTypePersistent {
+				continue
+			}
+			var entry PeerStateEntry
+			if state._err != nil {
+				entry.LastError = state._err.Error()
+				entry.LastErrorTime = state._errtime
+			}
+			if c := state._conn; c != nil {
+				entry.LastUp = now
+				entry.Uptime = now.Sub(c.up)
+				entry.up = c.up
+			}
+			links[info] = entry
+		}
+	})
+	phony.Block(s, func() {
+		s._update(links)
+	})
+}
+
+// save updates the peer state from the current links and then writes it
+// to disk atomically, by writing to a temporary file and renaming it.
+func (s *peerState) save() error {
+	if !s.enabled() {
+		return nil
+	}
+	s.snapshot()
+	var state peerStateFile
+	phony.Block(s, func() {
+		state.Peers = s._entries()
+	})
+	bs, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // nolint:errcheck
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(bs); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+func (s *peerState) _entries() []*PeerStateEntry {
+	entries := make([]*PeerStateEntry, 0, len(s._peers))
+	for _, entry := range s._peers {
+		e := *entry
+		entries = append(entries, &e)
+	}
+	slices.SortStableFunc(entries, func(a, b *PeerStateEntry) int {
+		if d := strings.Compare(a.URI, b.URI); d != 0 {
+			return d
+		}
+		return strings.Compare(a.Interface, b.Interface)
+	})
+	return entries
+}
+
+// clear forgets all stored peer history, including runtime peers, and
+// removes the peer state file.
+func (s *peerState) clear() error {
+	if !s.enabled() {
+		return nil
+	}
+	phony.Block(s, func() {
+		s._peers = make(map[linkInfo]*PeerStateEntry)
+		s._runtime = make(map[linkInfo]string)
+	})
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Admin socket stuff
+
+type GetPeerStateRequest struct{}
+
+type GetPeerStateResponse struct {
+	Path  string           `json:"path"`
+	Peers []PeerStateEntry `json:"peers"`
+}
+
+type ClearPeerStateRequest struct{}
+
+type ClearPeerStateResponse struct{}
+
+func (s *peerState) getPeerStateHandler(in json.RawMessage) (interface{}, error) {
+	var req GetPeerStateRequest
+	if err := json.Unmarshal(in, &req); err != nil {
+		return nil, err
+	}
+	if !s.enabled() {
+		return nil, fmt.Errorf("peer state file is not configured")
+	}
+	s.snapshot()
+	res := &GetPeerStateResponse{
+		Path:  s.path,
+		Peers: []PeerStateEntry{},
+	}
+	phony.Block(s, func() {
+		for _, entry := range s._entries() {
+			// Don't reveal passwords or other options through the
+			// admin socket.
+			if u, err := url.Parse(entry.URI); err == nil {
+				lu := urlForLinkInfo(*u)
+				entry.URI = lu.Redacted()
+			}
+			res.Peers = append(res.Peers, *entry)
+		}
+	})
+	return res, nil
+}
+
+func (s *peerState) clearPeerStateHandler(in json.RawMessage) (interface{}, error) {
+	var req ClearPeerStateRequest
+	if err := json.Unmarshal(in, &req); err != nil {
+		return nil, err
+	}
+	if !s.enabled() {
+		return nil, fmt.Errorf("peer state file is not configured")
+	}
+	if err := s.clear(); err != nil {
+		return nil, err
+	}
+	return &ClearPeerStateResponse{}, nil
+}
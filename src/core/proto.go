@@ -35,8 +35,10 @@ type keyArray [ed25519.PublicKeySize]byte
 type protoHandler struct {
 	phony.Inbox
 
-	core     *Core
-	nodeinfo nodeinfo
+	core        *Core
+	nodeinfo    nodeinfo
+	remoteAdmin remoteAdmin
+	pathMTU     pathMTU
 
 	selfRequests  map[keyArray]*reqInfo
 	peersRequests map[keyArray]*reqInfo
@@ -46,6 +48,8 @@ type protoHandler struct {
 func (p *protoHandler) init(core *Core) {
 	p.core = core
 	p.nodeinfo.init(p)
+	p.remoteAdmin.init(p)
+	p.pathMTU.init(p)
 
 	p.selfRequests = make(map[keyArray]*reqInfo)
 	p.peersRequests = make(map[keyArray]*reqInfo)
@@ -64,6 +68,14 @@ func (p *protoHandler) handleProto(from phony.Actor, key keyArray, bs []byte) {
 		p.nodeinfo.handleReq(p, key)
 	case typeProtoNodeInfoResponse:
 		p.nodeinfo.handleRes(p, key, bs[1:])
+	case typeProtoAdminRequest:
+		p.remoteAdmin.handleRequest(p, key, bs[1:])
+	case typeProtoAdminResponse:
+		p.remoteAdmin.handleResponse(p, key, bs[1:])
+	case typeProtoPathMTUProbe:
+		p.pathMTU.handleProbe(key, bs[1:])
+	case typeProtoPathMTUAck:
+		p.pathMTU.handleAck(key, bs[1:])
 	case typeProtoDebug:
 		p.handleDebug(from, key, bs[1:])
 	}
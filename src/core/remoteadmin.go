@@ -0,0 +1,260 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	iwt "github.com/Arceliar/ironwood/types"
+	"github.com/Arceliar/phony"
+)
+
+// How far the timestamp of a remote admin request may be from our own clock.
+// Nonces are remembered for twice this long, so that a request can't be
+// replayed while its timestamp would still be accepted.
+const remoteAdminMaxSkew = time.Minute * 2
+
+// How long to wait for the response to a remote admin request.
+const remoteAdminTimeout = time.Second * 10
+
+const remoteAdminNonceSize = 16
+
+// remoteAdminRequest is signed by the sender. The target key and the nonce
+// stop it from being replayed to other nodes or to the same node again.
+type remoteAdminRequest struct {
+	ID        uint64          `json:"id"`
+	Target    []byte          `json:"target"`
+	Time      int64           `json:"time"` // Unix time in nanoseconds
+	Nonce     []byte          `json:"nonce"`
+	Name      string          `json:"request"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type remoteAdminResponse struct {
+	ID       uint64          `json:"id"`
+	Error    string          `json:"error,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// RemoteAdminHandler is called for each remote admin request that has a
+// valid signature from an operator. It is up to the handler to decide
+// whether the operator is allowed to make the particular request.
+type RemoteAdminHandler func(from ed25519.PublicKey, name string, args json.RawMessage) (interface{}, error)
+
+// RemoteAdminOperator reports whether the node with the given key may send
+// remote admin requests at all. Requests from other nodes are dropped
+// without a response before anything else is done with them.
+type RemoteAdminOperator func(from ed25519.PublicKey) bool
+
+type remoteAdmin struct {
+	phony.Inbox
+	proto     *protoHandler
+	_handler  RemoteAdminHandler
+	_operator RemoteAdminOperator
+	_nonces   map[[remoteAdminNonceSize]byte]time.Time
+	_nextID   uint64
+	_requests map[uint64]*remoteAdminCall
+}
+
+type remoteAdminCall struct {
+	key      keyArray
+	callback func(*remoteAdminResponse)
+}
+
+func (m *remoteAdmin) init(proto *protoHandler) {
+	m.proto = proto
+	m._nonces = make(map[[remoteAdminNonceSize]byte]time.Time)
+	m._requests = make(map[uint64]*remoteAdminCall)
+}
+
+func (m *remoteAdmin) setHandler(operator RemoteAdminOperator, handler RemoteAdminHandler) {
+	phony.Block(m, func() {
+		m._operator = operator
+		m._handler = handler
+	})
+}
+
+// remoteAdminTranscript returns the bytes that are signed for a request.
+func remoteAdminTranscript(body []byte) []byte {
+	return append([]byte("ruvchain remote admin"), body...)
+}
+
+func (m *remoteAdmin) sendRequest(key keyArray, name string, args json.RawMessage, callback func(*remoteAdminResponse)) error {
+	nonce := make([]byte, remoteAdminNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	var err error
+	phony.Block(m, func() {
+		m._nextID++
+		req := remoteAdminRequest{
+			ID:        m._nextID,
+			Target:    key[:],
+			Time:      time.Now().UnixNano(),
+			Nonce:     nonce,
+			Name:      name,
+			Arguments: args,
+		}
+		var body []byte
+		if body, err = json.Marshal(req); err != nil {
+			return
+		}
+		sig := ed25519.Sign(m.proto.core.secret, remoteAdminTranscript(body))
+		bs := append([]byte{typeSessionProto, typeProtoAdminRequest}, sig...)
+		bs = append(bs, body...)
+		if uint64(len(bs)) > m.proto.core.PacketConn.MTU() {
+			err = errors.New("request is too large")
+			return
+		}
+		id := req.ID
+		m._requests[id] = &remoteAdminCall{key: key, callback: callback}
+		time.AfterFunc(remoteAdminTimeout, func() {
+			m.Act(nil, func() {
+				delete(m._requests, id)
+			})
+		})
+		_, err = m.proto.core.PacketConn.WriteTo(bs, iwt.Addr(key[:]))
+	})
+	return err
+}
+
+func (m *remoteAdmin) handleRequest(from phony.Actor, key keyArray, bs []byte) {
+	m.Act(from, func() {
+		m._handleRequest(key, bs)
+	})
+}
+
+func (m *remoteAdmin) _handleRequest(key keyArray, bs []byte) {
+	if m._handler == nil || m._operator == nil || len(bs) < ed25519.SignatureSize {
+		// Remote admin is not enabled, so stay quiet.
+		return
+	}
+	if !m._operator(ed25519.PublicKey(key[:])) {
+		// Don't remember anything or answer, so that other nodes can't use
+		// up memory or fill the log.
+		m.proto.core.log.Debugf("Dropped remote admin request from %x, which is not an operator", key[:])
+		return
+	}
+	sig, body := bs[:ed25519.SignatureSize], bs[ed25519.SignatureSize:]
+	if !ed25519.Verify(key[:], remoteAdminTranscript(body), sig) {
+		m.proto.core.log.Debugf("Dropped remote admin request from %x with an invalid signature", key[:])
+		return
+	}
+	var req remoteAdminRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return
+	}
+	if err := m._checkReplay(&req); err != nil {
+		m.proto.core.log.Warnf("Dropped remote admin request from %x: %s", key[:], err)
+		m._sendResponse(key, &remoteAdminResponse{ID: req.ID, Error: err.Error()})
+		return
+	}
+	handler := m._handler
+	go func() {
+		res := &remoteAdminResponse{ID: req.ID}
+		switch result, err := handler(ed25519.PublicKey(key[:]), req.Name, req.Arguments); {
+		case err != nil:
+			res.Error = err.Error()
+		default:
+			if res.Response, err = json.Marshal(result); err != nil {
+				res.Error = fmt.Sprintf("failed to marshal response: %s", err)
+			}
+		}
+		m.Act(nil, func() {
+			m._sendResponse(key, res)
+		})
+	}()
+}
+
+// _checkReplay makes sure that the request is meant for this node, is
+// recent and hasn't been seen before.
+func (m *remoteAdmin) _checkReplay(req *remoteAdminRequest) error {
+	now := time.Now()
+	for nonce, seen := range m._nonces {
+		if now.Sub(seen) > 2*remoteAdminMaxSkew {
+			delete(m._nonces, nonce)
+		}
+	}
+	var nonce [remoteAdminNonceSize]byte
+	switch {
+	case !m.proto.core.public.Equal(ed25519.PublicKey(req.Target)):
+		return errors.New("request is for another node")
+	case len(req.Nonce) != remoteAdminNonceSize:
+		return errors.New("request has an invalid nonce")
+	}
+	copy(nonce[:], req.Nonce)
+	skew := now.Sub(time.Unix(0, req.Time))
+	switch {
+	case skew > remoteAdminMaxSkew || skew < -remoteAdminMaxSkew:
+		return errors.New("request timestamp is too far from the node's clock")
+	case !m._nonces[nonce].IsZero():
+		return errors.New("request has already been seen")
+	}
+	m._nonces[nonce] = now
+	return nil
+}
+
+func (m *remoteAdmin) _sendResponse(key keyArray, res *remoteAdminResponse) {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+	bs := append([]byte{typeSessionProto, typeProtoAdminResponse}, body...)
+	if uint64(len(bs)) > m.proto.core.PacketConn.MTU() {
+		body, _ = json.Marshal(&remoteAdminResponse{ID: res.ID, Error: "response is too large"})
+		bs = append([]byte{typeSessionProto, typeProtoAdminResponse}, body...)
+	}
+	_, _ = m.proto.core.PacketConn.WriteTo(bs, iwt.Addr(key[:]))
+}
+
+func (m *remoteAdmin) handleResponse(from phony.Actor, key keyArray, bs []byte) {
+	m.Act(from, func() {
+		var res remoteAdminResponse
+		if err := json.Unmarshal(bs, &res); err != nil {
+			return
+		}
+		call := m._requests[res.ID]
+		if call == nil || call.key != key {
+			return
+		}
+		delete(m._requests, res.ID)
+		call.callback(&res)
+	})
+}
+
+// RemoteAdmin sends an admin request to a remote node, signed with this
+// node's key, and waits for the response. The remote node must have this
+// node's public key configured as an operator.
+func (c *Core) RemoteAdmin(key ed25519.PublicKey, name string, args json.RawMessage) (json.RawMessage, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key length")
+	}
+	var k keyArray
+	copy(k[:], key)
+	ch := make(chan *remoteAdminResponse, 1)
+	if err := c.proto.remoteAdmin.sendRequest(k, name, args, func(res *remoteAdminResponse) {
+		ch <- res
+	}); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(remoteAdminTimeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil, errors.New("timed out waiting for response")
+	case res := <-ch:
+		if res.Error != "" {
+			return nil, errors.New(res.Error)
+		}
+		return res.Response, nil
+	}
+}
+
+// SetRemoteAdminHandler enables answering remote admin requests from the
+// nodes that operator accepts. Requests are ignored until a handler is set.
+func (c *Core) SetRemoteAdminHandler(operator RemoteAdminOperator, handler RemoteAdminHandler) {
+	c.proto.remoteAdmin.setHandler(operator, handler)
+}
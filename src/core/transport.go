@@ -0,0 +1,89 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/Arceliar/phony"
+)
+
+// Transport carries links to other nodes, for the peering and listener URLs
+// with the scheme that it is registered for. The built-in schemes are
+// transports too. Connections are authenticated and encrypted by the node
+// itself, so a transport only needs to carry a stream of bytes reliably and
+// in order.
+type Transport interface {
+	// Dial connects to the peer given by the URL.
+	Dial(ctx context.Context, u *url.URL, opts TransportOptions) (net.Conn, error)
+	// Listen accepts connections from peers on the address given by the
+	// URL, until the listener is closed.
+	Listen(ctx context.Context, u *url.URL, opts TransportOptions) (net.Listener, error)
+}
+
+// TransportOptions are passed to a Transport along with the URL.
+type TransportOptions struct {
+	Interface string // Source interface, e.g. from InterfacePeers, if any
+	Options   any    // Returned by ParseOptions, if the Transport has any
+}
+
+// TransportOptionParser is implemented by a Transport that takes options of
+// its own in the query of the URL. They are parsed when a peer or listener is
+// added, so that mistakes are reported straight away rather than on every
+// connection attempt.
+type TransportOptionParser interface {
+	ParseOptions(u *url.URL) (any, error)
+}
+
+// ConnMetadata may be implemented by the connections of a Transport to
+// describe them, e.g. which serial port a link is using. The metadata is
+// reported in PeerInfo.
+type ConnMetadata interface {
+	Metadata() map[string]string
+}
+
+// RegisterTransport adds a Transport for peering and listener URLs with the
+// given scheme. Peers and listeners that are configured when the node is
+// created need their transports to be given with the CustomTransport option
+// instead, as they are started by New.
+func (c *Core) RegisterTransport(scheme string, t Transport) error {
+	var err error
+	phony.Block(&c.links, func() {
+		err = c.links._registerTransport(scheme, t)
+	})
+	return err
+}
+
+func (l *links) _registerTransport(scheme string, t Transport) error {
+	scheme = strings.ToLower(scheme)
+	switch {
+	case t == nil:
+		return fmt.Errorf("no transport given for scheme %q", scheme)
+	case scheme == "" || strings.ContainsAny(scheme, ":/"):
+		return fmt.Errorf("invalid transport scheme %q", scheme)
+	}
+	if _, ok := l._transports[scheme]; ok {
+		return fmt.Errorf("transport for scheme %q is already registered", scheme)
+	}
+	l._transports[scheme] = t
+	return nil
+}
+
+// _transportFor returns the Transport for the scheme of the URL, along with
+// the options that it has parsed from it.
+func (l *links) _transportFor(u *url.URL) (Transport, any, error) {
+	t, ok := l._transports[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, nil, ErrLinkUnrecognisedSchema
+	}
+	var options any
+	if p, ok := t.(TransportOptionParser); ok {
+		var err error
+		if options, err = p.ParseOptions(u); err != nil {
+			return nil, nil, err
+		}
+	}
+	return t, options, nil
+}
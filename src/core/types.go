@@ -12,5 +12,9 @@ const (
 	typeProtoDummy = iota
 	typeProtoNodeInfoRequest
 	typeProtoNodeInfoResponse
+	typeProtoAdminRequest
+	typeProtoAdminResponse
+	typeProtoPathMTUProbe
+	typeProtoPathMTUAck
 	typeProtoDebug = 255
 )
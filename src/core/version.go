@@ -7,8 +7,10 @@ package core
 import (
 	"bytes"
 	"crypto/ed25519"
+	"crypto/tls"
 	"encoding/binary"
 	"io"
+	"net"
 
 	"golang.org/x/crypto/blake2b"
 )
@@ -17,24 +19,41 @@ import (
 // It must always begin with the 4 bytes "meta" and a wire formatted uint64 major version number.
 // The current version also includes a minor version number, and the box/sig/link keys that need to be exchanged to open a connection.
 type version_metadata struct {
-	majorVer  uint16
-	minorVer  uint16
-	publicKey ed25519.PublicKey
-	priority  uint8
+	majorVer        uint16
+	minorVer        uint16
+	publicKey       ed25519.PublicKey
+	priority        uint8
+	currentMinorVer uint16
+	nonce           []byte
 }
 
 const (
 	ProtocolVersionMajor uint16 = 0
-	ProtocolVersionMinor uint16 = 5
+	ProtocolVersionMinor uint16 = 6
+	// ProtocolVersionMinorCompatible is the minor version that is sent in
+	// the version metadata and multicast beacons. Nodes older than minor
+	// version 6 require an exact match on it, so the current minor version
+	// is sent separately and newer features are negotiated using that.
+	ProtocolVersionMinorCompatible uint16 = 5
 )
 
+// The minor version from which nodes prove possession of their private key
+// by signing the handshake transcript.
+const protocolVersionMinorProof uint16 = 6
+
+// The size of the random handshake nonce.
+const metaNonceSize = 32
+
 // Once a major/minor version is released, it is not safe to change any of these
 // (including their ordering), it is only safe to add new ones.
 const (
-	metaVersionMajor uint16 = iota // uint16
-	metaVersionMinor               // uint16
-	metaPublicKey                  // [32]byte
-	metaPriority                   // uint8
+	metaVersionMajor        uint16 = iota // uint16
+	metaVersionMinor                      // uint16
+	metaPublicKey                         // [32]byte
+	metaPriority                          // uint8
+	metaVersionMinorCurrent               // uint16
+	metaNonce                             // [32]byte
+	metaSignature                         // [64]byte, over all preceding fields
 )
 
 type handshakeError string
@@ -46,12 +65,15 @@ const ErrHandshakeInvalidLength = handshakeError("invalid handshake length, poss
 const ErrHandshakeInvalidPassword = handshakeError("invalid password supplied, check your config")
 const ErrHandshakeHashFailure = handshakeError("invalid hash length")
 const ErrHandshakeIncorrectPassword = handshakeError("password does not match remote side")
+const ErrHandshakeInvalidProof = handshakeError("remote side failed to prove possession of its key")
+const ErrHandshakeInvalidSignature = handshakeError("remote side's metadata signature is invalid")
 
 // Gets a base metadata with no keys set, but with the correct version numbers.
 func version_getBaseMetadata() version_metadata {
 	return version_metadata{
-		majorVer: ProtocolVersionMajor,
-		minorVer: ProtocolVersionMinor,
+		majorVer:        ProtocolVersionMajor,
+		minorVer:        ProtocolVersionMinorCompatible,
+		currentMinorVer: ProtocolVersionMinor,
 	}
 }
 
@@ -77,6 +99,30 @@ func (m *version_metadata) encode(privateKey ed25519.PrivateKey, password []byte
 	bs = binary.BigEndian.AppendUint16(bs, 1)
 	bs = append(bs, m.priority)
 
+	if m.currentMinorVer != 0 {
+		bs = binary.BigEndian.AppendUint16(bs, metaVersionMinorCurrent)
+		bs = binary.BigEndian.AppendUint16(bs, 2)
+		bs = binary.BigEndian.AppendUint16(bs, m.currentMinorVer)
+	}
+
+	if len(m.nonce) == metaNonceSize {
+		bs = binary.BigEndian.AppendUint16(bs, metaNonce)
+		bs = binary.BigEndian.AppendUint16(bs, metaNonceSize)
+		bs = append(bs, m.nonce...)
+	}
+
+	// Newer nodes also sign every field, since the signature below only
+	// covers the public key and is kept for older nodes.
+	if m.currentMinorVer >= protocolVersionMinorProof {
+		hash, err := version_metadataHash(password, bs[6:])
+		if err != nil {
+			return nil, err
+		}
+		bs = binary.BigEndian.AppendUint16(bs, metaSignature)
+		bs = binary.BigEndian.AppendUint16(bs, ed25519.SignatureSize)
+		bs = append(bs, ed25519.Sign(privateKey, hash)...)
+	}
+
 	hasher, err := blake2b.New512(password)
 	if err != nil {
 		return nil, err
@@ -115,6 +161,9 @@ func (m *version_metadata) decode(r io.Reader, password []byte) error {
 	}
 	sig := bs[len(bs)-ed25519.SignatureSize:]
 	bs = bs[:len(bs)-ed25519.SignatureSize]
+	fields := bs
+	var fieldsSig []byte
+	var signed int
 
 	for len(bs) >= 4 {
 		op := binary.BigEndian.Uint16(bs[:2])
@@ -135,6 +184,23 @@ func (m *version_metadata) decode(r io.Reader, password []byte) error {
 
 		case metaPriority:
 			m.priority = bs[0]
+
+		case metaVersionMinorCurrent:
+			if oplen == 2 {
+				m.currentMinorVer = binary.BigEndian.Uint16(bs[:2])
+			}
+
+		case metaNonce:
+			if oplen == metaNonceSize {
+				m.nonce = make([]byte, metaNonceSize)
+				copy(m.nonce, bs[:metaNonceSize])
+			}
+
+		case metaSignature:
+			if oplen == ed25519.SignatureSize {
+				fieldsSig = bs[:ed25519.SignatureSize]
+				signed = len(fields) - len(bs) - 4
+			}
 		}
 		bs = bs[oplen:]
 	}
@@ -151,15 +217,42 @@ func (m *version_metadata) decode(r io.Reader, password []byte) error {
 	if !ed25519.Verify(m.publicKey, hash, sig) {
 		return ErrHandshakeIncorrectPassword
 	}
+
+	// Nodes which sign every field must have done so, and nothing may follow
+	// the signature, otherwise fields could have been changed in transit.
+	if m.currentMinorVer >= protocolVersionMinorProof {
+		if fieldsSig == nil || signed+4+ed25519.SignatureSize != len(fields) {
+			return ErrHandshakeInvalidSignature
+		}
+		hash, err := version_metadataHash(password, fields[:signed])
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(m.publicKey, hash, fieldsSig) {
+			return ErrHandshakeInvalidSignature
+		}
+	}
 	return nil
 }
 
+// Hashes the encoded metadata fields for the signature that covers all of
+// them.
+func version_metadataHash(password []byte, fields []byte) ([]byte, error) {
+	hasher, err := blake2b.New512(password)
+	if err != nil {
+		return nil, ErrHandshakeInvalidPassword
+	}
+	hasher.Write([]byte("ruvchain metadata"))
+	hasher.Write(fields)
+	return hasher.Sum(nil), nil
+}
+
 // Checks that the "meta" bytes and the version numbers are the expected values.
 func (m *version_metadata) check() bool {
 	switch {
 	case m.majorVer != ProtocolVersionMajor:
 		return false
-	case m.minorVer != ProtocolVersionMinor:
+	case m.minorVer != ProtocolVersionMinorCompatible:
 		return false
 	case len(m.publicKey) != ed25519.PublicKeySize:
 		return false
@@ -167,3 +260,57 @@ func (m *version_metadata) check() bool {
 		return true
 	}
 }
+
+// Checks whether the remote side will prove possession of its private key
+// after the metadata exchange. Older nodes don't, in which case the public
+// key in the metadata can't be trusted beyond the password check.
+func (m *version_metadata) supportsProof() bool {
+	return m.currentMinorVer >= protocolVersionMinorProof && len(m.nonce) == metaNonceSize
+}
+
+// Builds the transcript that is signed to prove possession of a private key.
+// It is bound to both keys, both nonces and, where the link runs over TLS,
+// to the TLS session itself, so that it can't be replayed on another link
+// or relayed through a middlebox. The signer's values always come first so
+// that a signature can't be reflected back to the side that produced it.
+func version_proofTranscript(password []byte, signer, other ed25519.PublicKey, signerNonce, otherNonce, exporter []byte) ([]byte, error) {
+	hasher, err := blake2b.New512(password)
+	if err != nil {
+		return nil, ErrHandshakeInvalidPassword
+	}
+	hasher.Write([]byte("ruvchain link proof"))
+	hasher.Write(signer)
+	hasher.Write(other)
+	hasher.Write(signerNonce)
+	hasher.Write(otherNonce)
+	hasher.Write([]byte{byte(len(exporter))})
+	hasher.Write(exporter)
+	return hasher.Sum(nil), nil
+}
+
+// Returns keying material exported from the TLS session that the link runs
+// over, or nil if the link doesn't use TLS directly.
+func version_tlsExporter(conn net.Conn) []byte {
+	if lc, ok := conn.(*linkConn); ok {
+		conn = lc.Conn
+	}
+	var state tls.ConnectionState
+	switch c := conn.(type) {
+	case *tls.Conn:
+		state = c.ConnectionState()
+	case *linkQUICStream:
+		state = c.ConnectionState().TLS
+	case *linkSOCKSQUICStream:
+		state = c.ConnectionState().TLS
+	default:
+		return nil
+	}
+	if !state.HandshakeComplete {
+		return nil
+	}
+	exporter, err := state.ExportKeyingMaterial("EXPORTER-ruvchain-link-proof", nil, 32)
+	if err != nil {
+		return nil
+	}
+	return exporter
+}
@@ -76,3 +76,92 @@ func TestVersionRoundtrip(t *testing.T) {
 		}
 	}
 }
+
+func TestVersionProofFields(t *testing.T) {
+	pk, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := version_getBaseMetadata()
+	test.publicKey = pk
+	test.nonce = bytes.Repeat([]byte{1}, metaNonceSize)
+	meta, err := test.encode(sk, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &version_metadata{}
+	if err := decoded.decode(bytes.NewBuffer(meta), nil); err != nil {
+		t.Fatalf("failed to decode: %s", err)
+	}
+	if !reflect.DeepEqual(&test, decoded) {
+		t.Fatalf("round-trip failed\nwant: %+v\n got: %+v", test, decoded)
+	}
+	if !decoded.check() || !decoded.supportsProof() {
+		t.Fatal("expected metadata to be compatible and support proofs")
+	}
+
+	// Legacy metadata has neither the current version nor a nonce.
+	legacy := version_metadata{
+		majorVer:  ProtocolVersionMajor,
+		minorVer:  ProtocolVersionMinorCompatible,
+		publicKey: pk,
+	}
+	if !legacy.check() || legacy.supportsProof() {
+		t.Fatal("expected legacy metadata to be compatible without proofs")
+	}
+}
+
+func TestVersionMetadataSignature(t *testing.T) {
+	pk, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := version_getBaseMetadata()
+	test.publicKey = pk
+	test.nonce = bytes.Repeat([]byte{1}, metaNonceSize)
+	meta, err := test.encode(sk, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing any field, such as the priority, must be detected even
+	// though the legacy signature only covers the public key.
+	tampered := bytes.Clone(meta)
+	tampered[6+6+6+4+ed25519.PublicKeySize+4] ^= 1
+	var decoded version_metadata
+	if err := decoded.decode(bytes.NewBuffer(tampered), nil); err != ErrHandshakeInvalidSignature {
+		t.Fatalf("expected tampered metadata to be rejected, got %v", err)
+	}
+
+	// Without its signature, metadata from a newer node is rejected.
+	legacyLen := 6 + 6 + 6 + 4 + ed25519.PublicKeySize + 5 + 6 + 4 + metaNonceSize
+	stripped := append(bytes.Clone(meta[:legacyLen]), meta[len(meta)-ed25519.SignatureSize:]...)
+	stripped[4], stripped[5] = 0, byte(len(stripped)-6)
+	if err := decoded.decode(bytes.NewBuffer(stripped), nil); err != ErrHandshakeInvalidSignature {
+		t.Fatalf("expected unsigned metadata to be rejected, got %v", err)
+	}
+}
+
+func TestVersionProofTranscript(t *testing.T) {
+	pk1, _, _ := ed25519.GenerateKey(nil)
+	pk2, _, _ := ed25519.GenerateKey(nil)
+	n1, n2 := []byte("nonce1"), []byte("nonce2")
+	a, err := version_proofTranscript(nil, pk1, pk2, n1, n2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The transcripts signed by each side must differ, otherwise a
+	// signature could be reflected back to the side that sent it.
+	b, _ := version_proofTranscript(nil, pk2, pk1, n2, n1, nil)
+	if bytes.Equal(a, b) {
+		t.Fatal("transcripts should depend on the signer")
+	}
+	c, _ := version_proofTranscript(nil, pk1, pk2, n1, n2, []byte("exporter"))
+	if bytes.Equal(a, c) {
+		t.Fatal("transcripts should depend on the TLS exporter")
+	}
+	d, _ := version_proofTranscript([]byte("foo"), pk1, pk2, n1, n2, nil)
+	if bytes.Equal(a, d) {
+		t.Fatal("transcripts should depend on the password")
+	}
+}
@@ -0,0 +1,514 @@
+// Package coretest builds networks of nodes in a single process, linked by
+// the in-memory transport, for testing routing. Nodes get the same keys in
+// every run and the topologies and link losses are seeded, but the nodes run
+// on real timers, so the order of events and how long a network takes to
+// converge vary from run to run. Tests wait for conditions to hold, with a
+// deadline, rather than for fixed times. Building a network takes seconds, so
+// tests that do should be skipped in short mode.
+package coretest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	iwt "github.com/Arceliar/ironwood/types"
+	"github.com/gologme/log"
+
+	"github.com/ruvcoindev/ruvchain/src/config"
+	"github.com/ruvcoindev/ruvchain/src/core"
+	"github.com/ruvcoindev/ruvchain/src/inmem"
+)
+
+// How many packets that have been received are kept for each node.
+const packetQueueSize = 64
+
+// How long the tree must stay the same to have converged.
+const settleTime = time.Second
+
+// How often nodes send bloom filters to their peers on the tree, which is
+// the router maintenance period in ironwood. Lookups for a node only reach
+// it once its key has made its way into the blooms along the tree, one hop
+// per period. Until then, lookups are dropped, and so is the session ack that
+// triggered one, which stalls traffic to that node until the session times
+// out a minute later.
+const bloomPeriod = time.Second
+
+// Link is a peering from node A to node B.
+type Link struct {
+	A, B int
+}
+
+// Topology is a number of nodes and the links between them.
+type Topology struct {
+	Nodes int
+	Links []Link
+}
+
+// Chain links each node to the next.
+func Chain(n int) Topology {
+	t := Topology{Nodes: n}
+	for i := 1; i < n; i++ {
+		t.Links = append(t.Links, Link{i - 1, i})
+	}
+	return t
+}
+
+// Star links every other node to node 0.
+func Star(n int) Topology {
+	t := Topology{Nodes: n}
+	for i := 1; i < n; i++ {
+		t.Links = append(t.Links, Link{i, 0})
+	}
+	return t
+}
+
+// Ring links each node to the next, and the last one to the first.
+func Ring(n int) Topology {
+	t := Chain(n)
+	if n > 2 {
+		t.Links = append(t.Links, Link{n - 1, 0})
+	}
+	return t
+}
+
+// RandomMesh links the nodes with a random spanning tree, so that they are
+// all connected, and then adds up to extra further links between random
+// pairs of nodes that aren't linked yet.
+func RandomMesh(n, extra int, seed int64) Topology {
+	r := rand.New(rand.NewSource(seed))
+	t := Topology{Nodes: n}
+	linked := make(map[Link]bool)
+	link := func(a, b int) {
+		t.Links = append(t.Links, Link{a, b})
+		linked[Link{a, b}], linked[Link{b, a}] = true, true
+	}
+	order := r.Perm(n)
+	for i := 1; i < n; i++ {
+		link(order[i], order[r.Intn(i)])
+	}
+	for tries := 0; extra > 0 && tries < 100*n; tries++ {
+		if a, b := r.Intn(n), r.Intn(n); a != b && !linked[Link{a, b}] {
+			link(a, b)
+			extra--
+		}
+	}
+	return t
+}
+
+// Network is a running network of nodes. The nodes are stopped when the test
+// finishes.
+type Network struct {
+	t        testing.TB
+	topology Topology
+	network  *inmem.Network
+	Nodes    []*Node
+}
+
+// Node is a node of a Network.
+type Node struct {
+	*core.Core
+	Name    string
+	packets chan Packet
+}
+
+// Packet is a traffic packet that a node has received.
+type Packet struct {
+	From ed25519.PublicKey
+	Data []byte
+}
+
+// New starts a node for each node of the topology, listening on
+// "inmem://nodeN", and peers them as given by its links.
+func New(t testing.TB, topology Topology) *Network {
+	t.Helper()
+	n := &Network{
+		t:        t,
+		topology: topology,
+		network:  inmem.NewNetwork(1),
+	}
+	logger := log.New(io.Discard, "", 0)
+	for i := 0; i < topology.Nodes; i++ {
+		seed := sha256.Sum256([]byte(fmt.Sprintf("coretest node %d", i)))
+		cfg := &config.NodeConfig{
+			PrivateKey: config.KeyBytes(ed25519.NewKeyFromSeed(seed[:])),
+		}
+		if err := cfg.GenerateSelfSignedCertificate(); err != nil {
+			t.Fatal(err)
+		}
+		node := &Node{
+			Name:    fmt.Sprintf("node%d", i),
+			packets: make(chan Packet, packetQueueSize),
+		}
+		var err error
+		node.Core, err = core.New(cfg.Certificate, logger,
+			core.CustomTransport{Scheme: inmem.Scheme, Transport: n.network},
+			core.ListenAddress(fmt.Sprintf("%s://%s", inmem.Scheme, node.Name)),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(node.Stop)
+		go node.read()
+		n.Nodes = append(n.Nodes, node)
+	}
+	for _, l := range topology.Links {
+		a, b := n.Nodes[l.A], n.Nodes[l.B]
+		u, err := url.Parse(fmt.Sprintf("%s://%s?from=%s", inmem.Scheme, b.Name, a.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = a.AddConfiguredPeer(u, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return n
+}
+
+// read receives traffic, which also lets the node handle protocol traffic.
+// Packets are dropped if nobody is waiting for them.
+func (node *Node) read() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := node.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		select {
+		case node.packets <- Packet{
+			From: ed25519.PublicKey(from.(iwt.Addr)),
+			Data: append([]byte(nil), buf[:n]...),
+		}:
+		default:
+		}
+	}
+}
+
+// SetConditions sets the conditions of the link between two nodes. When a
+// link comes back up, both nodes try to connect again straight away rather
+// than after their backoff.
+func (n *Network) SetConditions(a, b int, c inmem.Conditions) {
+	was := n.network.Conditions(n.Nodes[a].Name, n.Nodes[b].Name)
+	n.network.SetConditions(n.Nodes[a].Name, n.Nodes[b].Name, c)
+	if was.Down && !c.Down {
+		n.Nodes[a].RetryPeersNow()
+		n.Nodes[b].RetryPeersNow()
+	}
+}
+
+// LinkDown takes the link between two nodes down, keeping its other
+// conditions.
+func (n *Network) LinkDown(a, b int) {
+	c := n.network.Conditions(n.Nodes[a].Name, n.Nodes[b].Name)
+	c.Down = true
+	n.SetConditions(a, b, c)
+}
+
+// LinkUp brings the link between two nodes back up.
+func (n *Network) LinkUp(a, b int) {
+	c := n.network.Conditions(n.Nodes[a].Name, n.Nodes[b].Name)
+	c.Down = false
+	n.SetConditions(a, b, c)
+}
+
+// components returns the groups of nodes that are connected by links that
+// are up.
+func (n *Network) components() [][]int {
+	adjacent := make([][]int, len(n.Nodes))
+	for _, l := range n.topology.Links {
+		if n.network.Conditions(n.Nodes[l.A].Name, n.Nodes[l.B].Name).Down {
+			continue
+		}
+		adjacent[l.A] = append(adjacent[l.A], l.B)
+		adjacent[l.B] = append(adjacent[l.B], l.A)
+	}
+	seen := make([]bool, len(n.Nodes))
+	var components [][]int
+	for i := range n.Nodes {
+		if seen[i] {
+			continue
+		}
+		seen[i] = true
+		component := []int{i}
+		for j := 0; j < len(component); j++ {
+			for _, k := range adjacent[component[j]] {
+				if !seen[k] {
+					seen[k] = true
+					component = append(component, k)
+				}
+			}
+		}
+		components = append(components, component)
+	}
+	return components
+}
+
+// index returns the node with the key, or -1 if there is none.
+func (n *Network) index(key ed25519.PublicKey) int {
+	for i, node := range n.Nodes {
+		if bytes.Equal(node.PublicKey(), key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// peer returns the node that a node is connected to on the port, or -1.
+func (n *Network) peer(i int, port uint64) int {
+	for _, p := range n.Nodes[i].GetPeers() {
+		if p.Up && p.Port == port {
+			return n.index(p.Key)
+		}
+	}
+	return -1
+}
+
+// CheckTree returns an error unless the spanning tree has converged: within
+// each group of connected nodes, every node knows its ancestors up to the
+// same root, every node other than the root has a peer as its parent, and
+// what each node knows about its ancestors agrees with what they know about
+// themselves. Nodes don't learn about the whole tree, and may remember
+// outdated parents of other nodes for a while.
+func (n *Network) CheckTree() error {
+	for _, component := range n.components() {
+		parents := make([]map[int]int, len(n.Nodes)) // As seen by each node
+		for _, i := range component {
+			parents[i] = make(map[int]int)
+			for _, e := range n.Nodes[i].GetTree() {
+				if k, p := n.index(e.Key), n.index(e.Parent); k >= 0 && p >= 0 {
+					parents[i][k] = p
+				}
+			}
+		}
+		root := -1
+		for _, i := range component {
+			own, ok := parents[i][i]
+			switch {
+			case !ok:
+				return fmt.Errorf("%s doesn't know its own parent", n.Nodes[i].Name)
+			case own != i && !n.peered(i, own):
+				return fmt.Errorf("%s has %s as its parent, which isn't a peer", n.Nodes[i].Name, n.Nodes[own].Name)
+			}
+		}
+		for _, i := range component {
+			r := i
+			for steps := 0; parents[i][r] != r; steps++ {
+				p, ok := parents[i][r]
+				switch {
+				case !ok || steps > len(component):
+					return fmt.Errorf("%s doesn't know its ancestors", n.Nodes[i].Name)
+				case parents[r] == nil || parents[r][r] != p:
+					return fmt.Errorf("%s has the wrong parent for its ancestor %s", n.Nodes[i].Name, n.Nodes[r].Name)
+				}
+				r = p
+			}
+			if root >= 0 && root != r {
+				return fmt.Errorf("%s has %s as its root, not %s", n.Nodes[i].Name, n.Nodes[r].Name, n.Nodes[root].Name)
+			}
+			root = r
+		}
+	}
+	return nil
+}
+
+// peered returns true if the nodes are connected to each other.
+func (n *Network) peered(a, b int) bool {
+	for _, p := range n.Nodes[a].GetPeers() {
+		if p.Up && bytes.Equal(p.Key, n.Nodes[b].PublicKey()) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPaths returns an error unless the path that each node has learned to
+// each destination leads there, following the ports from the root of the
+// tree down to the destination. Paths to nodes that can't be reached are
+// not checked, as they are only forgotten after a while.
+func (n *Network) CheckPaths() error {
+	for _, component := range n.components() {
+		in := make(map[int]bool)
+		for _, i := range component {
+			in[i] = true
+		}
+		for _, i := range component {
+			for _, p := range n.Nodes[i].GetPaths() {
+				dest := n.index(p.Key)
+				if !in[dest] {
+					continue
+				}
+				cur := n.root(i)
+				for _, port := range p.Path {
+					if cur = n.peer(cur, port); cur < 0 {
+						break
+					}
+				}
+				if cur != dest {
+					return fmt.Errorf("path from %s to %s is %v, which doesn't lead there", n.Nodes[i].Name, n.Nodes[dest].Name, p.Path)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// root returns the root of the tree as seen by the node, by following its
+// ancestors, or -1.
+func (n *Network) root(i int) int {
+	parents := make(map[int]int)
+	for _, e := range n.Nodes[i].GetTree() {
+		if k, p := n.index(e.Key), n.index(e.Parent); k >= 0 && p >= 0 {
+			parents[k] = p
+		}
+	}
+	r := i
+	for steps := 0; steps <= len(n.Nodes); steps++ {
+		p, ok := parents[r]
+		switch {
+		case !ok:
+			return -1
+		case p == r:
+			return r
+		}
+		r = p
+	}
+	return -1
+}
+
+// depth returns the largest number of hops from any node to its root.
+func (n *Network) depth() int {
+	var depth int
+	for i, node := range n.Nodes {
+		parents := make(map[int]int)
+		for _, e := range node.GetTree() {
+			if k, p := n.index(e.Key), n.index(e.Parent); k >= 0 && p >= 0 {
+				parents[k] = p
+			}
+		}
+		for r, hops := i, 0; hops <= len(n.Nodes); hops++ {
+			p, ok := parents[r]
+			if !ok || p == r {
+				depth = max(depth, hops)
+				break
+			}
+			r = p
+		}
+	}
+	return depth
+}
+
+// connected returns true if the nodes are in the same group of connected
+// nodes.
+func (n *Network) connected(a, b int) bool {
+	for _, component := range n.components() {
+		var hasA, hasB bool
+		for _, i := range component {
+			hasA, hasB = hasA || i == a, hasB || i == b
+		}
+		if hasA || hasB {
+			return hasA && hasB
+		}
+	}
+	return false
+}
+
+// WaitConverged waits until CheckTree passes and the parents of the nodes
+// haven't changed for long enough for the blooms to have spread across the
+// tree, failing the test if that doesn't happen within the timeout. Until
+// the tree has settled, the coordinates that nodes give out in lookups may be
+// outdated, and answers to them get lost.
+func (n *Network) WaitConverged(timeout time.Duration) {
+	n.t.Helper()
+	var err error
+	var parents string
+	var since time.Time
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if err = n.CheckTree(); err != nil {
+			parents = ""
+			continue
+		}
+		if p := n.parents(); p != parents {
+			parents, since = p, time.Now()
+		} else if time.Since(since) >= settleTime+time.Duration(2*n.depth())*bloomPeriod {
+			return
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("parents still changing")
+	}
+	n.t.Fatalf("Tree didn't converge within %s: %s", timeout, err)
+}
+
+// parents returns the parent that each node has chosen, along with the
+// sequence number of its choice, which changes when it is announced again.
+func (n *Network) parents() string {
+	var sb strings.Builder
+	for i, node := range n.Nodes {
+		for _, e := range node.GetTree() {
+			if bytes.Equal(e.Key, node.PublicKey()) {
+				fmt.Fprintf(&sb, "%d:%d:%d ", i, n.index(e.Parent), e.Sequence)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// Send sends a traffic packet from one node to another.
+func (n *Network) Send(from, to int, data []byte) error {
+	_, err := n.Nodes[from].WriteTo(data, iwt.Addr(n.Nodes[to].PublicKey()))
+	return err
+}
+
+// Receive returns the next packet that the node receives from the other
+// node, or an error if there is none within the timeout. Packets from other
+// nodes are discarded.
+func (n *Network) Receive(node, from int, timeout time.Duration) (Packet, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case p := <-n.Nodes[node].packets:
+			if bytes.Equal(p.From, n.Nodes[from].PublicKey()) {
+				return p, nil
+			}
+		case <-timer.C:
+			return Packet{}, fmt.Errorf("nothing received by %s from %s within %s", n.Nodes[node].Name, n.Nodes[from].Name, timeout)
+		}
+	}
+}
+
+// Deliver sends packets from one node to another until one arrives, which
+// may take a few tries while the path is looked up, and returns an error if
+// none do within the timeout.
+func (n *Network) Deliver(from, to int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for try := 0; time.Now().Before(deadline); try++ {
+		data := []byte(fmt.Sprintf("coretest %d", try))
+		if err := n.Send(from, to, data); err != nil {
+			return err
+		}
+		wait := 100 * time.Millisecond
+		if left := time.Until(deadline); left < wait {
+			wait = left
+		}
+		if _, err := n.Receive(to, from, wait); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("nothing delivered from %s to %s within %s", n.Nodes[from].Name, n.Nodes[to].Name, timeout)
+}
+
+// WaitDelivered is like Deliver, but fails the test if nothing arrives.
+func (n *Network) WaitDelivered(from, to int, timeout time.Duration) {
+	n.t.Helper()
+	if err := n.Deliver(from, to, timeout); err != nil {
+		n.t.Fatal(err)
+	}
+}
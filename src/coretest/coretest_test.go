@@ -0,0 +1,110 @@
+package coretest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ruvcoindev/ruvchain/src/inmem"
+)
+
+func TestTopologies(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds networks that take several seconds to converge")
+	}
+	for name, topology := range map[string]Topology{
+		"chain": Chain(5),
+		"star":  Star(5),
+		"ring":  Ring(5),
+		"mesh":  RandomMesh(8, 4, 1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			n := New(t, topology)
+			n.WaitConverged(30 * time.Second)
+			for to := 1; to < topology.Nodes; to++ {
+				n.WaitDelivered(0, to, 5*time.Second)
+				n.WaitDelivered(to, 0, 5*time.Second)
+			}
+			if err := n.CheckPaths(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRandomMesh(t *testing.T) {
+	a, b := RandomMesh(10, 5, 7), RandomMesh(10, 5, 7)
+	if fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Fatal("same seed gave different topologies")
+	}
+	if len(a.Links) != 9+5 {
+		t.Fatalf("unexpected number of links %d", len(a.Links))
+	}
+}
+
+func TestLinkFlap(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds networks that take several seconds to converge")
+	}
+	t.Parallel()
+	n := New(t, Chain(4))
+	n.WaitConverged(30 * time.Second)
+	n.WaitDelivered(0, 3, 5*time.Second)
+
+	// The chain splits in two, each with a tree of its own.
+	n.LinkDown(1, 2)
+	if len(n.components()) != 2 {
+		t.Fatal("expected two groups of nodes")
+	}
+	n.WaitConverged(30 * time.Second)
+	n.WaitDelivered(0, 1, 5*time.Second)
+	n.WaitDelivered(3, 2, 5*time.Second)
+	if err := n.Deliver(0, 3, time.Second); err == nil {
+		t.Fatal("delivered across a link that is down")
+	}
+
+	n.LinkUp(1, 2)
+	n.WaitConverged(30 * time.Second)
+	// Paths learned before the split are kept until traffic finds them to be
+	// broken, so send some between every pair of nodes before checking them.
+	for from := range n.Nodes {
+		for to := range n.Nodes {
+			if from != to {
+				n.WaitDelivered(from, to, 5*time.Second)
+			}
+		}
+	}
+	if err := n.CheckPaths(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLatencyAndLoss(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds networks that take several seconds to converge")
+	}
+	t.Parallel()
+	n := New(t, Ring(4))
+	n.SetConditions(0, 1, inmem.Conditions{Latency: 25 * time.Millisecond})
+	n.SetConditions(2, 3, inmem.Conditions{Loss: 0.2})
+	n.WaitConverged(30 * time.Second)
+	n.WaitDelivered(0, 2, 5*time.Second)
+	n.WaitDelivered(1, 3, 5*time.Second)
+
+	// The round trip time of the slow link is measured by both nodes.
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		var latency time.Duration
+		for _, p := range n.Nodes[0].GetPeers() {
+			if p.Up && p.Key.Equal(n.Nodes[1].PublicKey()) {
+				latency = p.Latency
+			}
+		}
+		if latency >= 50*time.Millisecond {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("latency of slow link is %s", latency)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package dns runs a DNS server that resolves names in the network, so that
+// addresses don't have to be written out. It answers AAAA queries for
+// <public key>.ruv, for names that nodes publish in their nodeinfo and for
+// static hosts from the configuration. As many resolvers refuse labels that
+// are longer than 63 characters, the hex-encoded public key may be split
+// into several labels, e.g. <first 32>.<last 32>.ruv.
+package dns
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/ruvcoindev/ruvchain/src/address"
+	"github.com/ruvcoindev/ruvchain/src/core"
+)
+
+// How long a TCP client may take to send a query.
+const tcpTimeout = 10 * time.Second
+
+type Server struct {
+	resolver
+	udp    net.PacketConn
+	tcp    net.Listener
+	wg     sync.WaitGroup
+	done   chan struct{}
+	config struct {
+		listenaddr ListenAddress
+		hosts      []Host
+	}
+}
+
+// New starts the DNS server on UDP and TCP. If no listen address is
+// configured, nil is returned and no server is run.
+func New(c *core.Core, log core.Logger, opts ...SetupOption) (*Server, error) {
+	s := &Server{
+		done: make(chan struct{}),
+	}
+	s.log = log
+	s.cache = make(map[string]cacheEntry)
+	s.keys = func() []ed25519.PublicKey { return knownKeys(c) }
+	s.nodeInfo = c.GetNodeInfo
+	for _, opt := range opts {
+		s._applyOption(opt)
+	}
+	if s.config.listenaddr == "none" || s.config.listenaddr == "" {
+		return nil, nil
+	}
+	if err := s.setupHosts(); err != nil {
+		return nil, err
+	}
+	return s, s.start(string(s.config.listenaddr))
+}
+
+// setupHosts parses the static hosts, which map a name to either an IPv6
+// address or the public key of a node.
+func (s *Server) setupHosts() error {
+	s.hosts = make(map[string]netip.Addr, len(s.config.hosts))
+	for _, h := range s.config.hosts {
+		addr, err := netip.ParseAddr(h.Address)
+		if err != nil || !addr.Is6() {
+			key, kerr := hex.DecodeString(h.Address)
+			if kerr != nil || len(key) != ed25519.PublicKeySize {
+				return fmt.Errorf("DNS host %q must be an IPv6 address or a public key", h.Name)
+			}
+			addr = netip.AddrFrom16(*address.AddrForKey(key))
+		}
+		s.hosts[normalise(h.Name)] = addr
+	}
+	return nil
+}
+
+func (s *Server) start(listenaddr string) error {
+	var err error
+	if s.udp, err = net.ListenPacket("udp", listenaddr); err != nil {
+		return fmt.Errorf("DNS server failed to listen: %w", err)
+	}
+	if s.tcp, err = net.Listen("tcp", s.udp.LocalAddr().String()); err != nil {
+		_ = s.udp.Close()
+		return fmt.Errorf("DNS server failed to listen: %w", err)
+	}
+	s.log.Infof("DNS server listening on %s", s.udp.LocalAddr())
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+	return nil
+}
+
+// Addr returns the address that the DNS server is bound to.
+func (s *Server) Addr() net.Addr {
+	return s.udp.LocalAddr()
+}
+
+// Stop will stop the DNS server.
+func (s *Server) Stop() error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+	err := errors.Join(s.udp.Close(), s.tcp.Close())
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) stopped(err error) {
+	select {
+	case <-s.done:
+	default:
+		s.log.Errorln("DNS server stopped:", err)
+	}
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			s.stopped(err)
+			return
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			if res := s.handle(query); res != nil {
+				_, _ = s.udp.WriteTo(res, from)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			s.stopped(err)
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				_ = conn.SetDeadline(time.Now().Add(tcpTimeout))
+				var l [2]byte
+				if _, err := io.ReadFull(conn, l[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(l[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				res := s.handle(query)
+				if res == nil {
+					return
+				}
+				if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(res))), res...)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// handle answers a single query. It returns nil if the query can't be
+// parsed well enough to answer it.
+func (s *Server) handle(query []byte) []byte {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil || hdr.Response {
+		return nil
+	}
+	res := dnsmessage.Header{
+		ID:               hdr.ID,
+		Response:         true,
+		OpCode:           hdr.OpCode,
+		RecursionDesired: hdr.RecursionDesired,
+	}
+	questions, err := p.AllQuestions()
+	switch {
+	case err != nil:
+		res.RCode = dnsmessage.RCodeFormatError
+		return build(res, nil, nil, 0)
+	case hdr.OpCode != 0:
+		res.RCode = dnsmessage.RCodeNotImplemented
+		return build(res, nil, nil, 0)
+	case len(questions) != 1:
+		res.RCode = dnsmessage.RCodeFormatError
+		return build(res, questions, nil, 0)
+	}
+	q := questions[0]
+	if q.Class != dnsmessage.ClassINET && q.Class != dnsmessage.ClassANY {
+		res.RCode = dnsmessage.RCodeRefused
+		return build(res, questions, nil, 0)
+	}
+	addr, ttl, err := s.resolve(q.Name.String())
+	switch {
+	case errors.Is(err, errRefused):
+		res.RCode = dnsmessage.RCodeRefused
+		return build(res, questions, nil, 0)
+	case errors.Is(err, errPending):
+		// Ask the client to try again later, rather than to cache that
+		// the name doesn't exist.
+		res.RCode = dnsmessage.RCodeServerFailure
+		return build(res, questions, nil, 0)
+	case err != nil:
+		res.Authoritative = true
+		res.RCode = dnsmessage.RCodeNameError
+		return build(res, questions, nil, 0)
+	}
+	res.Authoritative = true
+	if q.Type != dnsmessage.TypeAAAA && q.Type != dnsmessage.TypeALL {
+		// The name exists, but only has an IPv6 address.
+		return build(res, questions, nil, 0)
+	}
+	answer := &dnsmessage.AAAAResource{AAAA: addr.As16()}
+	return build(res, questions, answer, uint32(ttl/time.Second))
+}
+
+// build builds a response with an optional AAAA answer for the question.
+func build(hdr dnsmessage.Header, questions []dnsmessage.Question, answer *dnsmessage.AAAAResource, ttl uint32) []byte {
+	b := dnsmessage.NewBuilder(nil, hdr)
+	b.EnableCompression()
+	_ = b.StartQuestions()
+	for _, q := range questions {
+		_ = b.Question(q)
+	}
+	if answer != nil {
+		_ = b.StartAnswers()
+		_ = b.AAAAResource(dnsmessage.ResourceHeader{
+			Name:  questions[0].Name,
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		}, *answer)
+	}
+	res, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,226 @@
+package dns
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/ruvcoindev/ruvchain/src/address"
+)
+
+func newKey(t *testing.T) ed25519.PublicKey {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+func addrForKey(key ed25519.PublicKey) netip.Addr {
+	return netip.AddrFrom16(*address.AddrForKey(key))
+}
+
+// newTestServer returns a server where each of the keys publishes the name
+// that it maps to in its nodeinfo.
+func newTestServer(t *testing.T, published map[string]string, opts ...SetupOption) (*Server, *atomic.Int32) {
+	var lookups atomic.Int32
+	s := &Server{done: make(chan struct{})}
+	s.log = log.New(io.Discard, "", 0)
+	s.cache = make(map[string]cacheEntry)
+	s.keys = func() []ed25519.PublicKey {
+		lookups.Add(1)
+		var keys []ed25519.PublicKey
+		for k := range published {
+			keys = append(keys, ed25519.PublicKey(k))
+		}
+		return keys
+	}
+	s.nodeInfo = func(key ed25519.PublicKey, _ time.Duration) (json.RawMessage, error) {
+		name, ok := published[string(key)]
+		if !ok {
+			return nil, errors.New("timed out")
+		}
+		return json.Marshal(map[string]string{NodeInfoKey: name, "other": "value"})
+	}
+	for _, opt := range opts {
+		s._applyOption(opt)
+	}
+	if err := s.setupHosts(); err != nil {
+		t.Fatal(err)
+	}
+	return s, &lookups
+}
+
+// waitLookup waits for the background lookup of published names, if one is
+// running, to finish.
+func waitLookup(s *Server) {
+	s.mutex.Lock()
+	done := s.lookup
+	s.mutex.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func TestResolve(t *testing.T) {
+	alice, bob, mallory, hostKey := newKey(t), newKey(t), newKey(t), newKey(t)
+	s, lookups := newTestServer(t, map[string]string{
+		string(alice):   "Alice",
+		string(bob):     "shared.ruv",
+		string(mallory): "shared",
+	},
+		Host{Name: "Static.Example.", Address: "fa00::1"},
+		Host{Name: "keyed.ruv", Address: hex.EncodeToString(hostKey)},
+	)
+
+	// Published names are looked up in the background, and until that is
+	// done the client is asked to try again.
+	if _, _, err := s.resolve("alice.ruv"); !errors.Is(err, errPending) {
+		t.Fatalf("resolve(%q) = %v, expected %v", "alice.ruv", err, errPending)
+	}
+	waitLookup(s)
+
+	for name, expected := range map[string]netip.Addr{
+		hex.EncodeToString(alice) + ".ruv.":                                            addrForKey(alice),
+		hex.EncodeToString(alice[:16]) + "." + hex.EncodeToString(alice[16:]) + ".ruv": addrForKey(alice),
+		"static.example": netip.MustParseAddr("fa00::1"),
+		"KEYED.ruv":      addrForKey(hostKey),
+		"alice.ruv.":     addrForKey(alice),
+		"ALICE.RUV":      addrForKey(alice),
+	} {
+		if addr, _, err := s.resolve(name); err != nil || addr != expected {
+			t.Fatalf("resolve(%q) = %s, %v, expected %s", name, addr, err, expected)
+		}
+	}
+	if n := lookups.Load(); n != 1 {
+		t.Fatalf("expected a single nodeinfo lookup, got %d", n)
+	}
+	for name, expected := range map[string]error{
+		"example.com":      errRefused,
+		"shared.ruv":       errNotFound, // Published by two nodes
+		"nobody.ruv":       errNotFound,
+		"a.b.ruv":          errNotFound,
+		"not_a_label.ruv.": errNotFound,
+	} {
+		if _, _, err := s.resolve(name); !errors.Is(err, expected) {
+			t.Fatalf("resolve(%q) = %v, expected %v", name, err, expected)
+		}
+	}
+	// Unknown names must not cause another lookup straight away.
+	if n := lookups.Load(); n != 1 {
+		t.Fatalf("expected a single nodeinfo lookup, got %d", n)
+	}
+
+	// Once a name expires, the old address is still given while it is
+	// looked up again.
+	s.mutex.Lock()
+	entry := s.cache["alice"]
+	entry.expires = time.Now().Add(-time.Second)
+	s.cache["alice"] = entry
+	s.lastTime = time.Time{}
+	s.mutex.Unlock()
+	if addr, ttl, err := s.resolve("alice.ruv"); err != nil || addr != addrForKey(alice) || ttl != staleTTL {
+		t.Fatalf("resolve(%q) = %s, %s, %v, expected %s for %s", "alice.ruv", addr, ttl, err, addrForKey(alice), staleTTL)
+	}
+	waitLookup(s)
+	if n := lookups.Load(); n != 2 {
+		t.Fatalf("expected a second nodeinfo lookup, got %d", n)
+	}
+	if _, ttl, err := s.resolve("alice.ruv"); err != nil || ttl <= staleTTL {
+		t.Fatalf("resolve(%q) = %s, %v, expected a fresh answer", "alice.ruv", ttl, err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	alice := newKey(t)
+	s, _ := newTestServer(t, map[string]string{string(alice): "alice"})
+	// Look up the published names first, see TestServerPending.
+	_, _, _ = s.resolve("alice.ruv")
+	waitLookup(s)
+	if err := s.start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	for _, network := range []string{"udp", "tcp"} {
+		r := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, s.Addr().String())
+			},
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		split := hex.EncodeToString(alice[:16]) + "." + hex.EncodeToString(alice[16:]) + ".ruv."
+		for _, name := range []string{split, "alice.ruv."} {
+			addrs, err := r.LookupNetIP(ctx, "ip6", name)
+			if err != nil {
+				t.Fatalf("%s: %s", network, err)
+			}
+			if len(addrs) != 1 || addrs[0] != addrForKey(alice) {
+				t.Fatalf("%s: unexpected addresses %v for %s", network, addrs, name)
+			}
+		}
+		var dnsErr *net.DNSError
+		if _, err := r.LookupNetIP(ctx, "ip6", "nobody.ruv."); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Fatalf("%s: expected not found, got %v", network, err)
+		}
+	}
+}
+
+// While published names are being looked up, queries for them fail with
+// SERVFAIL so that the client tries again, instead of with NXDOMAIN.
+func TestServerPending(t *testing.T) {
+	alice := newKey(t)
+	s, _ := newTestServer(t, map[string]string{string(alice): "alice"})
+	release := make(chan struct{})
+	nodeInfo := s.nodeInfo
+	s.nodeInfo = func(key ed25519.PublicKey, timeout time.Duration) (json.RawMessage, error) {
+		<-release
+		return nodeInfo(key, timeout)
+	}
+	query := func(name string) dnsmessage.RCode {
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1})
+		_ = b.StartQuestions()
+		_ = b.Question(dnsmessage.Question{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  dnsmessage.TypeAAAA,
+			Class: dnsmessage.ClassINET,
+		})
+		q, err := b.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p dnsmessage.Parser
+		hdr, err := p.Start(s.handle(q))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hdr.RCode
+	}
+	for _, name := range []string{"alice.ruv.", "nobody.ruv."} {
+		if rcode := query(name); rcode != dnsmessage.RCodeServerFailure {
+			t.Fatalf("%s: expected SERVFAIL while looking up, got %s", name, rcode)
+		}
+	}
+	close(release)
+	waitLookup(s)
+	if rcode := query("alice.ruv."); rcode != dnsmessage.RCodeSuccess {
+		t.Fatalf("expected success after looking up, got %s", rcode)
+	}
+	if rcode := query("nobody.ruv."); rcode != dnsmessage.RCodeNameError {
+		t.Fatalf("expected NXDOMAIN after looking up, got %s", rcode)
+	}
+}
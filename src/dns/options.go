@@ -0,0 +1,27 @@
+package dns
+
+func (s *Server) _applyOption(opt SetupOption) {
+	switch v := opt.(type) {
+	case ListenAddress:
+		s.config.listenaddr = v
+	case Host:
+		s.config.hosts = append(s.config.hosts, v)
+	}
+}
+
+type SetupOption interface {
+	isSetupOption()
+}
+
+// ListenAddress is the address to serve DNS on, over both UDP and TCP.
+type ListenAddress string
+
+// Host is a static name that resolves to either an IPv6 address or the
+// address of the node with the given hex-encoded public key.
+type Host struct {
+	Name    string
+	Address string
+}
+
+func (a ListenAddress) isSetupOption() {}
+func (a Host) isSetupOption()          {}
@@ -0,0 +1,244 @@
+package dns
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ruvcoindev/ruvchain/src/address"
+	"github.com/ruvcoindev/ruvchain/src/core"
+)
+
+// Suffix is the domain that the server answers for, apart from any static
+// hosts.
+const Suffix = "ruv"
+
+// NodeInfoKey is the nodeinfo key under which a node can publish a name for
+// itself, which then resolves as <name>.ruv. Names are first come, first
+// served and not authenticated in any way: any node can publish any name,
+// and a name that is published by more than one node doesn't resolve at
+// all. Names that must resolve to a particular node should be given as
+// <public key>.ruv or bound to its key with a Host instead.
+const NodeInfoKey = "dnsname"
+
+// How long answers may be cached by clients. Names made from keys never
+// change, published names can.
+const (
+	keyTTL      = 24 * time.Hour
+	hostsTTL    = 5 * time.Minute
+	nodeInfoTTL = 5 * time.Minute
+	staleTTL    = 30 * time.Second // While an expired name is looked up again
+)
+
+// Looking up published names means asking other nodes for their nodeinfo,
+// so this is limited in how many nodes are asked at once and in total, how
+// long it takes and how often it happens.
+const (
+	lookupNodes       = 256
+	lookupConcurrency = 32
+	lookupTimeout     = 3 * time.Second
+	lookupInterval    = 10 * time.Second
+)
+
+var errRefused = errors.New("name is not in the network")
+var errNotFound = errors.New("name not found")
+var errPending = errors.New("name is being looked up")
+
+type cacheEntry struct {
+	addr    netip.Addr // Invalid if the name wasn't found
+	expires time.Time
+}
+
+type resolver struct {
+	log      core.Logger
+	hosts    map[string]netip.Addr
+	keys     func() []ed25519.PublicKey
+	nodeInfo func(key ed25519.PublicKey, timeout time.Duration) (json.RawMessage, error)
+	mutex    sync.Mutex // Protects everything below
+	cache    map[string]cacheEntry
+	lookup   chan struct{} // Closed when the current lookup is done
+	lastTime time.Time     // When the last lookup started or finished
+}
+
+// normalise lowercases the name and removes the trailing dot.
+func normalise(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// resolve returns the address for the name and for how long it may be
+// cached, errNotFound if it doesn't exist, or errRefused if the name is not
+// one that this server answers for.
+func (r *resolver) resolve(name string) (netip.Addr, time.Duration, error) {
+	name = normalise(name)
+	if addr, ok := r.hosts[name]; ok {
+		return addr, hostsTTL, nil
+	}
+	label, ok := strings.CutSuffix(name, "."+Suffix)
+	if !ok {
+		return netip.Addr{}, 0, errRefused
+	}
+	// A hex-encoded key is longer than the 63 characters that a label may
+	// have, so it can also be split over several labels.
+	if key, err := hex.DecodeString(strings.ReplaceAll(label, ".", "")); err == nil && len(key) == ed25519.PublicKeySize {
+		return netip.AddrFrom16(*address.AddrForKey(key)), keyTTL, nil
+	}
+	if !validLabel(label) {
+		return netip.Addr{}, 0, errNotFound
+	}
+	return r.resolveNodeInfo(label)
+}
+
+// resolveNodeInfo answers from the cache if possible. Otherwise it starts
+// asking known nodes for their nodeinfo in the background to find out who
+// publishes the name, and returns errPending until that is done, so that
+// clients try again rather than caching a negative answer.
+func (r *resolver) resolveNodeInfo(label string) (netip.Addr, time.Duration, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entry, ok := r.cache[label]
+	if ok && time.Now().Before(entry.expires) {
+		if !entry.addr.IsValid() {
+			return netip.Addr{}, 0, errNotFound
+		}
+		return entry.addr, time.Until(entry.expires), nil
+	}
+	switch {
+	case r.lookup != nil:
+		// A lookup is already running, which may still find the name.
+	case time.Since(r.lastTime) < lookupInterval:
+		// The last lookup has only just finished and didn't find it.
+		return netip.Addr{}, 0, errNotFound
+	default:
+		r._startLookup()
+	}
+	if entry.addr.IsValid() {
+		// The name was published until recently, so keep answering with
+		// the old address while it is looked up again.
+		return entry.addr, staleTTL, nil
+	}
+	return netip.Addr{}, 0, errPending
+}
+
+// _startLookup looks up published names in the background, drops expired
+// entries from the cache and adds the names that were found. Names that
+// nobody publishes aren't cached: they are answered as not found until
+// lookupInterval after the lookup, and asking for them after that starts
+// another one.
+func (r *resolver) _startLookup() {
+	done := make(chan struct{})
+	r.lookup, r.lastTime = done, time.Now()
+	go func() {
+		names := r.lookupNodeInfo()
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		now := time.Now()
+		for name, e := range r.cache {
+			if now.After(e.expires) {
+				delete(r.cache, name)
+			}
+		}
+		for name, keys := range names {
+			entry := cacheEntry{expires: now.Add(nodeInfoTTL)}
+			if len(keys) == 1 {
+				entry.addr = netip.AddrFrom16(*address.AddrForKey(keys[0]))
+			} else {
+				r.log.Debugf("DNS name %s.%s is published by %d nodes, ignoring it", name, Suffix, len(keys))
+			}
+			r.cache[name] = entry
+		}
+		r.lookup = nil
+		r.lastTime = now
+		close(done)
+	}()
+}
+
+// lookupNodeInfo asks known nodes for their nodeinfo and returns the keys
+// of the nodes that publish each name.
+func (r *resolver) lookupNodeInfo() map[string][]ed25519.PublicKey {
+	keys := r.keys()
+	if len(keys) > lookupNodes {
+		keys = keys[:lookupNodes]
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	names := make(map[string][]ed25519.PublicKey)
+	sem := make(chan struct{}, lookupConcurrency)
+	for _, key := range keys {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(key ed25519.PublicKey) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			info, err := r.nodeInfo(key, lookupTimeout)
+			if err != nil {
+				return
+			}
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(info, &fields); err != nil {
+				return
+			}
+			var name string
+			if err := json.Unmarshal(fields[NodeInfoKey], &name); err != nil {
+				return
+			}
+			name = strings.TrimSuffix(normalise(name), "."+Suffix)
+			if !validLabel(name) {
+				return
+			}
+			mutex.Lock()
+			names[name] = append(names[name], key)
+			mutex.Unlock()
+		}(key)
+	}
+	wg.Wait()
+	return names
+}
+
+// knownKeys returns the keys of the nodes that this node knows about,
+// starting with the closest ones.
+func knownKeys(c *core.Core) []ed25519.PublicKey {
+	seen := make(map[[ed25519.PublicKeySize]byte]struct{})
+	var keys []ed25519.PublicKey
+	add := func(key ed25519.PublicKey) {
+		if len(key) != ed25519.PublicKeySize || key.Equal(c.PublicKey()) {
+			return
+		}
+		if _, ok := seen[[ed25519.PublicKeySize]byte(key)]; ok {
+			return
+		}
+		seen[[ed25519.PublicKeySize]byte(key)] = struct{}{}
+		keys = append(keys, key)
+	}
+	for _, p := range c.GetPeers() {
+		add(p.Key)
+	}
+	for _, s := range c.GetSessions() {
+		add(s.Key)
+	}
+	for _, t := range c.GetTree() {
+		add(t.Key)
+	}
+	return keys
+}
+
+// validLabel returns true for a single DNS label of letters, digits and
+// hyphens.
+func validLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, c := range label {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ruvcoindev/ruvchain/src/admin"
+)
+
+type CounterEntry struct {
+	Packets uint64         `json:"packets"`
+	Bytes   admin.DataUnit `json:"bytes"`
+}
+
+type FirewallRuleEntry struct {
+	Index int `json:"index"`
+	Rule
+	CounterEntry
+}
+
+type GetFirewallRequest struct{}
+
+type GetFirewallResponse struct {
+	InboundPolicy   string              `json:"inbound_policy"`
+	OutboundPolicy  string              `json:"outbound_policy"`
+	Flows           int                 `json:"flows"`
+	Established     CounterEntry        `json:"established"`
+	InboundDefault  CounterEntry        `json:"inbound_default"`
+	OutboundDefault CounterEntry        `json:"outbound_default"`
+	Untracked       CounterEntry        `json:"untracked"`
+	Rules           []FirewallRuleEntry `json:"rules"`
+}
+
+type AddFirewallRuleRequest struct {
+	Rule
+	Index json.Number `json:"index,omitempty"` // At the end if empty
+}
+
+type AddFirewallRuleResponse struct{}
+
+type RemoveFirewallRuleRequest struct {
+	Index json.Number `json:"index"`
+}
+
+type RemoveFirewallRuleResponse struct{}
+
+func (c *counter) entry() CounterEntry {
+	return CounterEntry{
+		Packets: c.packets.Load(),
+		Bytes:   admin.DataUnit(c.bytes.Load()),
+	}
+}
+
+func policyName(allow bool) string {
+	if allow {
+		return ActionAllow
+	}
+	return ActionDeny
+}
+
+func parseIndex(index json.Number) (int, error) {
+	i, err := index.Int64()
+	if err != nil {
+		return 0, fmt.Errorf("invalid index %q", index)
+	}
+	return int(i), nil
+}
+
+func (f *Firewall) getFirewallHandler(_ *GetFirewallRequest, res *GetFirewallResponse) error {
+	res.InboundPolicy = policyName(f.inbound)
+	res.OutboundPolicy = policyName(f.outbound)
+	res.Flows = f.states.count()
+	res.Established = f.counters.established.entry()
+	res.InboundDefault = f.counters.inbound.entry()
+	res.OutboundDefault = f.counters.outbound.entry()
+	res.Untracked = f.counters.untracked.entry()
+	f.mutex.RLock()
+	rules := f.rules
+	f.mutex.RUnlock()
+	res.Rules = make([]FirewallRuleEntry, 0, len(rules))
+	for i, r := range rules {
+		res.Rules = append(res.Rules, FirewallRuleEntry{
+			Index:        i,
+			Rule:         r.Rule,
+			CounterEntry: r.entry(),
+		})
+	}
+	return nil
+}
+
+func (f *Firewall) addFirewallRuleHandler(req *AddFirewallRuleRequest, _ *AddFirewallRuleResponse) error {
+	index := -1
+	if req.Index != "" {
+		var err error
+		if index, err = parseIndex(req.Index); err != nil {
+			return err
+		}
+	}
+	return f.AddRule(req.Rule, index)
+}
+
+func (f *Firewall) removeFirewallRuleHandler(req *RemoveFirewallRuleRequest, _ *RemoveFirewallRuleResponse) error {
+	index, err := parseIndex(req.Index)
+	if err != nil {
+		return err
+	}
+	return f.RemoveRule(index)
+}
+
+func (f *Firewall) SetupAdminHandlers(a *admin.AdminSocket) {
+	_ = a.AddReadOnlyHandler(
+		"getFirewall", "Show the firewall policies and rules with their packet counters", []string{},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &GetFirewallRequest{}
+			res := &GetFirewallResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := f.getFirewallHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddHandler(
+		"addFirewallRule", "Add a firewall rule, at the end or before the rule at the given index", []string{"action", "direction", "key", "source", "destination", "protocol", "ports", "index"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &AddFirewallRuleRequest{}
+			res := &AddFirewallRuleResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := f.addFirewallRuleHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddHandler(
+		"removeFirewallRule", "Remove the firewall rule at the given index", []string{"index"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &RemoveFirewallRuleRequest{}
+			res := &RemoveFirewallRuleResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := f.removeFirewallRuleHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+}
@@ -0,0 +1,309 @@
+// Package firewall filters the packets that pass between the TUN adapter and
+// the network. Rules match on the public key of the remote node, address
+// prefixes, protocol and destination ports, and are checked in order for each
+// direction, with a default policy for packets that no rule matches. Once a
+// packet has been allowed, the rest of its flow is allowed in both
+// directions, so that replies don't need rules of their own.
+package firewall
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ruvcoindev/ruvchain/src/core"
+	"github.com/ruvcoindev/ruvchain/src/ipv6rwc"
+)
+
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+type Firewall struct {
+	log      core.Logger
+	mutex    sync.RWMutex // Protects rules, which are replaced rather than changed
+	rules    []*rule
+	inbound  bool // Whether packets that no rule matches are allowed
+	outbound bool
+	states   states
+	counters struct {
+		established       counter // Packets of known flows
+		inbound, outbound counter // Packets that no rule matched
+		untracked         counter // Allowed packets whose flow couldn't be remembered
+	}
+	config struct {
+		inbound  InboundPolicy
+		outbound OutboundPolicy
+		rules    []Rule
+	}
+}
+
+type counter struct {
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+}
+
+func (c *counter) add(bs []byte) {
+	c.packets.Add(1)
+	c.bytes.Add(uint64(len(bs)))
+}
+
+type rule struct {
+	Rule
+	counter
+	inbound     bool
+	allow       bool
+	key         ed25519.PublicKey // Nil to match any
+	source      netip.Prefix      // Invalid to match any
+	destination netip.Prefix      // Invalid to match any
+	protocol    int               // -1 to match any
+	portMin     uint16            // Both zero to match any
+	portMax     uint16
+}
+
+// New sets up the firewall as the filter of the ReadWriteCloser. If no
+// policies or rules are configured, nil is returned and packets aren't
+// filtered at all.
+func New(rwc *ipv6rwc.ReadWriteCloser, log core.Logger, opts ...SetupOption) (*Firewall, error) {
+	f := &Firewall{
+		log: log,
+	}
+	f.states.flows = make(map[flow]time.Time)
+	for _, opt := range opts {
+		f._applyOption(opt)
+	}
+	if f.config.inbound == "" && f.config.outbound == "" && len(f.config.rules) == 0 {
+		return nil, nil
+	}
+	if err := f.setup(); err != nil {
+		return nil, err
+	}
+	rwc.SetFilter(f)
+	f.log.Infof("Firewall enabled with %d rules", len(f.rules))
+	return f, nil
+}
+
+func (f *Firewall) setup() error {
+	var err error
+	if f.inbound, err = parsePolicy(string(f.config.inbound)); err != nil {
+		return fmt.Errorf("inbound firewall policy: %w", err)
+	}
+	if f.outbound, err = parsePolicy(string(f.config.outbound)); err != nil {
+		return fmt.Errorf("outbound firewall policy: %w", err)
+	}
+	for i, r := range f.config.rules {
+		nr, err := newRule(r)
+		if err != nil {
+			return fmt.Errorf("firewall rule %d: %w", i, err)
+		}
+		f.rules = append(f.rules, nr)
+	}
+	return nil
+}
+
+func parsePolicy(policy string) (bool, error) {
+	switch policy {
+	case "", ActionAllow:
+		return true, nil
+	case ActionDeny:
+		return false, nil
+	default:
+		return false, fmt.Errorf("%q is not %q or %q", policy, ActionAllow, ActionDeny)
+	}
+}
+
+func newRule(r Rule) (*rule, error) {
+	nr := &rule{Rule: r, protocol: -1}
+	var err error
+	if nr.allow, err = parsePolicy(r.Action); err != nil || r.Action == "" {
+		return nil, fmt.Errorf("action must be %q or %q", ActionAllow, ActionDeny)
+	}
+	switch r.Direction {
+	case DirectionIn:
+		nr.inbound = true
+	case DirectionOut:
+	default:
+		return nil, fmt.Errorf("direction must be %q or %q", DirectionIn, DirectionOut)
+	}
+	if r.PublicKey != "" {
+		key, err := hex.DecodeString(r.PublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key %q", r.PublicKey)
+		}
+		nr.key = key
+	}
+	for _, p := range []struct {
+		s      string
+		prefix *netip.Prefix
+	}{
+		{r.Source, &nr.source},
+		{r.Destination, &nr.destination},
+	} {
+		if p.s == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(p.s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(p.s)
+			if aerr != nil {
+				return nil, fmt.Errorf("invalid prefix %q", p.s)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if prefix.Addr().Is4In6() {
+			return nil, fmt.Errorf("prefix %q must be given as IPv4", p.s)
+		}
+		*p.prefix = prefix.Masked()
+	}
+	switch r.Protocol {
+	case "":
+	case "tcp":
+		nr.protocol = protoTCP
+	case "udp":
+		nr.protocol = protoUDP
+	case "icmp", "icmpv6":
+		nr.protocol = protoICMP
+	case "icmpv4":
+		nr.protocol = protoICMPv4
+	default:
+		n, err := strconv.ParseUint(r.Protocol, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("unknown protocol %q", r.Protocol)
+		}
+		nr.protocol = int(n)
+	}
+	if r.Ports != "" {
+		if nr.protocol != protoTCP && nr.protocol != protoUDP {
+			return nil, fmt.Errorf("ports can only be matched for tcp or udp")
+		}
+		first, last, isRange := strings.Cut(r.Ports, "-")
+		if !isRange {
+			last = first
+		}
+		lo, err := strconv.ParseUint(first, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ports %q", r.Ports)
+		}
+		hi, err := strconv.ParseUint(last, 10, 16)
+		if err != nil || hi < lo || hi == 0 {
+			return nil, fmt.Errorf("invalid ports %q", r.Ports)
+		}
+		nr.portMin, nr.portMax = uint16(lo), uint16(hi)
+	}
+	return nr, nil
+}
+
+func (r *rule) match(key ed25519.PublicKey, p *packet) bool {
+	switch {
+	case r.key != nil && !r.key.Equal(key):
+		return false
+	case r.source.IsValid() && !r.source.Contains(p.src):
+		return false
+	case r.destination.IsValid() && !r.destination.Contains(p.dst):
+		return false
+	case r.protocol >= 0 && r.protocol != int(p.proto):
+		return false
+	case r.portMax != 0 && (!p.hasPorts || p.dstPort < r.portMin || p.dstPort > r.portMax):
+		return false
+	}
+	return true
+}
+
+// AllowInbound implements ipv6rwc.Filter.
+func (f *Firewall) AllowInbound(key ed25519.PublicKey, bs []byte) bool {
+	return f.allow(true, key, bs)
+}
+
+// AllowOutbound implements ipv6rwc.Filter.
+func (f *Firewall) AllowOutbound(key ed25519.PublicKey, bs []byte) bool {
+	return f.allow(false, key, bs)
+}
+
+func (f *Firewall) allow(inbound bool, key ed25519.PublicKey, bs []byte) bool {
+	now := time.Now()
+	p := parse(bs)
+	fl := p.flow(inbound)
+	if f.states.refresh(fl, now) {
+		f.counters.established.add(bs)
+		return true
+	}
+	if p.icmpError {
+		// An error about a packet of a known flow. The packet that caused it
+		// went in the opposite direction.
+		if inner := parse(p.inner); f.states.known(inner.flow(!inbound), now) {
+			f.counters.established.add(bs)
+			return true
+		}
+	}
+	f.mutex.RLock()
+	rules := f.rules
+	f.mutex.RUnlock()
+	allow, matched := f.outbound, false
+	if inbound {
+		allow = f.inbound
+	}
+	for _, r := range rules {
+		if r.inbound == inbound && r.match(key, &p) {
+			r.add(bs)
+			allow, matched = r.allow, true
+			break
+		}
+	}
+	switch {
+	case matched:
+	case inbound:
+		f.counters.inbound.add(bs)
+	default:
+		f.counters.outbound.add(bs)
+	}
+	if allow && !p.icmpError && !f.states.add(fl, now) {
+		f.counters.untracked.add(bs)
+	}
+	return allow
+}
+
+// AddRule checks and adds a rule at the given index, or at the end if the
+// index is negative. Flows that are already known aren't affected.
+func (f *Firewall) AddRule(r Rule, index int) error {
+	nr, err := newRule(r)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if index < 0 {
+		index = len(f.rules)
+	}
+	if index > len(f.rules) {
+		return fmt.Errorf("index %d is out of range", index)
+	}
+	rules := make([]*rule, 0, len(f.rules)+1)
+	rules = append(rules, f.rules[:index]...)
+	rules = append(rules, nr)
+	f.rules = append(rules, f.rules[index:]...)
+	return nil
+}
+
+// RemoveRule removes the rule at the given index. Flows that are already
+// known aren't affected.
+func (f *Firewall) RemoveRule(index int) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if index < 0 || index >= len(f.rules) {
+		return fmt.Errorf("index %d is out of range", index)
+	}
+	rules := make([]*rule, 0, len(f.rules)-1)
+	rules = append(rules, f.rules[:index]...)
+	f.rules = append(rules, f.rules[index+1:]...)
+	return nil
+}
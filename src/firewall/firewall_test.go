@@ -0,0 +1,254 @@
+package firewall
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+
+	"github.com/ruvcoindev/ruvchain/src/address"
+)
+
+func newKey(t *testing.T) ed25519.PublicKey {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+func addrForKey(key ed25519.PublicKey) netip.Addr {
+	return netip.AddrFrom16(*address.AddrForKey(key))
+}
+
+func newTestFirewall(t *testing.T, opts ...SetupOption) *Firewall {
+	f := &Firewall{log: log.New(io.Discard, "", 0)}
+	f.states.flows = make(map[flow]time.Time)
+	for _, opt := range opts {
+		f._applyOption(opt)
+	}
+	if err := f.setup(); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func ipv6Packet(src, dst netip.Addr, proto uint8, payload []byte) []byte {
+	bs := make([]byte, 40, 40+len(payload))
+	bs[0] = 0x60
+	binary.BigEndian.PutUint16(bs[4:], uint16(len(payload)))
+	bs[6], bs[7] = proto, 64
+	copy(bs[8:24], src.AsSlice())
+	copy(bs[24:40], dst.AsSlice())
+	return append(bs, payload...)
+}
+
+func ipv4Packet(src, dst netip.Addr, proto uint8, payload []byte) []byte {
+	bs := make([]byte, 20, 20+len(payload))
+	bs[0] = 0x45
+	binary.BigEndian.PutUint16(bs[2:], uint16(20+len(payload)))
+	bs[8], bs[9] = 64, proto
+	copy(bs[12:16], src.AsSlice())
+	copy(bs[16:20], dst.AsSlice())
+	return append(bs, payload...)
+}
+
+func ports(src, dst uint16) []byte {
+	bs := make([]byte, 20)
+	binary.BigEndian.PutUint16(bs[0:], src)
+	binary.BigEndian.PutUint16(bs[2:], dst)
+	return bs
+}
+
+func icmp(typ uint8, rest []byte) []byte {
+	bs := make([]byte, 8)
+	bs[0] = typ
+	return append(bs, rest...)
+}
+
+func echo(typ uint8, id uint16) []byte {
+	bs := icmp(typ, nil)
+	binary.BigEndian.PutUint16(bs[4:], id)
+	return bs
+}
+
+func TestRules(t *testing.T) {
+	key := hex.EncodeToString(newKey(t))
+	for _, r := range []Rule{
+		{Action: "allow", Direction: "in"},
+		{Action: "deny", Direction: "out", PublicKey: key, Source: "fa00::/7", Destination: "fb00::1", Protocol: "udp", Ports: "53"},
+		{Action: "allow", Direction: "in", Protocol: "tcp", Ports: "8000-8999"},
+		{Action: "allow", Direction: "in", Protocol: "icmp"},
+		{Action: "allow", Direction: "in", Protocol: "132"},
+		{Action: "allow", Direction: "out", Destination: "10.0.0.0/8", Protocol: "icmpv4"},
+	} {
+		if _, err := newRule(r); err != nil {
+			t.Fatalf("%+v: %s", r, err)
+		}
+	}
+	for _, r := range []Rule{
+		{Direction: "in"},
+		{Action: "reject", Direction: "in"},
+		{Action: "allow"},
+		{Action: "allow", Direction: "in", PublicKey: key[:10]},
+		{Action: "allow", Direction: "in", Source: "::ffff:10.0.0.0/104"},
+		{Action: "allow", Direction: "in", Destination: "nonsense"},
+		{Action: "allow", Direction: "in", Protocol: "sctp"},
+		{Action: "allow", Direction: "in", Ports: "22"},
+		{Action: "allow", Direction: "in", Protocol: "icmp", Ports: "22"},
+		{Action: "allow", Direction: "in", Protocol: "tcp", Ports: "22-21"},
+		{Action: "allow", Direction: "in", Protocol: "tcp", Ports: "65536"},
+	} {
+		if _, err := newRule(r); err == nil {
+			t.Fatalf("%+v: expected an error", r)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	self, alice, bob := newKey(t), newKey(t), newKey(t)
+	local, a, b := addrForKey(self), addrForKey(alice), addrForKey(bob)
+	f := newTestFirewall(t,
+		InboundPolicy("deny"),
+		Rule{Action: "allow", Direction: "in", PublicKey: hex.EncodeToString(alice), Protocol: "tcp", Ports: "22"},
+		Rule{Action: "deny", Direction: "out", Protocol: "udp", Ports: "53"},
+	)
+
+	for _, test := range []struct {
+		name    string
+		inbound bool
+		key     ed25519.PublicKey
+		packet  []byte
+		allow   bool
+	}{
+		{"ssh from alice", true, alice, ipv6Packet(a, local, protoTCP, ports(40000, 22)), true},
+		{"ssh reply to alice", false, alice, ipv6Packet(local, a, protoTCP, ports(22, 40000)), true},
+		{"ssh from bob", true, bob, ipv6Packet(b, local, protoTCP, ports(40000, 22)), false},
+		{"http from alice", true, alice, ipv6Packet(a, local, protoTCP, ports(40000, 80)), false},
+		{"udp to bob", false, bob, ipv6Packet(local, b, protoUDP, ports(5000, 123)), true},
+		{"udp reply from bob", true, bob, ipv6Packet(b, local, protoUDP, ports(123, 5000)), true},
+		{"udp from bob", true, bob, ipv6Packet(b, local, protoUDP, ports(124, 5000)), false},
+		{"dns to bob", false, bob, ipv6Packet(local, b, protoUDP, ports(5001, 53)), false},
+		{"ping to bob", false, bob, ipv6Packet(local, b, protoICMP, echo(128, 7)), true},
+		{"pong from bob", true, bob, ipv6Packet(b, local, protoICMP, echo(129, 7)), true},
+		{"ping from bob", true, bob, ipv6Packet(b, local, protoICMP, echo(128, 8)), false},
+		{"error about udp", true, bob, ipv6Packet(b, local, protoICMP, icmp(1, ipv6Packet(local, b, protoUDP, ports(5000, 123)))), true},
+		{"error about nothing", true, bob, ipv6Packet(b, local, protoICMP, icmp(1, ipv6Packet(local, b, protoUDP, ports(5002, 123)))), false},
+	} {
+		var allow bool
+		if test.inbound {
+			allow = f.AllowInbound(test.key, test.packet)
+		} else {
+			allow = f.AllowOutbound(test.key, test.packet)
+		}
+		if allow != test.allow {
+			t.Fatalf("%s: allowed %v, expected %v", test.name, allow, test.allow)
+		}
+	}
+
+	res := &GetFirewallResponse{}
+	if err := f.getFirewallHandler(nil, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.InboundPolicy != "deny" || res.OutboundPolicy != "allow" || res.Flows != 3 {
+		t.Fatalf("unexpected state %+v", res)
+	}
+	if len(res.Rules) != 2 || res.Rules[0].Packets != 1 || res.Rules[1].Packets != 1 {
+		t.Fatalf("unexpected rule counters %+v", res.Rules)
+	}
+	if res.Established.Packets != 4 || res.InboundDefault.Packets != 5 || res.OutboundDefault.Packets != 2 {
+		t.Fatalf("unexpected counters %+v", res)
+	}
+
+	// Allow everything from bob before the other rules, then remove it again.
+	if err := f.addFirewallRuleHandler(&AddFirewallRuleRequest{
+		Rule:  Rule{Action: "allow", Direction: "in", PublicKey: hex.EncodeToString(bob)},
+		Index: "0",
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !f.AllowInbound(bob, ipv6Packet(b, local, protoTCP, ports(40001, 80))) {
+		t.Fatal("expected the new rule to allow the packet")
+	}
+	if err := f.removeFirewallRuleHandler(&RemoveFirewallRuleRequest{Index: "0"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if f.AllowInbound(bob, ipv6Packet(b, local, protoTCP, ports(40002, 80))) {
+		t.Fatal("expected the removed rule not to allow the packet")
+	}
+	if err := f.RemoveRule(2); err == nil {
+		t.Fatal("expected an error for an index out of range")
+	}
+}
+
+func TestFilterIPv4(t *testing.T) {
+	alice := newKey(t)
+	local, remote := netip.MustParseAddr("10.98.0.1"), netip.MustParseAddr("10.99.0.1")
+	f := newTestFirewall(t,
+		InboundPolicy("deny"),
+		Rule{Action: "allow", Direction: "in", Source: "10.99.0.0/24", Protocol: "icmpv4"},
+	)
+
+	for _, test := range []struct {
+		name    string
+		inbound bool
+		packet  []byte
+		allow   bool
+	}{
+		{"tcp to alice", false, ipv4Packet(local, remote, protoTCP, ports(40000, 80)), true},
+		{"tcp reply from alice", true, ipv4Packet(remote, local, protoTCP, ports(80, 40000)), true},
+		{"tcp from alice", true, ipv4Packet(remote, local, protoTCP, ports(80, 40001)), false},
+		{"error about tcp", true, ipv4Packet(remote, local, protoICMPv4, icmp(3, ipv4Packet(local, remote, protoTCP, ports(40000, 80)))), true},
+		{"ping from alice", true, ipv4Packet(remote, local, protoICMPv4, echo(8, 1)), true},
+		{"pong to alice", false, ipv4Packet(local, remote, protoICMPv4, echo(0, 1)), true},
+		{"ping from elsewhere", true, ipv4Packet(netip.MustParseAddr("10.100.0.1"), local, protoICMPv4, echo(8, 1)), false},
+	} {
+		var allow bool
+		if test.inbound {
+			allow = f.AllowInbound(alice, test.packet)
+		} else {
+			allow = f.AllowOutbound(alice, test.packet)
+		}
+		if allow != test.allow {
+			t.Fatalf("%s: allowed %v, expected %v", test.name, allow, test.allow)
+		}
+	}
+}
+
+// Malformed packets, including those inside ICMP errors, are parsed without
+// ports rather than read past their end.
+func TestFilterMalformed(t *testing.T) {
+	bob := newKey(t)
+	local, b := netip.MustParseAddr("fa00::1"), addrForKey(bob)
+	oversized := ipv6Packet(b, local, protoHopByHop, []byte{protoTCP, 255, 0, 0, 0, 0, 0, 0})
+	badIPv4 := ipv4Packet(local, b, protoTCP, nil)
+	badIPv4[0] = 0x4f // A header of 60 bytes
+
+	for _, test := range []struct {
+		name   string
+		packet []byte
+	}{
+		{"oversized hop-by-hop", oversized},
+		{"truncated hop-by-hop", ipv6Packet(b, local, protoHopByHop, []byte{protoTCP})},
+		{"empty after hop-by-hop", ipv6Packet(b, local, protoHopByHop, []byte{protoTCP, 0, 0, 0, 0, 0, 0, 0})},
+		{"oversized second header", ipv6Packet(b, local, protoRouting, []byte{protoDestination, 0, 0, 0, 0, 0, 0, 0, protoUDP, 200})},
+		{"truncated fragment", ipv6Packet(b, local, protoFragment, []byte{protoTCP, 0, 0})},
+		{"oversized inner header", ipv6Packet(b, local, protoICMP, icmp(1, oversized))},
+		{"truncated inner fragment", ipv6Packet(b, local, protoICMP, icmp(1, ipv6Packet(local, b, protoFragment, nil)))},
+		{"oversized inner ipv4 header", ipv4Packet(b, local, protoICMPv4, icmp(3, badIPv4))},
+	} {
+		if p := parse(test.packet); p.hasPorts {
+			t.Fatalf("%s: unexpected ports %d and %d", test.name, p.srcPort, p.dstPort)
+		}
+		f := newTestFirewall(t, InboundPolicy("deny"))
+		if f.AllowInbound(bob, test.packet) {
+			t.Fatalf("%s: allowed inbound", test.name)
+		}
+		f.AllowOutbound(bob, test.packet)
+	}
+}
@@ -0,0 +1,38 @@
+package firewall
+
+func (f *Firewall) _applyOption(opt SetupOption) {
+	switch v := opt.(type) {
+	case InboundPolicy:
+		f.config.inbound = v
+	case OutboundPolicy:
+		f.config.outbound = v
+	case Rule:
+		f.config.rules = append(f.config.rules, v)
+	}
+}
+
+type SetupOption interface {
+	isSetupOption()
+}
+
+// What to do with packets that no rule matches, either "allow" or "deny".
+// Both are "allow" if empty.
+type InboundPolicy string
+type OutboundPolicy string
+
+// Rule allows or denies packets in one direction. Empty fields match
+// anything. The public key is always that of the remote node, and the ports
+// are destination ports, which are only matched for TCP and UDP.
+type Rule struct {
+	Action      string `json:"action"`                // "allow" or "deny"
+	Direction   string `json:"direction"`             // "in" or "out"
+	PublicKey   string `json:"key,omitempty"`         // Hex-encoded key of the remote node
+	Source      string `json:"source,omitempty"`      // Source prefix, e.g. "fa00::/7" or "10.0.0.0/8"
+	Destination string `json:"destination,omitempty"` // Destination prefix
+	Protocol    string `json:"protocol,omitempty"`    // "tcp", "udp", "icmp" (ICMPv6), "icmpv4" or a number
+	Ports       string `json:"ports,omitempty"`       // A port, e.g. "22", or a range, e.g. "8000-8999"
+}
+
+func (a InboundPolicy) isSetupOption()  {}
+func (a OutboundPolicy) isSetupOption() {}
+func (a Rule) isSetupOption()           {}
@@ -0,0 +1,210 @@
+package firewall
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// IP protocol and IPv6 next header values that matter here.
+const (
+	protoHopByHop    = 0
+	protoICMPv4      = 1
+	protoTCP         = 6
+	protoUDP         = 17
+	protoRouting     = 43
+	protoFragment    = 44
+	protoICMP        = 58
+	protoDestination = 60
+)
+
+// How long a flow is remembered after its last packet, and how many flows
+// are remembered at most.
+const (
+	tcpStateTimeout = 30 * time.Minute
+	stateTimeout    = 2 * time.Minute
+	stateLimit      = 65536
+	pruneInterval   = time.Minute
+)
+
+// packet holds the parts of an IP packet that rules match on.
+type packet struct {
+	src, dst         netip.Addr
+	proto            uint8
+	srcPort, dstPort uint16 // TCP or UDP ports, or the ICMP echo identifier
+	hasPorts         bool   // TCP or UDP ports were found
+	icmpError        bool   // An ICMP error, which includes the packet that caused it
+	inner            []byte // That packet, if icmpError
+}
+
+// parse parses an IPv6 packet of at least 40 bytes, skipping any extension
+// headers, or an IPv4 packet of at least 20 bytes. Fields that can't be
+// found, e.g. in a truncated packet or in a fragment other than the first,
+// are left empty.
+func parse(bs []byte) (p packet) {
+	if bs[0]&0xf0 == 0x40 {
+		return parseIPv4(bs)
+	}
+	p.src = netip.AddrFrom16([16]byte(bs[8:24]))
+	p.dst = netip.AddrFrom16([16]byte(bs[24:40]))
+	next, off := bs[6], 40
+	for {
+		switch next {
+		case protoHopByHop, protoRouting, protoDestination:
+			if len(bs) < off+2 {
+				p.proto = next
+				return
+			}
+			next, off = bs[off], off+(int(bs[off+1])+1)*8
+			if len(bs) < off {
+				p.proto = next
+				return
+			}
+			continue
+		case protoFragment:
+			if len(bs) < off+8 {
+				p.proto = next
+				return
+			}
+			next = bs[off]
+			if binary.BigEndian.Uint16(bs[off+2:])&^7 != 0 {
+				p.proto = next
+				return
+			}
+			off += 8
+			continue
+		}
+		break
+	}
+	p.proto = next
+	p.parseTransport(bs[off:])
+	return
+}
+
+func parseIPv4(bs []byte) (p packet) {
+	p.src = netip.AddrFrom4([4]byte(bs[12:16]))
+	p.dst = netip.AddrFrom4([4]byte(bs[16:20]))
+	p.proto = bs[9]
+	off := int(bs[0]&0x0f) * 4
+	if off < 20 || len(bs) < off || binary.BigEndian.Uint16(bs[6:])&0x1fff != 0 {
+		return
+	}
+	p.parseTransport(bs[off:])
+	return
+}
+
+func (p *packet) parseTransport(bs []byte) {
+	switch p.proto {
+	case protoTCP, protoUDP:
+		if len(bs) >= 4 {
+			p.srcPort = binary.BigEndian.Uint16(bs)
+			p.dstPort = binary.BigEndian.Uint16(bs[2:])
+			p.hasPorts = true
+		}
+	case protoICMP, protoICMPv4:
+		if len(bs) < 8 {
+			return
+		}
+		var isError, isEcho bool
+		if p.proto == protoICMP {
+			// Destination unreachable, packet too big, time exceeded and
+			// parameter problem messages are errors.
+			isError, isEcho = bs[0] < 128, bs[0] == 128 || bs[0] == 129
+		} else {
+			// Destination unreachable, source quench, redirect, time
+			// exceeded and parameter problem messages are errors.
+			switch bs[0] {
+			case 3, 4, 5, 11, 12:
+				isError = true
+			case 0, 8:
+				isEcho = true
+			}
+		}
+		switch {
+		case isError:
+			inner := bs[8:]
+			if len(inner) >= 40 && inner[0]&0xf0 == 0x60 || len(inner) >= 20 && inner[0]&0xf0 == 0x40 {
+				p.icmpError, p.inner = true, inner
+			}
+		case isEcho:
+			// Echo requests and replies are tracked by their identifier.
+			id := binary.BigEndian.Uint16(bs[4:])
+			p.srcPort, p.dstPort = id, id
+		}
+	}
+}
+
+// flow identifies the packets of a connection in both directions.
+type flow struct {
+	proto                 uint8
+	local, remote         netip.Addr
+	localPort, remotePort uint16
+}
+
+func (p *packet) flow(inbound bool) flow {
+	if inbound {
+		return flow{p.proto, p.dst, p.src, p.dstPort, p.srcPort}
+	}
+	return flow{p.proto, p.src, p.dst, p.srcPort, p.dstPort}
+}
+
+// states remembers the flows that packets have been allowed for, so that
+// the rest of their packets are allowed in both directions.
+type states struct {
+	mutex     sync.Mutex
+	flows     map[flow]time.Time // When each flow expires
+	lastPrune time.Time
+}
+
+// refresh extends the flow and returns true if it is known.
+func (s *states) refresh(fl flow, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	expires, ok := s.flows[fl]
+	if !ok || now.After(expires) {
+		return false
+	}
+	s.flows[fl] = now.Add(fl.timeout())
+	return true
+}
+
+// known returns true if the flow is known, without extending it.
+func (s *states) known(fl flow, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	expires, ok := s.flows[fl]
+	return ok && now.Before(expires)
+}
+
+// add remembers the flow, unless too many flows are already known.
+func (s *states) add(fl flow, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if now.Sub(s.lastPrune) >= pruneInterval {
+		for f, expires := range s.flows {
+			if now.After(expires) {
+				delete(s.flows, f)
+			}
+		}
+		s.lastPrune = now
+	}
+	if len(s.flows) >= stateLimit {
+		return false
+	}
+	s.flows[fl] = now.Add(fl.timeout())
+	return true
+}
+
+func (s *states) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.flows)
+}
+
+func (fl flow) timeout() time.Duration {
+	if fl.proto == protoTCP {
+		return tcpStateTimeout
+	}
+	return stateTimeout
+}
@@ -0,0 +1,387 @@
+// Package inmem provides a transport that links nodes in the same process
+// without any sockets, for tests and simulations. Nodes listen on
+// "inmem://name" and peer with "inmem://name?from=self", where the "from"
+// option names the dialling end, so that the conditions of the link between
+// the two names can be set: latency, loss, and whether the link is up.
+package inmem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ruvcoindev/ruvchain/src/core"
+)
+
+// Scheme is the URL scheme that a Network is usually registered for.
+const Scheme = "inmem"
+
+// How long a lost write takes to be sent again, like the minimum
+// retransmission timeout of TCP.
+const retransmitDelay = 200 * time.Millisecond
+
+// Conditions of the link between two names. Links carry a stream of bytes
+// like TCP does, so loss doesn't lose anything, but delays it and everything
+// written after it.
+type Conditions struct {
+	Latency time.Duration // One way
+	Loss    float64       // Probability that a write is lost and sent again
+	Down    bool          // Connections are reset and new ones are refused
+}
+
+// Network is a namespace of listeners, along with the conditions of the
+// links between them. It is a core.Transport, e.g. for use with
+// core.CustomTransport{Scheme: inmem.Scheme, Transport: network}.
+type Network struct {
+	mutex      sync.Mutex
+	rand       *rand.Rand
+	listeners  map[string]*listener
+	conditions map[pair]Conditions
+	conns      map[pair]map[*conn]struct{}
+	anonymous  uint64 // Names given to dialling ends without one
+}
+
+// pair is the two names of a link, in order.
+type pair [2]string
+
+func pairOf(a, b string) pair {
+	if b < a {
+		a, b = b, a
+	}
+	return pair{a, b}
+}
+
+// NewNetwork returns an empty Network. Losses are decided by a random
+// number generator with the given seed.
+func NewNetwork(seed int64) *Network {
+	return &Network{
+		rand:       rand.New(rand.NewSource(seed)),
+		listeners:  make(map[string]*listener),
+		conditions: make(map[pair]Conditions),
+		conns:      make(map[pair]map[*conn]struct{}),
+	}
+}
+
+// SetConditions sets the conditions of the link between two names, in both
+// directions. If the link goes down, its connections are reset.
+func (n *Network) SetConditions(a, b string, c Conditions) {
+	p := pairOf(a, b)
+	n.mutex.Lock()
+	n.conditions[p] = c
+	var reset []*conn
+	if c.Down {
+		for c := range n.conns[p] {
+			reset = append(reset, c)
+		}
+	}
+	n.mutex.Unlock()
+	for _, c := range reset {
+		c.reset()
+	}
+}
+
+// Conditions returns the conditions of the link between two names.
+func (n *Network) Conditions(a, b string) Conditions {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.conditions[pairOf(a, b)]
+}
+
+// delay returns how long a write on the link takes to arrive.
+func (n *Network) delay(p pair) time.Duration {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	c := n.conditions[p]
+	d := c.Latency
+	for i := 0; i < 16 && c.Loss > 0 && n.rand.Float64() < c.Loss; i++ {
+		d += retransmitDelay
+	}
+	return d
+}
+
+// ParseOptions checks that the URL has a name and returns the name of the
+// dialling end, if given.
+func (n *Network) ParseOptions(u *url.URL) (any, error) {
+	if u.Host == "" {
+		return nil, errors.New("no name given")
+	}
+	return u.Query().Get("from"), nil
+}
+
+func (n *Network) Dial(ctx context.Context, u *url.URL, opts core.TransportOptions) (net.Conn, error) {
+	from, _ := opts.Options.(string)
+	n.mutex.Lock()
+	if from == "" {
+		n.anonymous++
+		from = fmt.Sprintf("anonymous%d", n.anonymous)
+	}
+	p := pairOf(from, u.Host)
+	l := n.listeners[u.Host]
+	if l == nil || n.conditions[p].Down {
+		n.mutex.Unlock()
+		return nil, &net.OpError{Op: "dial", Net: Scheme, Addr: Addr(u.Host), Err: syscall.ECONNREFUSED}
+	}
+	local, remote := n.newConn(p, Addr(from), Addr(u.Host))
+	n.mutex.Unlock()
+	select {
+	case l.ch <- remote:
+		return local, nil
+	case <-l.done:
+		_ = local.Close()
+		_ = remote.Close()
+		return nil, &net.OpError{Op: "dial", Net: Scheme, Addr: Addr(u.Host), Err: syscall.ECONNREFUSED}
+	case <-ctx.Done():
+		_ = local.Close()
+		_ = remote.Close()
+		return nil, ctx.Err()
+	}
+}
+
+func (n *Network) Listen(_ context.Context, u *url.URL, _ core.TransportOptions) (net.Listener, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if _, ok := n.listeners[u.Host]; ok {
+		return nil, &net.OpError{Op: "listen", Net: Scheme, Addr: Addr(u.Host), Err: syscall.EADDRINUSE}
+	}
+	l := &listener{
+		network: n,
+		name:    u.Host,
+		ch:      make(chan *conn),
+		done:    make(chan struct{}),
+	}
+	n.listeners[u.Host] = l
+	return l, nil
+}
+
+// newConn returns both ends of a new connection. Must be called with the
+// mutex held.
+func (n *Network) newConn(p pair, a, b Addr) (*conn, *conn) {
+	ab, ba := newPipe(), newPipe()
+	ca := &conn{network: n, pair: p, local: a, remote: b, in: ba, out: ab}
+	cb := &conn{network: n, pair: p, local: b, remote: a, in: ab, out: ba}
+	if n.conns[p] == nil {
+		n.conns[p] = make(map[*conn]struct{})
+	}
+	n.conns[p][ca] = struct{}{}
+	n.conns[p][cb] = struct{}{}
+	return ca, cb
+}
+
+func (n *Network) forget(c *conn) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	delete(n.conns[c.pair], c)
+	if len(n.conns[c.pair]) == 0 {
+		delete(n.conns, c.pair)
+	}
+}
+
+// Addr is the name of one end of a connection.
+type Addr string
+
+func (a Addr) Network() string { return Scheme }
+func (a Addr) String() string  { return string(a) }
+
+type listener struct {
+	network *Network
+	name    string
+	ch      chan *conn
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.ch:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() {
+		l.network.mutex.Lock()
+		delete(l.network.listeners, l.name)
+		l.network.mutex.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return Addr(l.name)
+}
+
+// pipe carries the bytes written to one end of a connection to the other.
+type pipe struct {
+	mutex    sync.Mutex
+	chunks   []chunk
+	last     time.Time     // When the last chunk arrives
+	eof      bool          // The writing end is closed
+	closed   bool          // The reading end is closed
+	err      error         // The connection has been reset
+	deadline time.Time     // For reads
+	wake     chan struct{} // Closed when anything changes
+}
+
+type chunk struct {
+	data []byte
+	at   time.Time // When it arrives
+}
+
+func newPipe() *pipe {
+	return &pipe{wake: make(chan struct{})}
+}
+
+// _signal wakes up a waiting reader. Must be called with the mutex held.
+func (p *pipe) _signal() {
+	close(p.wake)
+	p.wake = make(chan struct{})
+}
+
+func (p *pipe) update(fn func()) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	fn()
+	p._signal()
+}
+
+type conn struct {
+	network       *Network
+	pair          pair
+	local, remote Addr
+	in, out       *pipe
+	mutex         sync.Mutex
+	writeDeadline time.Time
+	once          sync.Once
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	p := c.in
+	for {
+		p.mutex.Lock()
+		now := time.Now()
+		switch {
+		case p.closed:
+			p.mutex.Unlock()
+			return 0, net.ErrClosed
+		case p.err != nil:
+			p.mutex.Unlock()
+			return 0, p.err
+		case !p.deadline.IsZero() && !now.Before(p.deadline):
+			p.mutex.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		wait := time.Duration(-1)
+		if len(p.chunks) > 0 {
+			if ch := &p.chunks[0]; !now.Before(ch.at) {
+				n := copy(b, ch.data)
+				if ch.data = ch.data[n:]; len(ch.data) == 0 {
+					p.chunks = p.chunks[1:]
+				}
+				p.mutex.Unlock()
+				return n, nil
+			}
+			wait = p.chunks[0].at.Sub(now)
+		} else if p.eof {
+			p.mutex.Unlock()
+			return 0, io.EOF
+		}
+		if !p.deadline.IsZero() && (wait < 0 || p.deadline.Sub(now) < wait) {
+			wait = p.deadline.Sub(now)
+		}
+		wake := p.wake
+		p.mutex.Unlock()
+		if wait < 0 {
+			<-wake
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	deadline := c.writeDeadline
+	c.mutex.Unlock()
+	delay := c.network.delay(c.pair)
+	p := c.out
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	switch {
+	case p.eof:
+		return 0, net.ErrClosed
+	case p.err != nil:
+		return 0, p.err
+	case p.closed:
+		return 0, syscall.EPIPE
+	case !deadline.IsZero() && !now.Before(deadline):
+		return 0, os.ErrDeadlineExceeded
+	}
+	// Nothing overtakes what was written before, like with TCP.
+	at := now.Add(delay)
+	if at.Before(p.last) {
+		at = p.last
+	}
+	p.last = at
+	p.chunks = append(p.chunks, chunk{data: append([]byte(nil), b...), at: at})
+	p._signal()
+	return len(b), nil
+}
+
+// Close closes this end of the connection. The other end reads what has
+// been written so far, and then io.EOF.
+func (c *conn) Close() error {
+	c.once.Do(func() {
+		c.in.update(func() { c.in.closed = true })
+		c.out.update(func() { c.out.eof = true })
+		c.network.forget(c)
+	})
+	return nil
+}
+
+// reset breaks both ends of the connection straight away.
+func (c *conn) reset() {
+	for _, p := range []*pipe{c.in, c.out} {
+		p.update(func() {
+			if p.err == nil {
+				p.err = &net.OpError{Op: "read", Net: Scheme, Err: syscall.ECONNRESET}
+			}
+		})
+	}
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.local }
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	_ = c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.in.update(func() { c.in.deadline = t })
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writeDeadline = t
+	return nil
+}
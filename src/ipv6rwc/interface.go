@@ -0,0 +1,247 @@
+package ipv6rwc
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ruvcoindev/ruvchain/src/address"
+)
+
+// How many packets from the network are queued for an Interface before
+// further packets are dropped.
+const interfaceQueueSize = 256
+
+// Destination is the kind of local address that a packet from the network is
+// sent to.
+type Destination string
+
+const (
+	DestinationAddress Destination = "address" // The address of this node
+	DestinationSubnet  Destination = "subnet"  // An address in the subnet of this node
+	DestinationRouted  Destination = "routed"  // An address in a local route
+)
+
+// Selector chooses the packets that belong to an Interface. Empty fields
+// match anything.
+type Selector struct {
+	Destinations []Destination
+	Keys         []ed25519.PublicKey // Of the remote nodes
+}
+
+// Interface is a ReadWriteCloser for the packets that match a selector, so
+// that they can be handled by a TUN adapter of their own. Packets from the
+// network go to the first Interface that they match, or to the
+// ReadWriteCloser itself if they match none, and are only delivered while the
+// ReadWriteCloser is being read. Packets written to an Interface must match
+// its selector too, with the source address taking the part of the
+// destination.
+type Interface struct {
+	rwc          *ReadWriteCloser
+	destinations map[Destination]struct{}
+	keys         map[keyArray]struct{}
+	addrs        map[address.Address]struct{} // Of the keys
+	subnets      map[address.Subnet]struct{}  // Of the keys
+	mtu          atomic.Uint64
+	ch           chan []byte
+	done         chan struct{}
+	once         sync.Once
+}
+
+// AddInterface adds an Interface for the packets that match the selector. It
+// must be called before the ReadWriteCloser is used.
+func (rwc *ReadWriteCloser) AddInterface(s Selector) (*Interface, error) {
+	intf := &Interface{
+		rwc:  rwc,
+		ch:   make(chan []byte, interfaceQueueSize),
+		done: make(chan struct{}),
+	}
+	intf.mtu.Store(1280)
+	if len(s.Destinations) > 0 {
+		intf.destinations = make(map[Destination]struct{})
+		for _, d := range s.Destinations {
+			switch d {
+			case DestinationAddress, DestinationSubnet, DestinationRouted:
+				intf.destinations[d] = struct{}{}
+			default:
+				return nil, fmt.Errorf("unknown destination %q", d)
+			}
+		}
+	}
+	if len(s.Keys) > 0 {
+		intf.keys = make(map[keyArray]struct{})
+		intf.addrs = make(map[address.Address]struct{})
+		intf.subnets = make(map[address.Subnet]struct{})
+		for _, key := range s.Keys {
+			if len(key) != ed25519.PublicKeySize || key.Equal(rwc.core.PublicKey()) {
+				return nil, errors.New("invalid key for interface")
+			}
+			intf.keys[keyArray(key)] = struct{}{}
+			intf.addrs[*address.AddrForKey(key)] = struct{}{}
+			intf.subnets[*address.SubnetForKey(key)] = struct{}{}
+		}
+	}
+	rwc.interfaces = append(rwc.interfaces, intf)
+	return intf, nil
+}
+
+// interfaceFor returns the first Interface that a packet from the network
+// matches, or nil if there is none.
+func (k *keyStore) interfaceFor(dest Destination, key keyArray) *Interface {
+	for _, intf := range k.interfaces {
+		if intf.match(dest, key) {
+			return intf
+		}
+	}
+	return nil
+}
+
+func (intf *Interface) match(dest Destination, key keyArray) bool {
+	if intf.destinations != nil {
+		if _, ok := intf.destinations[dest]; !ok {
+			return false
+		}
+	}
+	if intf.keys != nil {
+		if _, ok := intf.keys[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver queues a copy of the packet from the network, unless the queue is
+// full or the Interface is closed.
+func (intf *Interface) deliver(bs []byte) {
+	if intf.rwc.tooBig(bs, intf.MTU()) {
+		return
+	}
+	select {
+	case <-intf.done:
+	case intf.ch <- append([]byte(nil), bs...):
+	default:
+	}
+}
+
+// source returns the kind of local address that a packet to the network is
+// sent from.
+func (intf *Interface) source(src netip.Addr) Destination {
+	switch {
+	case src.Is6() && address.Address(src.As16()) == intf.rwc.address:
+		return DestinationAddress
+	case src.Is6() && address.Subnet(src.AsSlice()[:8]) == intf.rwc.subnet:
+		return DestinationSubnet
+	default:
+		return DestinationRouted
+	}
+}
+
+// remoteKey returns true if a packet to the destination address would be
+// sent to one of the keys of the Interface.
+func (intf *Interface) remoteKey(dst netip.Addr) bool {
+	if dst.Is6() {
+		if _, ok := intf.addrs[address.Address(dst.As16())]; ok {
+			return true
+		}
+		if _, ok := intf.subnets[address.Subnet(dst.AsSlice()[:8])]; ok {
+			return true
+		}
+	}
+	key, ok := intf.rwc.remoteRoute(dst)
+	if !ok {
+		return false
+	}
+	_, ok = intf.keys[key]
+	return ok
+}
+
+func (intf *Interface) Read(p []byte) (int, error) {
+	select {
+	case bs := <-intf.ch:
+		return copy(p, bs), nil
+	case <-intf.done:
+		return 0, errors.New("interface is closed")
+	}
+}
+
+func (intf *Interface) Write(p []byte) (int, error) {
+	var src, dst netip.Addr
+	switch {
+	case len(p) >= 20 && p[0]&0xf0 == 0x40:
+		src, dst = netip.AddrFrom4([4]byte(p[12:16])), netip.AddrFrom4([4]byte(p[16:20]))
+	case len(p) >= 40 && p[0]&0xf0 == 0x60:
+		src, dst = netip.AddrFrom16([16]byte(p[8:24])), netip.AddrFrom16([16]byte(p[24:40]))
+	default:
+		return intf.rwc.writePC(p) // Let it report the error
+	}
+	if intf.destinations != nil {
+		if _, ok := intf.destinations[intf.source(src)]; !ok {
+			return 0, fmt.Errorf("source address %s doesn't belong to this interface", src)
+		}
+	}
+	if intf.keys != nil && !intf.remoteKey(dst) {
+		return 0, fmt.Errorf("destination address %s doesn't belong to this interface", dst)
+	}
+	return intf.rwc.writePC(p)
+}
+
+func (intf *Interface) Close() error {
+	intf.once.Do(func() {
+		close(intf.done)
+	})
+	return nil
+}
+
+// Address returns no address, as the address of the node is assigned to the
+// TUN adapter of the ReadWriteCloser itself.
+func (intf *Interface) Address() address.Address {
+	return address.Address{}
+}
+
+func (intf *Interface) Subnet() address.Subnet {
+	return address.Subnet{}
+}
+
+func (intf *Interface) MaxMTU() uint64 {
+	return intf.rwc.MaxMTU()
+}
+
+func (intf *Interface) SetMTU(mtu uint64) {
+	if mtu > intf.MaxMTU() {
+		mtu = intf.MaxMTU()
+	}
+	if mtu < 1280 {
+		mtu = 1280
+	}
+	intf.mtu.Store(mtu)
+}
+
+func (intf *Interface) MTU() uint64 {
+	return intf.mtu.Load()
+}
+
+// RemoteRoutes returns the prefixes that should be routed through the
+// Interface: the addresses and subnets of its keys, and the prefixes that are
+// routed to them. Without keys, there are none.
+func (intf *Interface) RemoteRoutes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for addr := range intf.addrs {
+		prefixes = append(prefixes, netip.PrefixFrom(netip.AddrFrom16(addr), 128))
+	}
+	for subnet := range intf.subnets {
+		var bs [16]byte
+		copy(bs[:], subnet[:])
+		prefixes = append(prefixes, netip.PrefixFrom(netip.AddrFrom16(bs), 64))
+	}
+	intf.rwc.mutex.Lock()
+	defer intf.rwc.mutex.Unlock()
+	for _, r := range intf.rwc.remoteRoutes {
+		if _, ok := intf.keys[r.key]; ok {
+			prefixes = append(prefixes, r.prefix)
+		}
+	}
+	return prefixes
+}
@@ -0,0 +1,167 @@
+package ipv6rwc
+
+import (
+	"crypto/ed25519"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ruvcoindev/ruvchain/src/address"
+)
+
+func subnetPrefix(key ed25519.PublicKey) netip.Prefix {
+	var bs [16]byte
+	copy(bs[:], address.SubnetForKey(key)[:])
+	return netip.PrefixFrom(netip.AddrFrom16(bs), 64)
+}
+
+func TestAddInterface(t *testing.T) {
+	rwc := newTestRWC(t)
+	if _, err := rwc.AddInterface(Selector{Destinations: []Destination{"elsewhere"}}); err == nil {
+		t.Fatal("expected unknown destination to be refused")
+	}
+	if _, err := rwc.AddInterface(Selector{Keys: []ed25519.PublicKey{rwc.core.PublicKey()}}); err == nil {
+		t.Fatal("expected the node's own key to be refused")
+	}
+	if _, err := rwc.AddInterface(Selector{Keys: []ed25519.PublicKey{{1, 2, 3}}}); err == nil {
+		t.Fatal("expected invalid key to be refused")
+	}
+	if len(rwc.interfaces) != 0 {
+		t.Fatal("refused interfaces were added")
+	}
+}
+
+// Packets from the network go to the first Interface that they match.
+func TestInterfaceFor(t *testing.T) {
+	rwc := newTestRWC(t)
+	alice, bob, carol := newTestKey(t), newTestKey(t), newTestKey(t)
+	routed, err := rwc.AddInterface(Selector{Destinations: []Destination{DestinationRouted}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromAlice, err := rwc.AddInterface(Selector{Keys: []ed25519.PublicKey{alice}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobToAddress, err := rwc.AddInterface(Selector{
+		Destinations: []Destination{DestinationAddress},
+		Keys:         []ed25519.PublicKey{bob},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		dest     Destination
+		key      ed25519.PublicKey
+		expected *Interface
+	}{
+		{DestinationRouted, alice, routed}, // The first match wins
+		{DestinationRouted, carol, routed},
+		{DestinationAddress, alice, fromAlice},
+		{DestinationSubnet, alice, fromAlice},
+		{DestinationAddress, bob, bobToAddress},
+		{DestinationSubnet, bob, nil},
+		{DestinationAddress, carol, nil},
+	} {
+		if intf := rwc.interfaceFor(tt.dest, keyArray(tt.key)); intf != tt.expected {
+			t.Fatalf("wrong interface for %s", tt.dest)
+		}
+	}
+}
+
+// An Interface takes the routes to the addresses and subnets of its keys and
+// the remote routes to them.
+func TestInterfaceRemoteRoutes(t *testing.T) {
+	rwc := newTestRWC(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+	for prefix, key := range map[string]ed25519.PublicKey{
+		"10.0.0.0/8":  alice,
+		"10.1.0.0/16": bob,
+	} {
+		if err := rwc.AddRemoteRoute(netip.MustParsePrefix(prefix), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	intf, err := rwc.AddInterface(Selector{Keys: []ed25519.PublicKey{alice}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := intf.RemoteRoutes()
+	expected := []netip.Prefix{
+		netip.PrefixFrom(addrForKey(alice), 128),
+		subnetPrefix(alice),
+		netip.MustParsePrefix("10.0.0.0/8"),
+	}
+	if len(routes) != len(expected) {
+		t.Fatalf("unexpected routes %v", routes)
+	}
+	for _, p := range expected {
+		if !slices.Contains(routes, p) {
+			t.Fatalf("route %s missing from %v", p, routes)
+		}
+	}
+	all, err := rwc.AddInterface(Selector{Destinations: []Destination{DestinationRouted}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routes := all.RemoteRoutes(); len(routes) != 0 {
+		t.Fatalf("expected no routes without keys, got %v", routes)
+	}
+}
+
+// Packets written to an Interface must be from a local address of the kind
+// it selects and to one of its keys.
+func TestInterfaceWrite(t *testing.T) {
+	rwc := newTestRWC(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+	if err := rwc.AddLocalRoute(netip.MustParsePrefix("192.168.1.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rwc.AddRemoteRoute(netip.MustParsePrefix("10.0.0.0/8"), alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := rwc.AddRemoteRoute(netip.MustParsePrefix("172.16.0.0/12"), bob); err != nil {
+		t.Fatal(err)
+	}
+	fromAddress, err := rwc.AddInterface(Selector{
+		Destinations: []Destination{DestinationAddress},
+		Keys:         []ed25519.PublicKey{alice},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routed, err := rwc.AddInterface(Selector{
+		Destinations: []Destination{DestinationRouted},
+		Keys:         []ed25519.PublicKey{alice},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	own := netip.AddrFrom16(rwc.address)
+	var subnet [16]byte
+	copy(subnet[:], rwc.subnet[:])
+	subnet[15] = 1
+	for _, tt := range []struct {
+		name     string
+		intf     *Interface
+		src, dst netip.Addr
+		err      string
+	}{
+		{"address to key", fromAddress, own, addrForKey(alice), ""},
+		{"address to subnet of key", fromAddress, own, subnetPrefix(alice).Addr().Next(), ""},
+		{"address to other key", fromAddress, own, addrForKey(bob), "destination address"},
+		{"subnet to key", fromAddress, netip.AddrFrom16(subnet), addrForKey(alice), "source address"},
+		{"routed to route of key", routed, netip.MustParseAddr("192.168.1.5"), netip.MustParseAddr("10.0.0.1"), ""},
+		{"routed to route of other key", routed, netip.MustParseAddr("192.168.1.5"), netip.MustParseAddr("172.16.0.1"), "destination address"},
+		{"address on routed interface", routed, own, addrForKey(alice), "source address"},
+	} {
+		_, err := tt.intf.Write(packet(tt.src, tt.dst))
+		switch {
+		case tt.err == "" && err != nil:
+			t.Fatalf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Fatalf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
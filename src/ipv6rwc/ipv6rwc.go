@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+	"sort"
 	"sync"
 	"time"
 
@@ -41,6 +43,23 @@ type keyStore struct {
 	subnetToInfo map[address.Subnet]*keyInfo
 	subnetBuffer map[address.Subnet]*buffer
 	mtu          uint64
+	filter       Filter
+	localRoutes  []netip.Prefix // Subnets behind this node
+	remoteRoutes []route        // Subnets behind other nodes, longest first
+	interfaces   []*Interface   // Checked in order for packets from the network
+	local        func([]byte)   // Writes packets back to the TUN adapter
+}
+
+type route struct {
+	prefix netip.Prefix
+	key    keyArray
+}
+
+// Filter decides whether packets may pass between the TUN adapter and the
+// node with the given key. The packet must not be retained.
+type Filter interface {
+	AllowInbound(key ed25519.PublicKey, packet []byte) bool
+	AllowOutbound(key ed25519.PublicKey, packet []byte) bool
 }
 
 type keyInfo struct {
@@ -79,7 +98,7 @@ func (k *keyStore) sendToAddress(addr address.Address, bs []byte) {
 	if info := k.addrToInfo[addr]; info != nil {
 		k.resetTimeout(info)
 		k.mutex.Unlock()
-		_, _ = k.core.WriteTo(bs, iwt.Addr(info.key[:]))
+		k.writeTo(info.key, bs)
 	} else {
 		var buf *buffer
 		if buf = k.addrBuffer[addr]; buf == nil {
@@ -108,7 +127,7 @@ func (k *keyStore) sendToSubnet(subnet address.Subnet, bs []byte) {
 	if info := k.subnetToInfo[subnet]; info != nil {
 		k.resetTimeout(info)
 		k.mutex.Unlock()
-		_, _ = k.core.WriteTo(bs, iwt.Addr(info.key[:]))
+		k.writeTo(info.key, bs)
 	} else {
 		var buf *buffer
 		if buf = k.subnetBuffer[subnet]; buf == nil {
@@ -158,11 +177,58 @@ func (k *keyStore) update(key ed25519.PublicKey) *keyInfo {
 	k.resetTimeout(info)
 	k.mutex.Unlock()
 	for _, packet := range packets {
-		_, _ = k.core.WriteTo(packet, iwt.Addr(info.key[:]))
+		k.writeTo(info.key, packet)
 	}
 	return info
 }
 
+func (k *keyStore) writeTo(key keyArray, bs []byte) {
+	if k.filter != nil && !k.filter.AllowOutbound(key[:], bs) {
+		return
+	}
+	if mtu := k.core.PathMTU(key[:]); uint64(len(bs)) > mtu {
+		k.pathTooBig(key, bs, mtu)
+		return
+	}
+	_, _ = k.core.WriteTo(bs, iwt.Addr(key[:]))
+}
+
+// pathTooBig answers a packet that is bigger than the MTU of the path to the
+// node that it would be sent to. For IPv6, an ICMPv6 error from its
+// destination is written back to the TUN adapter that it came from, as if
+// the node had sent it. IPv4 packets are just dropped.
+func (k *keyStore) pathTooBig(key keyArray, bs []byte, mtu uint64) {
+	if bs[0]&0xf0 != 0x60 {
+		return
+	}
+	buf := make([]byte, 512)
+	cn := copy(buf, bs)
+	ptb := &icmp.PacketTooBig{
+		MTU:  int(mtu),
+		Data: buf[:cn],
+	}
+	packet, err := CreateICMPv6(buf[8:24], buf[24:40], ipv6.ICMPTypePacketTooBig, 0, ptb)
+	if err != nil {
+		return
+	}
+	var dstAddr address.Address
+	var dstSubnet address.Subnet
+	copy(dstAddr[:], packet[24:])
+	copy(dstSubnet[:], packet[24:])
+	dest := DestinationRouted
+	switch {
+	case dstAddr == k.address:
+		dest = DestinationAddress
+	case dstSubnet == k.subnet:
+		dest = DestinationSubnet
+	}
+	if intf := k.interfaceFor(dest, key); intf != nil {
+		intf.deliver(packet)
+	} else if k.local != nil {
+		k.local(packet)
+	}
+}
+
 func (k *keyStore) resetTimeout(info *keyInfo) {
 	if info.timeout != nil {
 		info.timeout.Stop()
@@ -240,47 +306,139 @@ func (k *keyStore) readPC(p []byte) (int, error) {
 		if len(bs) == 0 {
 			continue
 		}
-		if bs[0]&0xf0 != 0x60 {
-			continue // not IPv6
+		dest, key, ok := k.check(from, bs)
+		if !ok {
+			continue
 		}
-		if len(bs) < 40 {
+		if intf := k.interfaceFor(dest, key); intf != nil {
+			intf.deliver(bs)
 			continue
 		}
-		k.mutex.Lock()
-		mtu := int(k.mtu)
-		k.mutex.Unlock()
-		if len(bs) > mtu {
-			// Using bs would make it leak off the stack, so copy to buf
-			buf := make([]byte, 512)
-			cn := copy(buf, bs)
-			ptb := &icmp.PacketTooBig{
-				MTU:  mtu,
-				Data: buf[:cn],
-			}
-			if packet, err := CreateICMPv6(buf[8:24], buf[24:40], ipv6.ICMPTypePacketTooBig, 0, ptb); err == nil {
-				_, _ = k.writePC(packet)
-			}
+		if k.tooBig(bs, k.MTU()) {
 			continue
 		}
-		var srcAddr, dstAddr address.Address
-		var srcSubnet, dstSubnet address.Subnet
+		n = copy(p, bs)
+		return n, nil
+	}
+}
+
+// check returns the kind of local address that a packet from the network is
+// sent to and the key of the node that sent it, or false if the packet must
+// be dropped.
+func (k *keyStore) check(from net.Addr, bs []byte) (Destination, keyArray, bool) {
+	switch {
+	case bs[0]&0xf0 == 0x40 && len(bs) >= 20:
+		// IPv4 is only ever routed.
+		src, dst := netip.AddrFrom4([4]byte(bs[12:16])), netip.AddrFrom4([4]byte(bs[16:20]))
+		key, ok := k.readRouted(from, bs, src, dst)
+		return DestinationRouted, key, ok
+	case bs[0]&0xf0 != 0x60:
+		return "", keyArray{}, false // not IPv6
+	case len(bs) < 40:
+		return "", keyArray{}, false
+	}
+	var srcAddr, dstAddr address.Address
+	var srcSubnet, dstSubnet address.Subnet
+	copy(srcAddr[:], bs[8:])
+	copy(dstAddr[:], bs[24:])
+	copy(srcSubnet[:], bs[8:])
+	copy(dstSubnet[:], bs[24:])
+	dest := DestinationAddress
+	switch {
+	case dstAddr == k.address:
+	case dstSubnet == k.subnet:
+		dest = DestinationSubnet
+	default:
+		key, ok := k.readRouted(from, bs, netip.AddrFrom16([16]byte(bs[8:24])), netip.AddrFrom16([16]byte(bs[24:40])))
+		return DestinationRouted, key, ok // bad local address/subnet if not ok
+	}
+	info := k.update(ed25519.PublicKey(from.(iwt.Addr)))
+	if srcAddr != info.address && srcSubnet != info.subnet {
+		if key, ok := k.remoteRoute(netip.AddrFrom16(srcAddr)); !ok || key != info.key {
+			return "", keyArray{}, false // bad remote address/subnet
+		}
+	}
+	if k.filter != nil && !k.filter.AllowInbound(info.key[:], bs) {
+		return "", keyArray{}, false // dropped by the filter
+	}
+	return dest, info.key, true
+}
+
+// tooBig returns true if the packet from the network is bigger than the MTU.
+// An ICMPv6 error is sent back for IPv6 packets, while IPv4 packets are just
+// dropped, as there's no ICMPv6 error that could be sent back for them.
+func (k *keyStore) tooBig(bs []byte, mtu uint64) bool {
+	if len(bs) <= int(mtu) {
+		return false
+	}
+	if bs[0]&0xf0 == 0x60 {
+		// Using bs would make it leak off the stack, so copy to buf
+		buf := make([]byte, 512)
+		cn := copy(buf, bs)
+		ptb := &icmp.PacketTooBig{
+			MTU:  int(mtu),
+			Data: buf[:cn],
+		}
+		if packet, err := CreateICMPv6(buf[8:24], buf[24:40], ipv6.ICMPTypePacketTooBig, 0, ptb); err == nil {
+			_, _ = k.writePC(packet)
+		}
+	}
+	return true
+}
+
+// readRouted checks a packet from the network to an address that isn't this
+// node's own, which must be in a local route and come from an address that
+// is routed to the node that sent it. The key of that node is returned.
+func (k *keyStore) readRouted(from net.Addr, bs []byte, src, dst netip.Addr) (keyArray, bool) {
+	if !k.localRoute(dst) {
+		return keyArray{}, false
+	}
+	info := k.update(ed25519.PublicKey(from.(iwt.Addr)))
+	if src.Is6() {
+		var srcAddr address.Address
+		var srcSubnet address.Subnet
 		copy(srcAddr[:], bs[8:])
-		copy(dstAddr[:], bs[24:])
 		copy(srcSubnet[:], bs[8:])
-		copy(dstSubnet[:], bs[24:])
-		if dstAddr != k.address && dstSubnet != k.subnet {
-			continue // bad local address/subnet
+		if srcAddr == info.address || srcSubnet == info.subnet {
+			return info.key, k.filter == nil || k.filter.AllowInbound(info.key[:], bs)
 		}
-		info := k.update(ed25519.PublicKey(from.(iwt.Addr)))
-		if srcAddr != info.address && srcSubnet != info.subnet {
-			continue // bad remote address/subnet
+	}
+	if key, ok := k.remoteRoute(src); !ok || key != info.key {
+		return keyArray{}, false
+	}
+	return info.key, k.filter == nil || k.filter.AllowInbound(info.key[:], bs)
+}
+
+// localRoute returns true if the address is in a local route.
+func (k *keyStore) localRoute(addr netip.Addr) bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for _, prefix := range k.localRoutes {
+		if prefix.Contains(addr) {
+			return true
 		}
-		n = copy(p, bs)
-		return n, nil
 	}
+	return false
+}
+
+// remoteRoute returns the key of the node that the address is routed to.
+func (k *keyStore) remoteRoute(addr netip.Addr) (keyArray, bool) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for _, r := range k.remoteRoutes {
+		if r.prefix.Contains(addr) {
+			return r.key, true
+		}
+	}
+	return keyArray{}, false
 }
 
 func (k *keyStore) writePC(bs []byte) (int, error) {
+	if len(bs) >= 20 && bs[0]&0xf0 == 0x40 {
+		// IPv4 is only ever routed.
+		src, dst := netip.AddrFrom4([4]byte(bs[12:16])), netip.AddrFrom4([4]byte(bs[16:20]))
+		return k.writeRouted(bs, src, dst)
+	}
 	if bs[0]&0xf0 != 0x60 {
 		return 0, errors.New("not an IPv6 packet") // not IPv6
 	}
@@ -294,7 +452,7 @@ func (k *keyStore) writePC(bs []byte) (int, error) {
 	copy(dstAddr[:], bs[24:])
 	copy(srcSubnet[:], bs[8:])
 	copy(dstSubnet[:], bs[24:])
-	if srcAddr != k.address && srcSubnet != k.subnet {
+	if srcAddr != k.address && srcSubnet != k.subnet && !k.localRoute(netip.AddrFrom16(srcAddr)) {
 		// This happens all the time due to link-local traffic
 		// Don't send back an error, just drop it
 		strErr := fmt.Sprint("incorrect source address: ", net.IP(srcAddr[:]).String())
@@ -305,11 +463,25 @@ func (k *keyStore) writePC(bs []byte) (int, error) {
 	} else if dstSubnet.IsValid() {
 		k.sendToSubnet(dstSubnet, bs)
 	} else {
-		return 0, errors.New("invalid destination address")
+		return k.writeRouted(bs, netip.AddrFrom16(srcAddr), netip.AddrFrom16([16]byte(dstAddr)))
 	}
 	return len(bs), nil
 }
 
+// writeRouted sends a packet to an address outside of the network to the
+// node that it is routed to.
+func (k *keyStore) writeRouted(bs []byte, src, dst netip.Addr) (int, error) {
+	if src.Is4() && !k.localRoute(src) {
+		return 0, fmt.Errorf("incorrect source address: %s", src)
+	}
+	key, ok := k.remoteRoute(dst)
+	if !ok {
+		return 0, fmt.Errorf("no route to %s", dst)
+	}
+	k.writeTo(key, bs)
+	return len(bs), nil
+}
+
 // Exported API
 
 func (k *keyStore) MaxMTU() uint64 {
@@ -353,6 +525,91 @@ func (rwc *ReadWriteCloser) Subnet() address.Subnet {
 	return rwc.subnet
 }
 
+// SetLocalWriter sets the function that writes packets back to the TUN
+// adapter, e.g. ICMPv6 errors for packets that are too big for the path to
+// their destination. It must be called before the ReadWriteCloser is used.
+func (rwc *ReadWriteCloser) SetLocalWriter(w func([]byte)) {
+	rwc.local = w
+}
+
+// SetFilter sets a filter for packets to and from the network. It must be
+// called before the ReadWriteCloser is used.
+func (rwc *ReadWriteCloser) SetFilter(f Filter) {
+	rwc.filter = f
+}
+
+// AddLocalRoute accepts packets from the network to addresses in the prefix,
+// e.g. a LAN behind this node, and allows packets from them to be sent. The
+// prefix may be IPv4 or IPv6 but must not be in the range of the network.
+func (rwc *ReadWriteCloser) AddLocalRoute(prefix netip.Prefix) error {
+	prefix, err := checkRoute(prefix)
+	if err != nil {
+		return err
+	}
+	rwc.mutex.Lock()
+	defer rwc.mutex.Unlock()
+	for _, p := range rwc.localRoutes {
+		if p == prefix {
+			return fmt.Errorf("local route %s already exists", prefix)
+		}
+	}
+	rwc.localRoutes = append(rwc.localRoutes, prefix)
+	return nil
+}
+
+// AddRemoteRoute sends packets to addresses in the prefix to the node with
+// the given key, and only accepts packets from them from that node.
+func (rwc *ReadWriteCloser) AddRemoteRoute(prefix netip.Prefix, key ed25519.PublicKey) error {
+	prefix, err := checkRoute(prefix)
+	if err != nil {
+		return err
+	}
+	if len(key) != ed25519.PublicKeySize || key.Equal(rwc.core.PublicKey()) {
+		return fmt.Errorf("invalid key for remote route %s", prefix)
+	}
+	rwc.mutex.Lock()
+	defer rwc.mutex.Unlock()
+	for _, r := range rwc.remoteRoutes {
+		if r.prefix == prefix {
+			return fmt.Errorf("remote route %s already exists", prefix)
+		}
+	}
+	r := route{prefix: prefix}
+	copy(r.key[:], key)
+	rwc.remoteRoutes = append(rwc.remoteRoutes, r)
+	sort.SliceStable(rwc.remoteRoutes, func(i, j int) bool {
+		return rwc.remoteRoutes[i].prefix.Bits() > rwc.remoteRoutes[j].prefix.Bits()
+	})
+	return nil
+}
+
+// RemoteRoutes returns the prefixes that are routed to other nodes.
+func (rwc *ReadWriteCloser) RemoteRoutes() []netip.Prefix {
+	rwc.mutex.Lock()
+	defer rwc.mutex.Unlock()
+	prefixes := make([]netip.Prefix, 0, len(rwc.remoteRoutes))
+	for _, r := range rwc.remoteRoutes {
+		prefixes = append(prefixes, r.prefix)
+	}
+	return prefixes
+}
+
+func checkRoute(prefix netip.Prefix) (netip.Prefix, error) {
+	if !prefix.IsValid() {
+		return prefix, errors.New("invalid route prefix")
+	}
+	if prefix.Addr().Is4In6() {
+		return prefix, fmt.Errorf("route %s must be given as IPv4", prefix)
+	}
+	prefix = prefix.Masked()
+	var overlay [16]byte
+	overlay[0] = address.GetPrefix()[0]
+	if prefix.Overlaps(netip.PrefixFrom(netip.AddrFrom16(overlay), 7)) {
+		return prefix, fmt.Errorf("route %s overlaps the network's own addresses", prefix)
+	}
+	return prefix, nil
+}
+
 func (rwc *ReadWriteCloser) Read(p []byte) (n int, err error) {
 	return rwc.readPC(p)
 }
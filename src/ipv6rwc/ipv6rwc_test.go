@@ -0,0 +1,237 @@
+package ipv6rwc
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+
+	iwt "github.com/Arceliar/ironwood/types"
+	"github.com/gologme/log"
+
+	"github.com/ruvcoindev/ruvchain/src/address"
+	"github.com/ruvcoindev/ruvchain/src/config"
+	"github.com/ruvcoindev/ruvchain/src/core"
+)
+
+func newTestRWC(t *testing.T) *ReadWriteCloser {
+	t.Helper()
+	cfg := config.GenerateConfig()
+	c, err := core.New(cfg.Certificate, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Stop)
+	return NewReadWriteCloser(c)
+}
+
+func newTestKey(t *testing.T) ed25519.PublicKey {
+	t.Helper()
+	key, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// packet returns a minimal IPv4 or IPv6 header from src to dst.
+func packet(src, dst netip.Addr) []byte {
+	if src.Is4() {
+		bs := make([]byte, 20)
+		bs[0] = 0x45
+		copy(bs[12:16], src.AsSlice())
+		copy(bs[16:20], dst.AsSlice())
+		return bs
+	}
+	bs := make([]byte, 40)
+	bs[0] = 0x60
+	copy(bs[8:24], src.AsSlice())
+	copy(bs[24:40], dst.AsSlice())
+	return bs
+}
+
+func addrForKey(key ed25519.PublicKey) netip.Addr {
+	return netip.AddrFrom16(*address.AddrForKey(key))
+}
+
+func TestCheckRoute(t *testing.T) {
+	for route, ok := range map[string]bool{
+		"10.0.0.0/8":          true,
+		"192.168.1.7/24":      true, // Masked
+		"2001:db8::/32":       true,
+		"fc00::/7":            true,
+		"fa00::/7":            false, // The network itself
+		"fa12::/16":           false,
+		"fb00::/8":            false,
+		"f000::/4":            false, // Contains the network
+		"::/0":                false,
+		"::ffff:10.0.0.0/104": false, // Must be given as IPv4
+	} {
+		_, err := checkRoute(netip.MustParsePrefix(route))
+		if (err == nil) != ok {
+			t.Fatalf("checkRoute(%s) = %v, expected ok %v", route, err, ok)
+		}
+	}
+	rwc := newTestRWC(t)
+	if err := rwc.AddLocalRoute(netip.MustParsePrefix("fa00::/8")); err == nil {
+		t.Fatal("expected local route overlapping the network to be refused")
+	}
+	if err := rwc.AddRemoteRoute(netip.MustParsePrefix("fb00::/8"), newTestKey(t)); err == nil {
+		t.Fatal("expected remote route overlapping the network to be refused")
+	}
+}
+
+// The most specific remote route wins, regardless of the order in which the
+// routes were added.
+func TestRemoteRouteLongestPrefix(t *testing.T) {
+	rwc := newTestRWC(t)
+	wide, narrow, v6 := newTestKey(t), newTestKey(t), newTestKey(t)
+	for _, r := range []struct {
+		prefix string
+		key    ed25519.PublicKey
+	}{
+		{"10.0.0.0/8", wide},
+		{"10.1.0.0/16", narrow},
+		{"2001:db8::/32", wide},
+		{"2001:db8:1::/48", v6},
+	} {
+		if err := rwc.AddRemoteRoute(netip.MustParsePrefix(r.prefix), r.key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rwc.AddRemoteRoute(netip.MustParsePrefix("10.1.0.0/16"), wide); err == nil {
+		t.Fatal("expected duplicate remote route to be refused")
+	}
+	for addr, expected := range map[string]ed25519.PublicKey{
+		"10.1.2.3":      narrow,
+		"10.2.0.1":      wide,
+		"2001:db8:1::1": v6,
+		"2001:db8:2::1": wide,
+		"192.168.0.1":   nil,
+		"2001:db9::1":   nil,
+	} {
+		key, ok := rwc.remoteRoute(netip.MustParseAddr(addr))
+		switch {
+		case expected == nil && ok:
+			t.Fatalf("expected no route for %s", addr)
+		case expected != nil && (!ok || key != keyArray(expected)):
+			t.Fatalf("wrong route for %s", addr)
+		}
+	}
+}
+
+// Packets from the network to routed addresses must be to a local route and
+// from an address that is routed to the node that sent them.
+func TestReadRouted(t *testing.T) {
+	rwc := newTestRWC(t)
+	gateway, other := newTestKey(t), newTestKey(t)
+	for _, p := range []string{"192.168.1.0/24", "2001:db8:1::/48"} {
+		if err := rwc.AddLocalRoute(netip.MustParsePrefix(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{"10.0.0.0/8", "2001:db8:2::/48"} {
+		if err := rwc.AddRemoteRoute(netip.MustParsePrefix(p), gateway); err != nil {
+			t.Fatal(err)
+		}
+	}
+	local4, local6 := netip.MustParseAddr("192.168.1.10"), netip.MustParseAddr("2001:db8:1::10")
+	for _, tt := range []struct {
+		name     string
+		from     ed25519.PublicKey
+		src, dst netip.Addr
+		ok       bool
+	}{
+		{"IPv4 from gateway", gateway, netip.MustParseAddr("10.0.0.5"), local4, true},
+		{"IPv4 from wrong key", other, netip.MustParseAddr("10.0.0.5"), local4, false},
+		{"IPv4 from unrouted source", gateway, netip.MustParseAddr("172.16.0.1"), local4, false},
+		{"IPv4 to unrouted destination", gateway, netip.MustParseAddr("10.0.0.5"), netip.MustParseAddr("172.16.0.1"), false},
+		{"IPv6 from gateway", gateway, netip.MustParseAddr("2001:db8:2::5"), local6, true},
+		{"IPv6 from wrong key", other, netip.MustParseAddr("2001:db8:2::5"), local6, false},
+		{"IPv6 from the node's own address", other, addrForKey(other), local6, true},
+		{"IPv6 to unrouted destination", gateway, netip.MustParseAddr("2001:db8:2::5"), netip.MustParseAddr("2001:db8:3::1"), false},
+	} {
+		dest, key, ok := rwc.check(iwt.Addr(tt.from), packet(tt.src, tt.dst))
+		if ok != tt.ok {
+			t.Fatalf("%s: expected ok %v", tt.name, tt.ok)
+		}
+		if ok && (dest != DestinationRouted || key != keyArray(tt.from)) {
+			t.Fatalf("%s: unexpected destination %q or key", tt.name, dest)
+		}
+	}
+}
+
+// Routed packets to the network must be from a local route and to a remote
+// route.
+func TestWriteRouted(t *testing.T) {
+	rwc := newTestRWC(t)
+	if err := rwc.AddLocalRoute(netip.MustParsePrefix("192.168.1.0/24")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rwc.AddRemoteRoute(netip.MustParsePrefix("10.0.0.0/8"), newTestKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		src, dst string
+		err      string
+	}{
+		{"192.168.1.10", "10.0.0.5", ""},
+		{"192.168.1.10", "172.16.0.1", "no route"},
+		{"192.168.2.10", "10.0.0.5", "incorrect source address"},
+		{"2001:db8::1", "2001:db8::2", "incorrect source address"},
+	} {
+		_, err := rwc.writePC(packet(netip.MustParseAddr(tt.src), netip.MustParseAddr(tt.dst)))
+		switch {
+		case tt.err == "" && err != nil:
+			t.Fatalf("%s -> %s: %v", tt.src, tt.dst, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Fatalf("%s -> %s: expected error %q, got %v", tt.src, tt.dst, tt.err, err)
+		}
+	}
+}
+
+// Packets that are too big for the path to their destination are answered
+// with a Packet Too Big from the destination, written back locally.
+func TestPathTooBig(t *testing.T) {
+	rwc := newTestRWC(t)
+	var written []byte
+	rwc.SetLocalWriter(func(bs []byte) {
+		written = append([]byte(nil), bs...)
+	})
+	remote := newTestKey(t)
+	src, dst := netip.AddrFrom16(rwc.address), addrForKey(remote)
+	bs := append(packet(src, dst), make([]byte, 1400)...)
+	rwc.pathTooBig(keyArray(remote), bs, 1280)
+
+	if len(written) < 40 {
+		t.Fatal("expected a packet to be written locally")
+	}
+	if len(written) > 1280 {
+		t.Fatalf("packet of %d bytes is larger than the minimum MTU", len(written))
+	}
+	if from := netip.AddrFrom16([16]byte(written[8:24])); from != dst {
+		t.Fatalf("unexpected source %s", from)
+	}
+	if to := netip.AddrFrom16([16]byte(written[24:40])); to != src {
+		t.Fatalf("unexpected destination %s", to)
+	}
+	msg, err := icmp.ParseMessage(ipv6.ICMPTypePacketTooBig.Protocol(), written[40:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ptb, ok := msg.Body.(*icmp.PacketTooBig)
+	if msg.Type != ipv6.ICMPTypePacketTooBig || !ok {
+		t.Fatalf("unexpected message %v", msg.Type)
+	}
+	if ptb.MTU != 1280 {
+		t.Fatalf("unexpected MTU %d", ptb.MTU)
+	}
+	if !bytes.HasPrefix(ptb.Data, bs[:40]) {
+		t.Fatal("expected the original header to be quoted")
+	}
+}
@@ -0,0 +1,242 @@
+// Package metrics serves statistics about a running node over HTTP in the
+// Prometheus text exposition format, so that they can be scraped without
+// going through the admin socket.
+package metrics
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ruvcoindev/ruvchain/src/core"
+	"github.com/ruvcoindev/ruvchain/src/tun"
+	"github.com/ruvcoindev/ruvchain/src/version"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type Metrics struct {
+	core     *core.Core
+	tun      *tun.TunAdapter
+	log      core.Logger
+	listener net.Listener
+	server   *http.Server
+	config   struct {
+		listenaddr ListenAddress
+	}
+}
+
+// New starts the metrics listener. The TUN adapter is optional and its
+// counters are only exported if it is given. If no listen address is
+// configured, nil is returned and no metrics are served.
+func New(c *core.Core, t *tun.TunAdapter, log core.Logger, opts ...SetupOption) (*Metrics, error) {
+	m := &Metrics{
+		core: c,
+		tun:  t,
+		log:  log,
+	}
+	for _, opt := range opts {
+		m._applyOption(opt)
+	}
+	if m.config.listenaddr == "none" || m.config.listenaddr == "" {
+		return nil, nil
+	}
+
+	listenaddr := string(m.config.listenaddr)
+	if u, err := url.Parse(listenaddr); err == nil && strings.EqualFold(u.Scheme, "http") {
+		listenaddr = u.Host
+	}
+	var err error
+	if m.listener, err = net.Listen("tcp", listenaddr); err != nil {
+		return nil, fmt.Errorf("metrics listener failed to listen: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.handler)
+	m.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	m.log.Infof("Metrics listening on http://%s/metrics", m.listener.Addr())
+	go func() {
+		if err := m.server.Serve(m.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.log.Errorln("Metrics listener stopped:", err)
+		}
+	}()
+	return m, nil
+}
+
+// Addr returns the address that the metrics listener is bound to.
+func (m *Metrics) Addr() net.Addr {
+	return m.listener.Addr()
+}
+
+// Stop will stop the metrics listener.
+func (m *Metrics) Stop() error {
+	if m == nil || m.server == nil {
+		return nil
+	}
+	return m.server.Close()
+}
+
+func (m *Metrics) handler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	m.collect(&buf)
+	w.Header().Set("Content-Type", contentType)
+	_, _ = buf.WriteTo(w)
+}
+
+// collect writes all metrics in the text exposition format.
+func (m *Metrics) collect(out io.Writer) {
+	w := &writer{out: out}
+
+	w.family("ruvchain_build_info", "gauge", "Build information about the running node.")
+	w.sample("ruvchain_build_info", 1,
+		"name", version.BuildName(),
+		"version", version.BuildVersion(),
+	)
+
+	self := m.core.GetSelf()
+	w.family("ruvchain_routing_entries", "gauge", "Number of entries in the routing table.")
+	w.sample("ruvchain_routing_entries", float64(self.RoutingEntries))
+
+	w.family("ruvchain_tree_entries", "gauge", "Number of nodes in the spanning tree known to this node.")
+	w.sample("ruvchain_tree_entries", float64(len(m.core.GetTree())))
+
+	m.collectPeers(w)
+
+	sessions := m.core.GetSessions()
+	var rx, tx uint64
+	for _, s := range sessions {
+		rx += s.RXBytes
+		tx += s.TXBytes
+	}
+	w.family("ruvchain_sessions", "gauge", "Number of open sessions.")
+	w.sample("ruvchain_sessions", float64(len(sessions)))
+	w.family("ruvchain_session_rx_bytes", "gauge", "Bytes received over all open sessions.")
+	w.sample("ruvchain_session_rx_bytes", float64(rx))
+	w.family("ruvchain_session_tx_bytes", "gauge", "Bytes sent over all open sessions.")
+	w.sample("ruvchain_session_tx_bytes", float64(tx))
+
+	failures := m.core.GetDialFailures()
+	classes := make([]string, 0, len(failures))
+	for class := range failures {
+		classes = append(classes, class)
+	}
+	slices.Sort(classes)
+	w.family("ruvchain_link_dial_failures_total", "counter", "Failed outbound peer connection attempts by error class.")
+	for _, class := range classes {
+		w.sample("ruvchain_link_dial_failures_total", float64(failures[class]), "class", class)
+	}
+
+	if m.tun != nil && m.tun.IsStarted() {
+		stats := m.tun.Stats()
+		w.family("ruvchain_tun_read_packets_total", "counter", "Packets read from the TUN interface.")
+		w.sample("ruvchain_tun_read_packets_total", float64(stats.ReadPackets))
+		w.family("ruvchain_tun_read_bytes_total", "counter", "Bytes read from the TUN interface.")
+		w.sample("ruvchain_tun_read_bytes_total", float64(stats.ReadBytes))
+		w.family("ruvchain_tun_written_packets_total", "counter", "Packets written to the TUN interface.")
+		w.sample("ruvchain_tun_written_packets_total", float64(stats.WrittenPackets))
+		w.family("ruvchain_tun_written_bytes_total", "counter", "Bytes written to the TUN interface.")
+		w.sample("ruvchain_tun_written_bytes_total", float64(stats.WrittenBytes))
+	}
+}
+
+func (m *Metrics) collectPeers(w *writer) {
+	peers := m.core.GetPeers()
+	slices.SortFunc(peers, func(a, b core.PeerInfo) int {
+		return strings.Compare(a.URI, b.URI)
+	})
+	labels := func(p core.PeerInfo) []string {
+		key := ""
+		if len(p.Key) > 0 {
+			key = hex.EncodeToString(p.Key)
+		}
+		dir := "outbound"
+		if p.Inbound {
+			dir = "inbound"
+		}
+		return []string{"key", key, "uri", p.URI, "direction", dir}
+	}
+	// Traffic and link statistics only make sense while the peering is up.
+	each := func(name, typ, help string, value func(core.PeerInfo) float64) {
+		w.family(name, typ, help)
+		for _, p := range peers {
+			if p.Up {
+				w.sample(name, value(p), labels(p)...)
+			}
+		}
+	}
+
+	w.family("ruvchain_peer_up", "gauge", "Whether the peering is currently up.")
+	for _, p := range peers {
+		up := 0.0
+		if p.Up {
+			up = 1
+		}
+		w.sample("ruvchain_peer_up", up, labels(p)...)
+	}
+	each("ruvchain_peer_rx_bytes_total", "counter", "Bytes received from the peer on the current connection.", func(p core.PeerInfo) float64 {
+		return float64(p.RXBytes)
+	})
+	each("ruvchain_peer_tx_bytes_total", "counter", "Bytes sent to the peer on the current connection.", func(p core.PeerInfo) float64 {
+		return float64(p.TXBytes)
+	})
+	each("ruvchain_peer_rx_rate_bytes", "gauge", "Bytes received from the peer in the last second.", func(p core.PeerInfo) float64 {
+		return float64(p.RXRate)
+	})
+	each("ruvchain_peer_tx_rate_bytes", "gauge", "Bytes sent to the peer in the last second.", func(p core.PeerInfo) float64 {
+		return float64(p.TXRate)
+	})
+	each("ruvchain_peer_latency_seconds", "gauge", "Round-trip latency to the peer.", func(p core.PeerInfo) float64 {
+		return p.Latency.Seconds()
+	})
+	each("ruvchain_peer_cost", "gauge", "Link cost of the peering.", func(p core.PeerInfo) float64 {
+		return float64(p.Cost)
+	})
+	each("ruvchain_peer_uptime_seconds", "gauge", "Time since the peering came up.", func(p core.PeerInfo) float64 {
+		return p.Uptime.Seconds()
+	})
+}
+
+// writer writes metric families and samples in the text exposition format.
+type writer struct {
+	out io.Writer
+}
+
+func (w *writer) family(name, typ, help string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w.out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a single sample. The labels are given as name/value pairs.
+func (w *writer) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(escape.Replace(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	b.WriteByte('\n')
+	_, _ = io.WriteString(w.out, b.String())
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gologme/log"
+
+	"github.com/ruvcoindev/ruvchain/src/config"
+	"github.com/ruvcoindev/ruvchain/src/core"
+)
+
+func TestWriterEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	w := &writer{out: &buf}
+	w.family("test_metric", "gauge", "Help with a \\ and a\nnewline.")
+	w.sample("test_metric", 1.5, "uri", "tcp://\"a\"\\b\n")
+	w.sample("test_metric", 2)
+	expected := "# HELP test_metric Help with a \\\\ and a\\nnewline.\n" +
+		"# TYPE test_metric gauge\n" +
+		"test_metric{uri=\"tcp://\\\"a\\\"\\\\b\\n\"} 1.5\n" +
+		"test_metric 2\n"
+	if got := buf.String(); got != expected {
+		t.Fatalf("unexpected output\nwant: %q\n got: %q", expected, got)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	cfg := config.GenerateConfig()
+	c, err := core.New(cfg.Certificate, log.New(os.Stderr, "", log.Flags()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	// Add a peer that can't be reached, so that it shows up as down.
+	u, _ := url.Parse("tcp://127.0.0.1:1")
+	if err := c.AddPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(c, nil, log.New(io.Discard, "", 0), ListenAddress("127.0.0.1:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	resp, err := http.Get("http://" + m.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != contentType {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"# TYPE ruvchain_peer_up gauge\n",
+		`ruvchain_peer_up{key="",uri="tcp://127.0.0.1:1",direction="outbound"} 0`,
+		"ruvchain_sessions 0\n",
+		"# TYPE ruvchain_link_dial_failures_total counter\n",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Fatalf("expected %q in output:\n%s", expected, body)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package metrics
+
+func (m *Metrics) _applyOption(opt SetupOption) {
+	switch v := opt.(type) {
+	case ListenAddress:
+		m.config.listenaddr = v
+	}
+}
+
+type SetupOption interface {
+	isSetupOption()
+}
+
+type ListenAddress string
+
+func (a ListenAddress) isSetupOption() {}
@@ -47,7 +47,7 @@ func (m *Multicast) getMulticastInterfacesHandler(_ *GetMulticastInterfacesReque
 }
 
 func (m *Multicast) SetupAdminHandlers(a *admin.AdminSocket) {
-	_ = a.AddHandler(
+	_ = a.AddReadOnlyHandler(
 		"getMulticastInterfaces", "Show which interfaces multicast is enabled on", []string{},
 		func(in json.RawMessage) (interface{}, error) {
 			req := &GetMulticastInterfacesRequest{}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/ed25519"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
@@ -113,7 +114,7 @@ func (m *Multicast) _start() error {
 		// Windows can't set this flag, so we need to handle it in other ways
 	}
 
-	go m.listen()
+	go m.listen(m.sock)
 	m.Act(nil, m._multicastStarted)
 	m.Act(nil, m._announce)
 
@@ -140,12 +141,53 @@ func (m *Multicast) _stop() error {
 		return nil
 	}
 	m.log.Infoln("Stopping multicast module")
+	if m._timer != nil {
+		m._timer.Stop()
+		m._timer = nil
+	}
+	for name, info := range m._listeners {
+		info.listener.Cancel()
+		delete(m._listeners, name)
+	}
 	if m.sock != nil {
 		m.sock.Close()
 	}
 	return nil
 }
 
+// SetInterfaces replaces the configured multicast interfaces. Existing
+// listeners are stopped so that they are recreated with the new settings on
+// the next announcement, and the module is started or stopped depending on
+// whether any of the new interfaces have beaconing or listening enabled.
+func (m *Multicast) SetInterfaces(intfs ...MulticastInterface) error {
+	var err error
+	phony.Block(m, func() {
+		m.config._interfaces = make(map[MulticastInterface]struct{}, len(intfs))
+		var anyEnabled bool
+		for _, intf := range intfs {
+			m.config._interfaces[intf] = struct{}{}
+			anyEnabled = anyEnabled || intf.Beacon || intf.Listen
+		}
+		if !m.running.Load() {
+			err = m._start()
+			return
+		}
+		if !anyEnabled {
+			err = m._stop()
+			return
+		}
+		for name, info := range m._listeners {
+			info.listener.Cancel()
+			delete(m._listeners, name)
+		}
+		if m._timer != nil {
+			m._timer.Stop()
+		}
+		m.Act(nil, m._announce)
+	})
+	return err
+}
+
 func (m *Multicast) _updateInterfaces() {
 	interfaces := m._getAllowedInterfaces()
 	for name, info := range interfaces {
@@ -351,7 +393,7 @@ func (m *Multicast) _announce() {
 			addr := linfo.listener.Addr().(*net.TCPAddr)
 			adv := multicastAdvertisement{
 				MajorVersion: core.ProtocolVersionMajor,
-				MinorVersion: core.ProtocolVersionMinor,
+				MinorVersion: core.ProtocolVersionMinorCompatible,
 				PublicKey:    m.core.PublicKey(),
 				Port:         uint16(addr.Port),
 				Hash:         info.hash,
@@ -377,7 +419,7 @@ func (m *Multicast) _announce() {
 	})
 }
 
-func (m *Multicast) listen() {
+func (m *Multicast) listen(sock *ipv6.PacketConn) {
 	groupAddr, err := net.ResolveUDPAddr("udp6", string(m.config._groupAddr))
 	if err != nil {
 		panic(err)
@@ -388,9 +430,9 @@ func (m *Multicast) listen() {
 		if !m.running.Load() {
 			return
 		}
-		n, rcm, fromAddr, err := m.sock.ReadFrom(bs)
+		n, rcm, fromAddr, err := sock.ReadFrom(bs)
 		if err != nil {
-			if !m.IsStarted() {
+			if !m.IsStarted() || errors.Is(err, net.ErrClosed) {
 				return
 			}
 			panic(err)
@@ -413,7 +455,7 @@ func (m *Multicast) listen() {
 		switch {
 		case adv.MajorVersion != core.ProtocolVersionMajor:
 			continue
-		case adv.MinorVersion != core.ProtocolVersionMinor:
+		case adv.MinorVersion != core.ProtocolVersionMinorCompatible:
 			continue
 		case adv.PublicKey.Equal(m.core.PublicKey()):
 			continue
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/ruvcoindev/ruvchain/src/admin"
+)
+
+type AddForwardRequest struct {
+	Forward
+}
+
+type AddForwardResponse struct{}
+
+type RemoveForwardRequest struct {
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"`
+	Listen   string `json:"listen"`
+}
+
+type RemoveForwardResponse struct{}
+
+type GetForwardsRequest struct{}
+
+type GetForwardsResponse struct {
+	Forwards []Forward `json:"forwards"`
+}
+
+func (p *Proxy) addForwardHandler(req *AddForwardRequest, _ *AddForwardResponse) error {
+	return p.AddForward(req.Forward)
+}
+
+func (p *Proxy) removeForwardHandler(req *RemoveForwardRequest, _ *RemoveForwardResponse) error {
+	return p.RemoveForward(Forward{Type: req.Type, Protocol: req.Protocol, Listen: req.Listen})
+}
+
+func (p *Proxy) getForwardsHandler(_ *GetForwardsRequest, res *GetForwardsResponse) error {
+	res.Forwards = p.Forwards()
+	sort.Slice(res.Forwards, func(i, j int) bool {
+		return res.Forwards[i].id() < res.Forwards[j].id()
+	})
+	return nil
+}
+
+func (p *Proxy) SetupAdminHandlers(a *admin.AdminSocket) {
+	_ = a.AddHandler(
+		"addForward", "Forward a local address into the network (type=local) or an address on this node to a local one (type=remote)", []string{"type", "protocol", "listen", "target"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &AddForwardRequest{}
+			res := &AddForwardResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := p.addForwardHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddHandler(
+		"removeForward", "Stop forwarding from the given address", []string{"type", "protocol", "listen"},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &RemoveForwardRequest{}
+			res := &RemoveForwardResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := p.removeForwardHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+	_ = a.AddReadOnlyHandler(
+		"getForwards", "Show the current port forwards", []string{},
+		func(in json.RawMessage) (interface{}, error) {
+			req := &GetForwardsRequest{}
+			res := &GetForwardsResponse{}
+			if err := json.Unmarshal(in, &req); err != nil {
+				return nil, err
+			}
+			if err := p.getForwardsHandler(req, res); err != nil {
+				return nil, err
+			}
+			return res, nil
+		},
+	)
+}
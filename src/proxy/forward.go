@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a UDP forward waits for a reply for a client before forgetting
+// about it. The client is remembered again on its next packet.
+const udpIdleTimeout = 2 * time.Minute
+
+// Forward types, like SSH -L and -R respectively.
+const (
+	ForwardLocal  = "local"  // Listen locally, connect into the network
+	ForwardRemote = "remote" // Listen on the node's address, connect locally
+)
+
+type forward struct {
+	Forward
+	closer io.Closer
+	done   chan struct{}
+}
+
+func (f *Forward) normalise() error {
+	f.Type = strings.ToLower(f.Type)
+	f.Protocol = strings.ToLower(f.Protocol)
+	if f.Protocol == "" {
+		f.Protocol = "tcp"
+	}
+	switch {
+	case f.Type != ForwardLocal && f.Type != ForwardRemote:
+		return fmt.Errorf("forward type must be %q or %q", ForwardLocal, ForwardRemote)
+	case f.Protocol != "tcp" && f.Protocol != "udp":
+		return errors.New(`forward protocol must be "tcp" or "udp"`)
+	}
+	if _, _, err := splitHostPort(f.Listen); err != nil {
+		return fmt.Errorf("invalid listen address: %w", err)
+	}
+	host, _, err := splitHostPort(f.Target)
+	if err != nil {
+		return fmt.Errorf("invalid target address: %w", err)
+	}
+	if f.Type == ForwardLocal {
+		if _, err := resolve(host); err != nil {
+			return fmt.Errorf("invalid target address: %w", err)
+		}
+	}
+	return nil
+}
+
+func (f *Forward) id() string {
+	return f.Type + "/" + f.Protocol + "/" + f.Listen
+}
+
+// AddForward starts forwarding connections or packets from the listen
+// address to the target address.
+func (p *Proxy) AddForward(f Forward) error {
+	if err := f.normalise(); err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	select {
+	case <-p.done:
+		return errors.New("proxy is stopped")
+	default:
+	}
+	if _, ok := p.forwards[f.id()]; ok {
+		return errors.New("forward already exists")
+	}
+	fw := &forward{
+		Forward: f,
+		done:    make(chan struct{}),
+	}
+	var err error
+	switch fw.Protocol {
+	case "tcp":
+		var listener net.Listener
+		if fw.Type == ForwardLocal {
+			listener, err = net.Listen("tcp", fw.Listen)
+		} else {
+			listener, err = p.listen("tcp", fw.Listen)
+		}
+		if err != nil {
+			return fmt.Errorf("forward failed to listen: %w", err)
+		}
+		fw.closer = listener
+		p.wg.Add(1)
+		go p.forwardTCP(fw, listener)
+	case "udp":
+		var conn net.PacketConn
+		if fw.Type == ForwardLocal {
+			conn, err = net.ListenPacket("udp", fw.Listen)
+		} else {
+			conn, err = p.listenPacket("udp", fw.Listen)
+		}
+		if err != nil {
+			return fmt.Errorf("forward failed to listen: %w", err)
+		}
+		fw.closer = conn
+		p.wg.Add(1)
+		go p.forwardUDP(fw, conn)
+	}
+	p.forwards[fw.id()] = fw
+	p.log.Infof("Forwarding %s %s from %s to %s", fw.Type, fw.Protocol, fw.Listen, fw.Target)
+	return nil
+}
+
+// RemoveForward stops the forward with the given type, protocol and listen
+// address. Connections that are already being forwarded are left to finish.
+func (p *Proxy) RemoveForward(f Forward) error {
+	f.Type, f.Protocol = strings.ToLower(f.Type), strings.ToLower(f.Protocol)
+	if f.Protocol == "" {
+		f.Protocol = "tcp"
+	}
+	p.mutex.Lock()
+	fw, ok := p.forwards[f.id()]
+	delete(p.forwards, f.id())
+	p.mutex.Unlock()
+	if !ok {
+		return errors.New("forward not found")
+	}
+	close(fw.done)
+	return fw.closer.Close()
+}
+
+// Forwards returns the current forwards.
+func (p *Proxy) Forwards() []Forward {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	forwards := make([]Forward, 0, len(p.forwards))
+	for _, fw := range p.forwards {
+		forwards = append(forwards, fw.Forward)
+	}
+	return forwards
+}
+
+// dialTarget opens a connection to the target of the forward, either into
+// the network or locally.
+func (p *Proxy) dialTarget(fw *forward) (net.Conn, error) {
+	if fw.Type == ForwardRemote {
+		return net.DialTimeout(fw.Protocol, fw.Target, dialTimeout)
+	}
+	host, port, err := splitHostPort(fw.Target)
+	if err != nil {
+		return nil, err
+	}
+	if fw.Protocol == "tcp" {
+		return p.connect(host, port)
+	}
+	addr, err := resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	return p.dial(ctx, "udp", netip.AddrPortFrom(addr, port).String())
+}
+
+func (p *Proxy) forwardTCP(fw *forward, listener net.Listener) {
+	defer p.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-fw.done:
+			case <-p.done:
+			default:
+				p.log.Errorf("Forward from %s stopped: %s", fw.Listen, err)
+			}
+			return
+		}
+		go func() {
+			remote, err := p.dialTarget(fw)
+			if err != nil {
+				p.log.Debugf("Forward from %s to %s failed: %s", fw.Listen, fw.Target, err)
+				_ = conn.Close()
+				return
+			}
+			pipe(conn, remote)
+		}()
+	}
+}
+
+// forwardUDP relays packets between each client and its own connection to
+// the target, so that replies go back to the right client.
+func (p *Proxy) forwardUDP(fw *forward, conn net.PacketConn) {
+	defer p.wg.Done()
+	var mutex sync.Mutex
+	clients := make(map[string]net.Conn)
+	defer func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, c := range clients {
+			_ = c.Close()
+		}
+	}()
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-fw.done:
+			case <-p.done:
+			default:
+				p.log.Errorf("Forward from %s stopped: %s", fw.Listen, err)
+			}
+			return
+		}
+		mutex.Lock()
+		remote, ok := clients[from.String()]
+		if !ok {
+			if remote, err = p.dialTarget(fw); err != nil {
+				mutex.Unlock()
+				p.log.Debugf("Forward from %s to %s failed: %s", fw.Listen, fw.Target, err)
+				continue
+			}
+			clients[from.String()] = remote
+			go func() {
+				defer func() {
+					mutex.Lock()
+					delete(clients, from.String())
+					mutex.Unlock()
+					_ = remote.Close()
+				}()
+				reply := make([]byte, 65535)
+				for {
+					_ = remote.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+					n, err := remote.Read(reply)
+					if err != nil {
+						return
+					}
+					if _, err := conn.WriteTo(reply[:n], from); err != nil {
+						return
+					}
+				}
+			}()
+		}
+		mutex.Unlock()
+		_, _ = remote.Write(buf[:n])
+	}
+}
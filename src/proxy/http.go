@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// serveHTTP handles a single CONNECT request. Other requests are refused,
+// as a plain HTTP proxy would need to parse and forward every request.
+func (p *Proxy) serveHTTP(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	r := bufio.NewReader(conn)
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		p.log.Debugln("HTTP proxy failed to read request:", err)
+		return
+	}
+	if req.Method != http.MethodConnect {
+		httpReply(conn, http.StatusMethodNotAllowed, "Only CONNECT is supported")
+		return
+	}
+	host, port, err := splitHostPort(req.Host)
+	if err != nil {
+		httpReply(conn, http.StatusBadRequest, err.Error())
+		return
+	}
+	remote, err := p.connect(host, port)
+	if err != nil {
+		p.log.Debugf("HTTP proxy connection to %s failed: %s", req.Host, err)
+		status := http.StatusBadGateway
+		if errors.Is(err, errNotOverlay) {
+			status = http.StatusForbidden
+		}
+		httpReply(conn, status, err.Error())
+		return
+	}
+	if _, err := fmt.Fprintf(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		_ = remote.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if n := r.Buffered(); n > 0 {
+		// The client may have sent data straight after the request.
+		buf, _ := r.Peek(n)
+		if _, err := remote.Write(buf); err != nil {
+			_ = remote.Close()
+			return
+		}
+	}
+	pipe(conn, remote)
+}
+
+func httpReply(conn net.Conn, status int, msg string) {
+	_, _ = fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s\n",
+		status, http.StatusText(status), len(msg)+1, msg)
+}
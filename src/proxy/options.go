@@ -0,0 +1,37 @@
+package proxy
+
+func (p *Proxy) _applyOption(opt SetupOption) {
+	switch v := opt.(type) {
+	case SOCKSListenAddress:
+		p.config.socksaddr = v
+	case HTTPListenAddress:
+		p.config.httpaddr = v
+	case Forward:
+		p.config.forwards = append(p.config.forwards, v)
+	}
+}
+
+type SetupOption interface {
+	isSetupOption()
+}
+
+// Local TCP addresses to run the SOCKS5 and HTTP CONNECT proxies on.
+type SOCKSListenAddress string
+type HTTPListenAddress string
+
+func (a SOCKSListenAddress) isSetupOption() {}
+func (a HTTPListenAddress) isSetupOption()  {}
+
+// Forward forwards TCP connections or UDP packets from the listen address to
+// the target address. Local forwards listen on a local address and connect
+// to a target in the network, which may be given as <public key>.ruv. Remote
+// forwards listen on the node's address, e.g. ":80", and connect to a local
+// target.
+type Forward struct {
+	Type     string `json:"type"`     // ForwardLocal or ForwardRemote
+	Protocol string `json:"protocol"` // "tcp" or "udp", "tcp" if empty
+	Listen   string `json:"listen"`
+	Target   string `json:"target"`
+}
+
+func (a Forward) isSetupOption() {}
@@ -0,0 +1,218 @@
+// Package proxy carries connections between local sockets and the network
+// over the userspace network stack, so that applications can reach nodes,
+// and offer services to them, without a TUN interface. It runs SOCKS5 and
+// HTTP CONNECT proxies and TCP and UDP port forwards.
+package proxy
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ruvcoindev/ruvchain/src/address"
+	"github.com/ruvcoindev/ruvchain/src/core"
+	"github.com/ruvcoindev/ruvchain/src/tun"
+)
+
+// NameSuffix is the suffix of names that resolve to the address of the node
+// with the hex-encoded public key before it, e.g. "<key>.ruv".
+const NameSuffix = ".ruv"
+
+// How long to wait for a client to send its request, and for a connection
+// into the network to be established.
+const handshakeTimeout = 10 * time.Second
+const dialTimeout = 30 * time.Second
+
+var errNotOverlay = errors.New("destination is not an address in the network")
+
+type Proxy struct {
+	dial         func(ctx context.Context, network, address string) (net.Conn, error)
+	listen       func(network, address string) (net.Listener, error)
+	listenPacket func(network, address string) (net.PacketConn, error)
+	log          core.Logger
+	listeners    []net.Listener
+	mutex        sync.Mutex // Protects forwards
+	forwards     map[string]*forward
+	wg           sync.WaitGroup
+	done         chan struct{}
+	config       struct {
+		socksaddr SOCKSListenAddress
+		httpaddr  HTTPListenAddress
+		forwards  []Forward
+	}
+}
+
+// New starts the configured proxies and forwards. If the TUN adapter isn't
+// using the userspace network stack, nil is returned, or an error if any
+// proxies or forwards are configured.
+func New(t *tun.TunAdapter, log core.Logger, opts ...SetupOption) (*Proxy, error) {
+	p := &Proxy{
+		log:      log,
+		forwards: make(map[string]*forward),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		p._applyOption(opt)
+	}
+	if t == nil || !t.IsNetstack() {
+		if enabled(string(p.config.socksaddr)) || enabled(string(p.config.httpaddr)) || len(p.config.forwards) > 0 {
+			return nil, fmt.Errorf("proxies and forwards require IfName to be %q", tun.NetstackName)
+		}
+		return nil, nil
+	}
+	p.dial, p.listen, p.listenPacket = t.DialContext, t.Listen, t.ListenPacket
+	return p, p.start()
+}
+
+func enabled(listenaddr string) bool {
+	return listenaddr != "" && listenaddr != "none"
+}
+
+func (p *Proxy) start() error {
+	for _, l := range []struct {
+		name       string
+		listenaddr string
+		serve      func(net.Conn)
+	}{
+		{"SOCKS5", string(p.config.socksaddr), p.serveSOCKS},
+		{"HTTP", string(p.config.httpaddr), p.serveHTTP},
+	} {
+		if !enabled(l.listenaddr) {
+			continue
+		}
+		listener, err := net.Listen("tcp", l.listenaddr)
+		if err != nil {
+			_ = p.Stop()
+			return fmt.Errorf("%s proxy failed to listen: %w", l.name, err)
+		}
+		p.log.Infof("%s proxy listening on %s", l.name, listener.Addr())
+		p.listeners = append(p.listeners, listener)
+		p.wg.Add(1)
+		go p.accept(listener, l.serve)
+	}
+	for _, f := range p.config.forwards {
+		if err := p.AddForward(f); err != nil {
+			_ = p.Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) accept(listener net.Listener, serve func(net.Conn)) {
+	defer p.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-p.done:
+			default:
+				p.log.Errorln("Proxy listener stopped:", err)
+			}
+			return
+		}
+		go serve(conn)
+	}
+}
+
+// Stop will stop the proxy listeners and forwards. Connections that are
+// already being proxied or forwarded are left to finish.
+func (p *Proxy) Stop() error {
+	if p == nil {
+		return nil
+	}
+	select {
+	case <-p.done:
+		return nil
+	default:
+		close(p.done)
+	}
+	var err error
+	for _, listener := range p.listeners {
+		err = errors.Join(err, listener.Close())
+	}
+	p.mutex.Lock()
+	for id, fw := range p.forwards {
+		err = errors.Join(err, fw.closer.Close())
+		delete(p.forwards, id)
+	}
+	p.mutex.Unlock()
+	p.wg.Wait()
+	return err
+}
+
+// resolve returns the address in the network for the host, which is either
+// an IPv6 address or a name made from a public key.
+func resolve(host string) (netip.Addr, error) {
+	host = strings.TrimSuffix(host, ".")
+	if len(host) > len(NameSuffix) && strings.EqualFold(host[len(host)-len(NameSuffix):], NameSuffix) {
+		key, err := hex.DecodeString(host[:len(host)-len(NameSuffix)])
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return netip.Addr{}, fmt.Errorf("%q is not a valid public key name", host)
+		}
+		return netip.AddrFrom16(*address.AddrForKey(key)), nil
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	if err != nil || !addr.Is6() {
+		return netip.Addr{}, errNotOverlay
+	}
+	a, s := address.Address(addr.As16()), address.Subnet{}
+	copy(s[:], a[:])
+	if !a.IsValid() && !s.IsValid() {
+		return netip.Addr{}, errNotOverlay
+	}
+	return addr, nil
+}
+
+// connect resolves the host and opens a TCP connection to it.
+func (p *Proxy) connect(host string, port uint16) (net.Conn, error) {
+	addr, err := resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	return p.dial(ctx, "tcp", netip.AddrPortFrom(addr, port).String())
+}
+
+// splitHostPort is like net.SplitHostPort, but also parses the port.
+func splitHostPort(hostport string) (string, uint16, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q", port)
+	}
+	return host, uint16(p), nil
+}
+
+// pipe copies between the connections until both directions are done.
+func pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	cp := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+	}
+	wg.Add(2)
+	go cp(a, b)
+	go cp(b, a)
+	wg.Wait()
+	_ = a.Close()
+	_ = b.Close()
+}
@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/gologme/log"
+
+	"github.com/ruvcoindev/ruvchain/src/address"
+)
+
+func TestResolve(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := netip.AddrFrom16(*address.AddrForKey(pub))
+	subnet := address.SubnetForKey(pub)
+	var s [16]byte
+	copy(s[:], subnet[:])
+	s[15] = 1
+	for host, expected := range map[string]netip.Addr{
+		hex.EncodeToString(pub) + ".ruv":                   addr,
+		strings.ToUpper(hex.EncodeToString(pub)) + ".RUV.": addr,
+		addr.String():                addr,
+		"[" + addr.String() + "]":    addr,
+		netip.AddrFrom16(s).String(): netip.AddrFrom16(s),
+	} {
+		if got, err := resolve(host); err != nil || got != expected {
+			t.Fatalf("resolve(%q) = %s, %v, expected %s", host, got, err, expected)
+		}
+	}
+	for _, host := range []string{
+		"example.com", "abcd.ruv", "127.0.0.1", "::1", "2001:db8::1",
+	} {
+		if _, err := resolve(host); err == nil {
+			t.Fatalf("resolve(%q) should have failed", host)
+		}
+	}
+}
+
+// newTestProxy runs both proxies with connections going to echo servers on
+// the loopback address instead of into the network. Listening on the node's
+// address is also replaced by listening on the loopback address.
+func newTestProxy(t *testing.T) (*Proxy, chan string) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	echoUDP, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { echoUDP.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := echoUDP.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echoUDP.WriteTo(buf[:n], from)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	dialed := make(chan string, 1)
+	p := &Proxy{
+		log:      log.New(io.Discard, "", 0),
+		forwards: make(map[string]*forward),
+		done:     make(chan struct{}),
+		dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed <- address
+			var d net.Dialer
+			if network == "udp" {
+				return d.DialContext(ctx, network, echoUDP.LocalAddr().String())
+			}
+			return d.DialContext(ctx, network, echo.Addr().String())
+		},
+		listen:       net.Listen,
+		listenPacket: net.ListenPacket,
+	}
+	p.config.socksaddr = "127.0.0.1:0"
+	p.config.httpaddr = "127.0.0.1:0"
+	p.config.forwards = []Forward{{
+		Type:   ForwardRemote,
+		Listen: "127.0.0.1:0",
+		Target: echo.Addr().String(),
+	}}
+	if err := p.start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Stop() })
+	return p, dialed
+}
+
+func expectEcho(t *testing.T, conn net.Conn, r io.Reader) {
+	t.Helper()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("unexpected echo %q", buf)
+	}
+}
+
+func TestSOCKS(t *testing.T) {
+	p, dialed := newTestProxy(t)
+	pub, _, _ := ed25519.GenerateKey(nil)
+	name := hex.EncodeToString(pub) + NameSuffix
+
+	conn, err := net.Dial("tcp", p.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := []byte{socksVersion, 1, socksMethodNone}
+	req = append(req, socksVersion, socksCmdConnect, 0, socksAtypDomain, byte(len(name)))
+	req = append(req, name...)
+	req = append(req, 0, 80)
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	res := make([]byte, 2+10)
+	if _, err := io.ReadFull(conn, res); err != nil {
+		t.Fatal(err)
+	}
+	if res[1] != socksMethodNone || res[3] != socksSucceeded {
+		t.Fatalf("unexpected response %v", res)
+	}
+	expected := netip.AddrPortFrom(netip.AddrFrom16(*address.AddrForKey(pub)), 80).String()
+	if got := <-dialed; got != expected {
+		t.Fatalf("dialed %s, expected %s", got, expected)
+	}
+	expectEcho(t, conn, conn)
+
+	// Destinations outside of the network must be refused.
+	conn, err = net.Dial("tcp", p.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req = []byte{socksVersion, 1, socksMethodNone}
+	req = append(req, socksVersion, socksCmdConnect, 0, socksAtypIPv6)
+	req = append(req, net.ParseIP("2001:db8::1")...)
+	req = append(req, 0, 80)
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, res); err != nil {
+		t.Fatal(err)
+	}
+	if res[3] != socksAddressNotSupported {
+		t.Fatalf("unexpected response %v", res)
+	}
+}
+
+func TestHTTPConnect(t *testing.T) {
+	p, dialed := newTestProxy(t)
+	pub, _, _ := ed25519.GenerateKey(nil)
+	addr := netip.AddrFrom16(*address.AddrForKey(pub))
+	target := netip.AddrPortFrom(addr, 443).String()
+
+	conn, err := net.Dial("tcp", p.listeners[1].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %s", res.Status)
+	}
+	if got := <-dialed; got != target {
+		t.Fatalf("dialed %s, expected %s", got, target)
+	}
+	expectEcho(t, conn, r)
+
+	conn, err = net.Dial("tcp", p.listeners[1].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = http.ReadResponse(bufio.NewReader(conn), nil); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status %s", res.Status)
+	}
+}
+
+func TestForwards(t *testing.T) {
+	p, dialed := newTestProxy(t)
+	pub, _, _ := ed25519.GenerateKey(nil)
+	name := hex.EncodeToString(pub) + NameSuffix
+	expected := netip.AddrPortFrom(netip.AddrFrom16(*address.AddrForKey(pub)), 80).String()
+
+	for _, f := range []Forward{
+		{Type: ForwardLocal, Listen: "127.0.0.1:0", Target: name + ":80"},
+		{Type: "LOCAL", Protocol: "udp", Listen: "127.0.0.1:0", Target: name + ":80"},
+	} {
+		if err := p.AddForward(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.AddForward(Forward{Type: ForwardLocal, Listen: "127.0.0.1:0", Target: "[2001:db8::1]:80"}); err == nil {
+		t.Fatal("forward to outside of the network should have failed")
+	}
+	if err := p.AddForward(Forward{Type: ForwardLocal, Listen: "127.0.0.1:0", Target: name + ":80"}); err == nil {
+		t.Fatal("duplicate forward should have failed")
+	}
+	if forwards := p.Forwards(); len(forwards) != 3 {
+		t.Fatalf("expected 3 forwards, got %v", forwards)
+	}
+
+	addr := func(typ, protocol string) string {
+		fw := p.forwards[typ+"/"+protocol+"/127.0.0.1:0"]
+		switch c := fw.closer.(type) {
+		case net.Listener:
+			return c.Addr().String()
+		case net.PacketConn:
+			return c.LocalAddr().String()
+		}
+		return ""
+	}
+	for _, f := range []struct {
+		typ, protocol string
+	}{
+		{ForwardLocal, "tcp"},
+		{ForwardLocal, "udp"},
+		{ForwardRemote, "tcp"},
+	} {
+		conn, err := net.Dial(f.protocol, addr(f.typ, f.protocol))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		expectEcho(t, conn, conn)
+		if f.typ == ForwardLocal {
+			if got := <-dialed; got != expected {
+				t.Fatalf("dialed %s, expected %s", got, expected)
+			}
+		}
+	}
+
+	listenaddr := addr(ForwardLocal, "tcp")
+	if err := p.RemoveForward(Forward{Type: ForwardLocal, Listen: "127.0.0.1:0"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.Dial("tcp", listenaddr); err == nil {
+		t.Fatal("forward should have stopped listening")
+	}
+	if err := p.RemoveForward(Forward{Type: ForwardLocal, Listen: "127.0.0.1:0"}); err == nil {
+		t.Fatal("removing a forward twice should have failed")
+	}
+}
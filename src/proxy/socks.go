@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// SOCKS5 as per RFC 1928, without authentication and only for CONNECT.
+
+const (
+	socksVersion       = 0x05
+	socksMethodNone    = 0x00
+	socksMethodNoMatch = 0xff
+	socksCmdConnect    = 0x01
+	socksAtypIPv4      = 0x01
+	socksAtypDomain    = 0x03
+	socksAtypIPv6      = 0x04
+)
+
+// SOCKS5 reply codes.
+const (
+	socksSucceeded           = 0x00
+	socksHostUnreachable     = 0x04
+	socksCommandNotSupported = 0x07
+	socksAddressNotSupported = 0x08
+)
+
+type socksError struct {
+	reply byte
+	err   error
+}
+
+func (e *socksError) Error() string {
+	return e.err.Error()
+}
+
+func (p *Proxy) serveSOCKS(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	r := bufio.NewReader(conn)
+	host, port, err := socksHandshake(r, conn)
+	if err != nil {
+		p.log.Debugln("SOCKS5 handshake failed:", err)
+		var serr *socksError
+		if errors.As(err, &serr) {
+			_ = socksReply(conn, serr.reply)
+		}
+		return
+	}
+	remote, err := p.connect(host, port)
+	if err != nil {
+		p.log.Debugf("SOCKS5 connection to %s failed: %s", net.JoinHostPort(host, fmt.Sprint(port)), err)
+		reply := byte(socksHostUnreachable)
+		if errors.Is(err, errNotOverlay) {
+			reply = socksAddressNotSupported
+		}
+		_ = socksReply(conn, reply)
+		return
+	}
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		_ = remote.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if n := r.Buffered(); n > 0 {
+		// The client may have sent data straight after the request.
+		buf, _ := r.Peek(n)
+		if _, err := remote.Write(buf); err != nil {
+			_ = remote.Close()
+			return
+		}
+	}
+	pipe(conn, remote)
+}
+
+// socksHandshake negotiates the method and reads the CONNECT request,
+// returning the requested destination.
+func socksHandshake(r *bufio.Reader, w io.Writer) (string, uint16, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return "", 0, err
+	}
+	if hdr[0] != socksVersion {
+		return "", 0, fmt.Errorf("unsupported SOCKS version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return "", 0, err
+	}
+	method := byte(socksMethodNoMatch)
+	for _, m := range methods {
+		if m == socksMethodNone {
+			method = socksMethodNone
+		}
+	}
+	if _, err := w.Write([]byte{socksVersion, method}); err != nil {
+		return "", 0, err
+	}
+	if method == socksMethodNoMatch {
+		return "", 0, errors.New("client requires authentication")
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(r, req[:]); err != nil {
+		return "", 0, err
+	}
+	if req[0] != socksVersion {
+		return "", 0, fmt.Errorf("unsupported SOCKS version %d", req[0])
+	}
+	var host string
+	switch req[3] {
+	case socksAtypIPv6:
+		ip := make(net.IP, net.IPv6len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", 0, err
+		}
+		host = ip.String()
+	case socksAtypDomain:
+		l, err := r.ReadByte()
+		if err != nil {
+			return "", 0, err
+		}
+		name := make([]byte, l)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", 0, err
+		}
+		host = string(name)
+	case socksAtypIPv4:
+		return "", 0, &socksError{socksAddressNotSupported, errors.New("IPv4 destinations are not supported")}
+	default:
+		return "", 0, &socksError{socksAddressNotSupported, fmt.Errorf("unknown address type %d", req[3])}
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", 0, err
+	}
+	if req[1] != socksCmdConnect {
+		return "", 0, &socksError{socksCommandNotSupported, fmt.Errorf("unsupported command %d", req[1])}
+	}
+	return host, binary.BigEndian.Uint16(port[:]), nil
+}
+
+// socksReply sends a reply with an empty bound address, as clients don't
+// need to know the local address of the connection into the network.
+func socksReply(w io.Writer, reply byte) error {
+	_, err := w.Write([]byte{socksVersion, reply, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
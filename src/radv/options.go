@@ -0,0 +1,29 @@
+package radv
+
+func (r *RouterAdvertiser) _applyOption(opt SetupOption) {
+	switch v := opt.(type) {
+	case InterfaceName:
+		r.config.ifname = v
+	case DefaultRouter:
+		r.config.router = v
+	}
+}
+
+type SetupOption interface {
+	isSetupOption()
+}
+
+// InterfaceName is the LAN interface to advertise the subnet on.
+type InterfaceName string
+
+func (a InterfaceName) isSetupOption() {}
+
+// DefaultRouter also advertises the node as a default router, so that hosts
+// which ignore the route to the network, such as Linux hosts with the
+// default accept_ra_rt_info_max_plen of 0, still send traffic for it to the
+// node. Hosts then send all IPv6 traffic without a more specific route to
+// the node, which only forwards it into the network, so this should only be
+// used on a LAN that has no other IPv6 router.
+type DefaultRouter bool
+
+func (a DefaultRouter) isSetupOption() {}
@@ -0,0 +1,268 @@
+// Package radv lets a node act as the gateway between the network and a LAN.
+// The node's routed /64 subnet is assigned to the LAN interface and
+// advertised on it with ICMPv6 router advertisements, so that hosts on the
+// LAN configure addresses in it with SLAAC, along with a route to the rest
+// of the network. By default the node itself is not advertised as a default
+// router, so hosts only learn the route from a Route Information Option,
+// which Linux ignores unless the accept_ra_rt_info_max_plen sysctl of the
+// interface is at least 7. Hosts that can't be configured like that can be
+// served with the DefaultRouter option instead.
+package radv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+
+	"github.com/ruvcoindev/ruvchain/src/address"
+	"github.com/ruvcoindev/ruvchain/src/core"
+	"github.com/ruvcoindev/ruvchain/src/tun"
+)
+
+// Advertisement timing, following the defaults of RFC 4861.
+const (
+	initialInterval       = 16 * time.Second
+	initialAdvertisements = 3
+	minInterval           = 200 * time.Second
+	maxInterval           = 600 * time.Second
+	minDelay              = 3 * time.Second // Between advertisements
+)
+
+// Lifetimes of the advertised prefix and route. They are kept short, as the
+// subnet is only reachable while the node is running.
+const (
+	validLifetime     = time.Hour
+	preferredLifetime = 30 * time.Minute
+	routeLifetime     = 3 * maxInterval
+)
+
+// The all-nodes and all-routers link-local multicast groups.
+var (
+	allNodes   = net.ParseIP("ff02::1")
+	allRouters = net.ParseIP("ff02::2")
+)
+
+type RouterAdvertiser struct {
+	log       core.Logger
+	iface     *net.Interface
+	subnet    net.IPNet // The /64 subnet of the node
+	network   net.IPNet // The prefix of the whole network
+	conn      *ipv6.PacketConn
+	solicited chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+	restore   func() // Undoes the changes made by setupInterface
+	config    struct {
+		ifname InterfaceName
+		router DefaultRouter
+	}
+}
+
+// New assigns the subnet of the node to the LAN interface and starts sending
+// router advertisements on it. This needs a TUN adapter, through which
+// packets are routed between the LAN and the network. If no interface is
+// configured, nil is returned and nothing is advertised.
+func New(c *core.Core, t *tun.TunAdapter, log core.Logger, opts ...SetupOption) (*RouterAdvertiser, error) {
+	r := &RouterAdvertiser{
+		log:       log,
+		subnet:    c.Subnet(),
+		solicited: make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		r._applyOption(opt)
+	}
+	if r.config.ifname == "none" || r.config.ifname == "" {
+		return nil, nil
+	}
+	if t == nil || !t.IsStarted() || t.IsNetstack() {
+		return nil, errors.New("advertising the subnet requires a TUN adapter")
+	}
+	prefix := address.GetPrefix()
+	r.network = net.IPNet{
+		IP:   append(prefix[:], make(net.IP, net.IPv6len-len(prefix))...),
+		Mask: net.CIDRMask(8*len(prefix)-1, 8*net.IPv6len),
+	}
+	var err error
+	if r.iface, err = net.InterfaceByName(string(r.config.ifname)); err != nil {
+		return nil, fmt.Errorf("failed to find interface %q: %w", r.config.ifname, err)
+	}
+	if err = r.setupInterface(); err != nil {
+		return nil, err
+	}
+	if err = r.listen(); err != nil {
+		r.restore()
+		return nil, err
+	}
+	r.wg.Add(2)
+	go r.read()
+	go r.advertise()
+	r.log.Infof("Advertising subnet %s on interface %s", r.subnet.String(), r.iface.Name)
+	return r, nil
+}
+
+func (r *RouterAdvertiser) listen() error {
+	pc, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return fmt.Errorf("failed to open ICMPv6 socket: %w", err)
+	}
+	conn := pc.IPv6PacketConn()
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeRouterSolicitation)
+	for _, err := range []error{
+		conn.SetICMPFilter(&filter),
+		conn.SetMulticastInterface(r.iface),
+		conn.SetMulticastHopLimit(255),
+		conn.SetHopLimit(255),
+		conn.SetMulticastLoopback(false),
+		conn.JoinGroup(r.iface, &net.IPAddr{IP: allRouters}),
+		conn.SetControlMessage(ipv6.FlagInterface|ipv6.FlagHopLimit, true),
+	} {
+		if err != nil {
+			_ = conn.Close()
+			return fmt.Errorf("failed to set up ICMPv6 socket: %w", err)
+		}
+	}
+	r.conn = conn
+	return nil
+}
+
+// read waits for router solicitations on the interface. Solicitations are
+// answered by the next advertisement, which is sent to all nodes.
+func (r *RouterAdvertiser) read() {
+	defer r.wg.Done()
+	bs := make([]byte, 1500)
+	for {
+		n, cm, _, err := r.conn.ReadFrom(bs)
+		if err != nil {
+			select {
+			case <-r.done:
+			default:
+				r.log.Errorf("Failed to read router solicitation: %v", err)
+			}
+			return
+		}
+		// Solicitations from other links or that have been forwarded by a
+		// router must be ignored.
+		if cm == nil || cm.IfIndex != r.iface.Index || cm.HopLimit != 255 {
+			continue
+		}
+		if n < 8 || bs[0] != byte(ipv6.ICMPTypeRouterSolicitation) || bs[1] != 0 {
+			continue
+		}
+		select {
+		case r.solicited <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *RouterAdvertiser) advertise() {
+	defer r.wg.Done()
+	var last time.Time
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for sent := 0; ; {
+		select {
+		case <-r.done:
+			return
+		case <-r.solicited:
+			if time.Since(last) < minDelay {
+				continue
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-timer.C:
+		}
+		if err := r.send(false); err != nil {
+			r.log.Errorf("Failed to send router advertisement: %v", err)
+		}
+		last, sent = time.Now(), sent+1
+		interval := initialInterval
+		if sent >= initialAdvertisements {
+			interval = minInterval + time.Duration(rand.Int63n(int64(maxInterval-minInterval)))
+		}
+		timer.Reset(interval)
+	}
+}
+
+func (r *RouterAdvertiser) send(final bool) error {
+	msg := icmp.Message{
+		Type: ipv6.ICMPTypeRouterAdvertisement,
+		Body: &icmp.RawBody{
+			Data: advertisement(r.subnet, r.network, r.iface.HardwareAddr, bool(r.config.router), final),
+		},
+	}
+	// The checksum is left to the kernel, which knows the source address.
+	bs, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	_, err = r.conn.WriteTo(bs, &ipv6.ControlMessage{IfIndex: r.iface.Index}, &net.IPAddr{IP: allNodes})
+	return err
+}
+
+// advertisement returns the body of a router advertisement, i.e. everything
+// after the checksum. It has options for the link-layer address of the
+// interface, the subnet, which hosts configure addresses in, and a route to
+// the network. If router is set, the node is also advertised as a default
+// router. The final advertisement withdraws the subnet and routes.
+func advertisement(subnet, network net.IPNet, mac net.HardwareAddr, router, final bool) []byte {
+	valid, preferred, route := validLifetime, preferredLifetime, routeLifetime
+	if final {
+		valid, preferred, route = 0, 0, 0
+	}
+	bs := make([]byte, 12)
+	bs[0] = 64 // Current hop limit
+	if router {
+		binary.BigEndian.PutUint16(bs[2:4], uint16(route/time.Second))
+	}
+	if len(mac) == 6 {
+		bs = append(bs, 1, 1) // Source link-layer address
+		bs = append(bs, mac...)
+	}
+	ones, _ := subnet.Mask.Size()
+	bs = append(bs, 3, 4, byte(ones), 0xc0) // Prefix information, on-link and autonomous
+	bs = binary.BigEndian.AppendUint32(bs, uint32(valid/time.Second))
+	bs = binary.BigEndian.AppendUint32(bs, uint32(preferred/time.Second))
+	bs = append(bs, 0, 0, 0, 0)
+	bs = append(bs, subnet.IP.To16()...)
+	ones, _ = network.Mask.Size()
+	bs = append(bs, 24, 2, byte(ones), 0) // Route information, medium preference
+	bs = binary.BigEndian.AppendUint32(bs, uint32(route/time.Second))
+	bs = append(bs, network.IP.To16()[:8]...)
+	return bs
+}
+
+// Stop withdraws the subnet from the hosts on the LAN and undoes the changes
+// made to the interface.
+func (r *RouterAdvertiser) Stop() error {
+	if r == nil {
+		return nil
+	}
+	select {
+	case <-r.done:
+		return nil
+	default:
+		close(r.done)
+	}
+	if err := r.send(true); err != nil {
+		r.log.Warnf("Failed to send final router advertisement: %v", err)
+	}
+	err := r.conn.Close()
+	r.wg.Wait()
+	r.restore()
+	return err
+}
@@ -0,0 +1,63 @@
+//go:build linux || android
+// +build linux android
+
+package radv
+
+// The linux platform specific router advertisement parts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+const forwardingSysctl = "/proc/sys/net/ipv6/conf/all/forwarding"
+
+// Assigns the first address of the subnet to the LAN interface, which also
+// adds the route to the subnet through it, and enables IPv6 forwarding.
+// Both are undone by restore, unless they were already in place.
+func (r *RouterAdvertiser) setupInterface() error {
+	nlintf, err := netlink.LinkByName(r.iface.Name)
+	if err != nil {
+		return fmt.Errorf("failed to find link by name: %w", err)
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, r.subnet.IP)
+	ip[net.IPv6len-1] = 1
+	nladdr := &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: r.subnet.Mask}}
+	added := true
+	if err := netlink.AddrAdd(nlintf, nladdr); errors.Is(err, syscall.EEXIST) {
+		added = false
+	} else if err != nil {
+		return fmt.Errorf("failed to add address to link: %w", err)
+	}
+	forwarding, err := os.ReadFile(forwardingSysctl)
+	if err == nil && !bytes.Equal(bytes.TrimSpace(forwarding), []byte("1")) {
+		err = os.WriteFile(forwardingSysctl, []byte("1"), 0644)
+	}
+	if err != nil {
+		if added {
+			_ = netlink.AddrDel(nlintf, nladdr)
+		}
+		return fmt.Errorf("failed to enable IPv6 forwarding: %w", err)
+	}
+	r.restore = func() {
+		if added {
+			if err := netlink.AddrDel(nlintf, nladdr); err != nil {
+				r.log.Warnf("Failed to remove address from link: %v", err)
+			}
+		}
+		if !bytes.Equal(bytes.TrimSpace(forwarding), []byte("1")) {
+			if err := os.WriteFile(forwardingSysctl, forwarding, 0644); err != nil {
+				r.log.Warnf("Failed to restore IPv6 forwarding: %v", err)
+			}
+		}
+	}
+	r.log.Infof("Interface %s IPv6: %s", r.iface.Name, nladdr.IPNet.String())
+	return nil
+}
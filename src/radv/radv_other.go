@@ -0,0 +1,11 @@
+//go:build !linux && !android
+// +build !linux,!android
+
+package radv
+
+// The address and forwarding aren't set up automatically on this platform.
+func (r *RouterAdvertiser) setupInterface() error {
+	r.log.Warnf("Address in %s must be added to interface %s and IPv6 forwarding enabled by hand", r.subnet.String(), r.iface.Name)
+	r.restore = func() {}
+	return nil
+}
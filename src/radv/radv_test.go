@@ -0,0 +1,77 @@
+package radv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+func TestAdvertisement(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("fb12:3456:789a:bcde::/64")
+	_, network, _ := net.ParseCIDR("fa00::/7")
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+
+	for _, tt := range []struct{ router, final bool }{
+		{false, false}, {false, true}, {true, false}, {true, true},
+	} {
+		router, final := tt.router, tt.final
+		bs, err := (&icmp.Message{
+			Type: ipv6.ICMPTypeRouterAdvertisement,
+			Body: &icmp.RawBody{Data: advertisement(*subnet, *network, mac, router, final)},
+		}).Marshal(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bs[0] != byte(ipv6.ICMPTypeRouterAdvertisement) || bs[4] != 64 {
+			t.Fatalf("unexpected header %x", bs[:16])
+		}
+		// Only advertised as a default router if asked to, and never in the
+		// final advertisement.
+		expected := uint16(0)
+		if router && !final {
+			expected = 1800
+		}
+		if lifetime := binary.BigEndian.Uint16(bs[6:]); lifetime != expected {
+			t.Fatalf("router lifetime is %d, expected %d", lifetime, expected)
+		}
+		var prefix, route []byte
+		for opts := bs[16:]; len(opts) > 0; {
+			if len(opts) < 8 || opts[1] == 0 || len(opts) < int(opts[1])*8 {
+				t.Fatalf("malformed options %x", opts)
+			}
+			opt := opts[:int(opts[1])*8]
+			switch opt[0] {
+			case 1:
+				if !bytes.Equal(opt[2:], mac) {
+					t.Fatalf("unexpected link-layer address %x", opt[2:])
+				}
+			case 3:
+				prefix = opt
+			case 24:
+				route = opt
+			default:
+				t.Fatalf("unexpected option %d", opt[0])
+			}
+			opts = opts[len(opt):]
+		}
+		if len(prefix) != 32 || prefix[2] != 64 || prefix[3] != 0xc0 || !net.IP(prefix[16:]).Equal(subnet.IP) {
+			t.Fatalf("unexpected prefix information %x", prefix)
+		}
+		if len(route) != 16 || route[2] != 7 || route[8] != 0xfa {
+			t.Fatalf("unexpected route information %x", route)
+		}
+		valid := binary.BigEndian.Uint32(prefix[4:])
+		preferred := binary.BigEndian.Uint32(prefix[8:])
+		lifetime := binary.BigEndian.Uint32(route[4:])
+		switch {
+		case final && (valid != 0 || preferred != 0 || lifetime != 0):
+			t.Fatalf("final advertisement has lifetimes %d, %d and %d", valid, preferred, lifetime)
+		case !final && (valid != 3600 || preferred != 1800 || lifetime != 1800):
+			t.Fatalf("advertisement has lifetimes %d, %d and %d", valid, preferred, lifetime)
+		}
+	}
+}
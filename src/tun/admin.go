@@ -28,7 +28,7 @@ func (t *TunAdapter) getTUNHandler(req *GetTUNRequest, res *GetTUNResponse) erro
 }
 
 func (t *TunAdapter) SetupAdminHandlers(a *admin.AdminSocket) {
-	_ = a.AddHandler(
+	_ = a.AddReadOnlyHandler(
 		"getTun", "Show information about the node's TUN interface", []string{},
 		func(in json.RawMessage) (interface{}, error) {
 			req := &GetTUNRequest{}
@@ -1,6 +1,7 @@
 package tun
 
 import (
+	"encoding/binary"
 	"errors"
 
 	wgtun "golang.zx2c4.com/wireguard/tun"
@@ -8,15 +9,18 @@ import (
 
 const TUN_OFFSET_BYTES = 80 // sizeof(virtio_net_hdr)
 
-func (tun *TunAdapter) read() {
-	vs := tun.iface.BatchSize()
+// read sends the packets from one queue of the TUN interface into the
+// network. With offloads enabled, large TCP and UDP segments are read in one
+// go and are split into packets by the interface.
+func (tun *TunAdapter) read(iface wgtun.Device) {
+	vs := iface.BatchSize()
 	bufs := make([][]byte, vs)
 	sizes := make([]int, vs)
 	for i := range bufs {
 		bufs[i] = make([]byte, TUN_OFFSET_BYTES+65535)
 	}
 	for {
-		n, err := tun.iface.Read(bufs, sizes, TUN_OFFSET_BYTES)
+		n, err := iface.Read(bufs, sizes, TUN_OFFSET_BYTES)
 		if err != nil {
 			if errors.Is(err, wgtun.ErrTooManySegments) {
 				tun.log.Debugln("TUN segments dropped: %v", err)
@@ -26,6 +30,8 @@ func (tun *TunAdapter) read() {
 			return
 		}
 		for i, b := range bufs[:n] {
+			tun.stats.readPackets.Add(1)
+			tun.stats.readBytes.Add(uint64(sizes[i]))
 			if _, err := tun.rwc.Write(b[TUN_OFFSET_BYTES : TUN_OFFSET_BYTES+sizes[i]]); err != nil {
 				tun.log.Debugln("Unable to send packet:", err)
 			}
@@ -33,43 +39,124 @@ func (tun *TunAdapter) read() {
 	}
 }
 
+// queue reads packets from the network, leaving room for the virtio-net
+// header in front of them so that they don't need to be copied again, and
+// passes each to the writer of the queue that its flow is assigned to.
 func (tun *TunAdapter) queue() {
 	for {
 		p := bufPool.Get().([]byte)[:bufPoolSize]
-		n, err := tun.rwc.Read(p)
+		n, err := tun.rwc.Read(p[TUN_OFFSET_BYTES:])
 		if err != nil {
 			tun.log.Errorln("Exiting TUN writer due to core read error:", err)
 			return
 		}
-		tun.ch <- p[:n]
+		p = p[:TUN_OFFSET_BYTES+n]
+		ch := tun.chs[0]
+		if len(tun.chs) > 1 {
+			ch = tun.chs[flowHash(p[TUN_OFFSET_BYTES:])%uint32(len(tun.chs))]
+		}
+		ch <- p
 	}
 }
 
-func (tun *TunAdapter) write() {
-	vs := cap(tun.ch)
-	bufs := make([][]byte, vs)
-	for i := range bufs {
-		bufs[i] = make([]byte, TUN_OFFSET_BYTES+65535)
+// localWriter is implemented by a ReadWriteCloser that sends packets of its
+// own to the TUN interface, apart from those read from the network.
+type localWriter interface {
+	SetLocalWriter(func([]byte))
+}
+
+// writeLocal queues a copy of a packet from the ReadWriteCloser to be written
+// to the TUN interface. It is dropped if the queue is full.
+func (tun *TunAdapter) writeLocal(bs []byte) {
+	if len(bs) > bufPoolSize-TUN_OFFSET_BYTES {
+		return
 	}
+	p := bufPool.Get().([]byte)[:bufPoolSize]
+	p = p[:TUN_OFFSET_BYTES+copy(p[TUN_OFFSET_BYTES:], bs)]
+	ch := tun.chs[0]
+	if len(tun.chs) > 1 {
+		ch = tun.chs[flowHash(bs)%uint32(len(tun.chs))]
+	}
+	select {
+	case ch <- p:
+	default:
+		tun.release([][]byte{p})
+	}
+}
+
+// write writes the packets for one queue of the TUN interface in batches.
+// With offloads enabled, the packets of a TCP or UDP flow are coalesced by
+// the interface into larger segments.
+func (tun *TunAdapter) write(iface wgtun.Device, ch chan []byte) {
+	vs := cap(ch)
+	msgs := make([][]byte, vs)
+	bufs := make([][]byte, vs)
 	for {
-		n := len(tun.ch)
+		n := len(ch)
 		if n == 0 {
 			n = 1 // Nothing queued up yet, wait for it instead
 		}
+		var size uint64
 		for i := 0; i < n; i++ {
-			msg := <-tun.ch
-			bufs[i] = append(bufs[i][:TUN_OFFSET_BYTES], msg...)
-			bufPool.Put(msg) // nolint:staticcheck
+			msgs[i] = <-ch
+			bufs[i] = msgs[i]
+			size += uint64(len(msgs[i]) - TUN_OFFSET_BYTES)
 		}
 		if !tun.isEnabled {
+			tun.release(msgs[:n])
 			continue // Nothing to do, the tun isn't enabled
 		}
-		if _, err := tun.iface.Write(bufs[:n], TUN_OFFSET_BYTES); err != nil {
+		_, err := iface.Write(bufs[:n], TUN_OFFSET_BYTES)
+		tun.release(msgs[:n])
+		if err != nil {
 			tun.Act(nil, func() {
 				if !tun.isOpen {
 					tun.log.Errorln("TUN iface write error:", err)
 				}
 			})
+			continue
+		}
+		tun.stats.writtenPackets.Add(uint64(n))
+		tun.stats.writtenBytes.Add(size)
+	}
+}
+
+func (tun *TunAdapter) release(msgs [][]byte) {
+	for i, msg := range msgs {
+		bufPool.Put(msg) // nolint:staticcheck
+		msgs[i] = nil
+	}
+}
+
+// flowHash returns the same value for all packets of a TCP or UDP flow, or
+// of other traffic between the same pair of addresses, so that they are
+// written to the same queue and don't get reordered.
+func flowHash(bs []byte) uint32 {
+	var addrs, ports []byte
+	var proto byte
+	switch {
+	case len(bs) >= 20 && bs[0]&0xf0 == 0x40:
+		addrs, proto = bs[12:20], bs[9]
+		if off := int(bs[0]&0x0f) * 4; len(bs) >= off+4 && binary.BigEndian.Uint16(bs[6:])&0x1fff == 0 {
+			ports = bs[off : off+4]
+		}
+	case len(bs) >= 40:
+		addrs, proto = bs[8:40], bs[6]
+		if len(bs) >= 44 {
+			ports = bs[40:44]
+		}
+	default:
+		return 0
+	}
+	if proto != 6 && proto != 17 {
+		ports = nil // Not TCP or UDP
+	}
+	h := uint32(2166136261) // FNV-1a
+	for _, part := range [][]byte{addrs, {proto}, ports} {
+		for _, b := range part {
+			h ^= uint32(b)
+			h *= 16777619
 		}
 	}
+	return h
 }
@@ -0,0 +1,112 @@
+package tun
+
+// This provides a userspace TCP/IP stack in place of a TUN interface, so that
+// the node can reach and offer services without any special privileges
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// NetstackName is the interface name that selects the userspace TCP/IP
+// stack instead of a TUN interface.
+const NetstackName = "netstack"
+
+var ErrNotNetstack = errors.New("the userspace network stack is not enabled")
+
+// Configures the userspace network stack with the node's address and MTU.
+func (tun *TunAdapter) setupNetstack(mtu uint64) error {
+	tun.mtu = getSupportedMTU(mtu)
+	iface, tnet, err := netstack.CreateNetTUN([]netip.Addr{netip.AddrFrom16(tun.addr)}, nil, int(tun.mtu))
+	if err != nil {
+		return fmt.Errorf("failed to create network stack: %w", err)
+	}
+	tun.iface = iface
+	tun.net = tnet
+	tun.log.Infof("Using userspace network stack instead of a TUN interface")
+	tun.log.Infof("Network stack IPv6: %s", net.IP(tun.addr[:]).String())
+	tun.log.Infof("Network stack MTU: %d", tun.mtu)
+	return nil
+}
+
+// IsNetstack returns true if the adapter is using the userspace network
+// stack, in which case DialContext, Listen and ListenPacket can be used.
+func (tun *TunAdapter) IsNetstack() bool {
+	return tun.net != nil
+}
+
+// DialContext connects to the address on the named network from the node's
+// address. Only "tcp", "tcp6", "udp" and "udp6" with IP addresses are
+// supported, as there is no name resolution within the network.
+func (tun *TunAdapter) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if tun.net == nil {
+		return nil, ErrNotNetstack
+	}
+	switch network {
+	case "tcp", "tcp6", "udp", "udp6":
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+	return tun.net.DialContext(ctx, network, address)
+}
+
+// Listen listens for TCP connections on the node's address. The address may
+// leave out the host, e.g. ":80", to listen on the node's address.
+func (tun *TunAdapter) Listen(network, address string) (net.Listener, error) {
+	if tun.net == nil {
+		return nil, ErrNotNetstack
+	}
+	switch network {
+	case "tcp", "tcp6":
+	default:
+		return nil, &net.OpError{Op: "listen", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+	addr, err := tun.localAddrPort(address)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: err}
+	}
+	return tun.net.ListenTCPAddrPort(addr)
+}
+
+// ListenPacket is like Listen, but for UDP.
+func (tun *TunAdapter) ListenPacket(network, address string) (net.PacketConn, error) {
+	if tun.net == nil {
+		return nil, ErrNotNetstack
+	}
+	switch network {
+	case "udp", "udp6":
+	default:
+		return nil, &net.OpError{Op: "listen", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+	addr, err := tun.localAddrPort(address)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: err}
+	}
+	return tun.net.ListenUDPAddrPort(addr)
+}
+
+// localAddrPort parses a local address, filling in the node's address if
+// the host is left out.
+func (tun *TunAdapter) localAddrPort(address string) (netip.AddrPort, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid port %q", port)
+	}
+	addr := netip.AddrFrom16(tun.addr)
+	if host != "" {
+		if addr, err = netip.ParseAddr(host); err != nil {
+			return netip.AddrPort{}, err
+		}
+	}
+	return netip.AddrPortFrom(addr, uint16(p)), nil
+}
@@ -0,0 +1,131 @@
+package tun
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+
+	"github.com/ruvcoindev/ruvchain/src/address"
+)
+
+// pipeEnd stands in for the core of one of two nodes, delivering every
+// packet that is written to it to the other node.
+type pipeEnd struct {
+	addr address.Address
+	in   chan []byte
+	out  chan []byte
+	done chan struct{}
+}
+
+func newPipe(t *testing.T) (*pipeEnd, *pipeEnd) {
+	ab, ba := make(chan []byte, 1024), make(chan []byte, 1024)
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	return &pipeEnd{addr: testAddress(t), in: ba, out: ab, done: done},
+		&pipeEnd{addr: testAddress(t), in: ab, out: ba, done: done}
+}
+
+func testAddress(t *testing.T) address.Address {
+	pk, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *address.AddrForKey(pk)
+}
+
+func (p *pipeEnd) Write(b []byte) (int, error) {
+	select {
+	case p.out <- append([]byte(nil), b...):
+		return len(b), nil
+	case <-p.done:
+		return 0, errClosed
+	}
+}
+
+func (p *pipeEnd) Read(b []byte) (int, error) {
+	select {
+	case bs := <-p.in:
+		return copy(b, bs), nil
+	case <-p.done:
+		return 0, errClosed
+	}
+}
+
+func (p *pipeEnd) Close() error                 { return nil }
+func (p *pipeEnd) Address() address.Address     { return p.addr }
+func (p *pipeEnd) Subnet() address.Subnet       { return address.Subnet{} }
+func (p *pipeEnd) MaxMTU() uint64               { return 65535 }
+func (p *pipeEnd) SetMTU(uint64)                {}
+func (p *pipeEnd) RemoteRoutes() []netip.Prefix { return nil }
+
+// One node can connect to a service that the other offers on its address
+// through the userspace network stack.
+func TestNetstackDial(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	a, b := newPipe(t)
+	// An MTU below the minimum must be raised before the stack is created,
+	// otherwise IPv6 can't be used at all.
+	tunA, err := New(a, logger, InterfaceName(NetstackName), InterfaceMTU(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunA.Stop() // nolint:errcheck
+	if tunA.MTU() != 1280 {
+		t.Fatalf("expected MTU to be raised to 1280, got %d", tunA.MTU())
+	}
+	tunB, err := New(b, logger, InterfaceName(NetstackName), InterfaceMTU(1280))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunB.Stop() // nolint:errcheck
+
+	l, err := tunA.Listen("tcp", ":80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	target := net.JoinHostPort(net.IP(a.addr[:]).String(), "80")
+	conn, err := tunB.DialContext(ctx, "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := conn.LocalAddr().(*net.TCPAddr).IP; !got.Equal(net.IP(b.addr[:])) {
+		t.Fatalf("expected to dial from %s, got %s", net.IP(b.addr[:]), got)
+	}
+	// Send more than one MTU's worth, so that segmentation is exercised.
+	msg := make([]byte, 4096)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	for i := range got {
+		if got[i] != msg[i] {
+			t.Fatalf("echoed data differs at byte %d", i)
+		}
+	}
+}
@@ -6,6 +6,8 @@ func (m *TunAdapter) _applyOption(opt SetupOption) {
 		m.config.name = v
 	case InterfaceMTU:
 		m.config.mtu = v
+	case InterfaceQueues:
+		m.config.queues = v
 	case FileDescriptor:
 		m.config.fd = int32(v)
 	}
@@ -17,8 +19,10 @@ type SetupOption interface {
 
 type InterfaceName string
 type InterfaceMTU uint64
+type InterfaceQueues uint64 // Linux only
 type FileDescriptor int32
 
-func (a InterfaceName) isSetupOption()  {}
-func (a InterfaceMTU) isSetupOption()   {}
-func (a FileDescriptor) isSetupOption() {}
+func (a InterfaceName) isSetupOption()   {}
+func (a InterfaceMTU) isSetupOption()    {}
+func (a InterfaceQueues) isSetupOption() {}
+func (a FileDescriptor) isSetupOption()  {}
@@ -0,0 +1,17 @@
+//go:build !linux && !android
+// +build !linux,!android
+
+package tun
+
+import (
+	"net/netip"
+)
+
+// Routes for the prefixes that are sent through the TUN adapter, e.g. subnets
+// outside of the network, aren't added automatically on this platform.
+func (tun *TunAdapter) setupRoutes(prefixes []netip.Prefix) error {
+	for _, prefix := range prefixes {
+		tun.log.Warnf("Route to %s must be added to the TUN interface by hand", prefix)
+	}
+	return nil
+}
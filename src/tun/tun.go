@@ -10,10 +10,13 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 
 	"github.com/Arceliar/phony"
 	wgtun "golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/tun/netstack"
 
 	"github.com/ruvcoindev/ruvchain/src/address"
 	"github.com/ruvcoindev/ruvchain/src/config"
@@ -28,6 +31,7 @@ type ReadWriteCloser interface {
 	Subnet() address.Subnet
 	MaxMTU() uint64
 	SetMTU(uint64)
+	RemoteRoutes() []netip.Prefix
 }
 
 // TunAdapter represents a running TUN interface and extends the
@@ -41,15 +45,34 @@ type TunAdapter struct {
 	subnet      address.Subnet
 	mtu         uint64
 	iface       wgtun.Device
+	queues      []wgtun.Device // Including iface, each with its own reader and writer
+	net         *netstack.Net
 	phony.Inbox // Currently only used for _handlePacket from the reader, TODO: all the stuff that currently needs a mutex below
 	isOpen      bool
 	isEnabled   bool // Used by the writer to drop sessionTraffic if not enabled
 	config      struct {
-		fd   int32
-		name InterfaceName
-		mtu  InterfaceMTU
+		fd     int32
+		name   InterfaceName
+		mtu    InterfaceMTU
+		queues InterfaceQueues
 	}
-	ch chan []byte
+	chs   []chan []byte // For the writer of each queue
+	stats struct {
+		readPackets    atomic.Uint64
+		readBytes      atomic.Uint64
+		writtenPackets atomic.Uint64
+		writtenBytes   atomic.Uint64
+	}
+}
+
+// Stats contains packet counters for the TUN interface. Read counters are
+// for packets read from the interface and sent into the network, written
+// counters for packets received from the network and written to it.
+type Stats struct {
+	ReadPackets    uint64
+	ReadBytes      uint64
+	WrittenPackets uint64
+	WrittenBytes   uint64
 }
 
 // Gets the maximum supported MTU for the platform based on the defaults in
@@ -125,8 +148,9 @@ func (tun *TunAdapter) _start() error {
 	if tun.config.name == "none" || tun.config.name == "dummy" {
 		tun.log.Debugln("Not starting TUN as ifname is none or dummy")
 		tun.isEnabled = false
+		tun.chs = []chan []byte{make(chan []byte, 1)}
 		go tun.queue()
-		go tun.write()
+		go tun.write(nil, tun.chs[0])
 		return nil
 	}
 	mtu := uint64(tun.config.mtu)
@@ -134,10 +158,15 @@ func (tun *TunAdapter) _start() error {
 		mtu = tun.rwc.MaxMTU()
 	}
 	var err error
-	if tun.config.fd > 0 {
+	switch {
+	case tun.config.name == NetstackName:
+		err = tun.setupNetstack(mtu)
+	case tun.config.fd > 0:
 		err = tun.setupFD(tun.config.fd, addr, mtu)
-	} else {
-		err = tun.setup(string(tun.config.name), addr, mtu)
+	default:
+		if err = tun.setup(string(tun.config.name), addr, mtu); err == nil {
+			err = tun.setupRoutes(tun.rwc.RemoteRoutes())
+		}
 	}
 	if err != nil {
 		return err
@@ -146,15 +175,42 @@ func (tun *TunAdapter) _start() error {
 		tun.log.Warnf("Warning: Interface MTU %d automatically adjusted to %d (supported range is 1280-%d)", tun.config.mtu, tun.MTU(), MaximumMTU())
 	}
 	tun.rwc.SetMTU(tun.MTU())
+	if tun.queues == nil {
+		tun.queues = []wgtun.Device{tun.iface}
+	}
+	if int(tun.config.queues) > len(tun.queues) {
+		tun.log.Warnf("Warning: Only %d TUN queue(s) supported on this platform", len(tun.queues))
+	}
 	tun.isOpen = true
 	tun.isEnabled = true
-	tun.ch = make(chan []byte, tun.iface.BatchSize())
-	go tun.queue()
-	go tun.read()
-	go tun.write()
+	tun.startQueues()
 	return nil
 }
 
+// startQueues starts a reader and a writer for each queue of the interface.
+func (tun *TunAdapter) startQueues() {
+	if lw, ok := tun.rwc.(localWriter); ok {
+		lw.SetLocalWriter(tun.writeLocal)
+	}
+	for _, iface := range tun.queues {
+		ch := make(chan []byte, iface.BatchSize())
+		tun.chs = append(tun.chs, ch)
+		go tun.read(iface)
+		go tun.write(iface, ch)
+	}
+	go tun.queue()
+}
+
+// Stats returns the packet counters for the TUN interface.
+func (tun *TunAdapter) Stats() Stats {
+	return Stats{
+		ReadPackets:    tun.stats.readPackets.Load(),
+		ReadBytes:      tun.stats.readBytes.Load(),
+		WrittenPackets: tun.stats.writtenPackets.Load(),
+		WrittenBytes:   tun.stats.writtenBytes.Load(),
+	}
+}
+
 // IsStarted returns true if the module has been started.
 func (tun *TunAdapter) IsStarted() bool {
 	var isOpen bool
@@ -181,6 +237,11 @@ func (tun *TunAdapter) _stop() error {
 		// Just in case we failed to start up the iface for some reason, this can apparently happen on Windows
 		tun.iface.Close()
 	}
+	for _, iface := range tun.queues {
+		if iface != tun.iface {
+			iface.Close()
+		}
+	}
 	return nil
 }
 
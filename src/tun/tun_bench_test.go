@@ -0,0 +1,301 @@
+package tun
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+	wgtun "golang.zx2c4.com/wireguard/tun"
+
+	"github.com/ruvcoindev/ruvchain/src/config"
+	"github.com/ruvcoindev/ruvchain/src/core"
+	"github.com/ruvcoindev/ruvchain/src/inmem"
+	"github.com/ruvcoindev/ruvchain/src/ipv6rwc"
+)
+
+var errClosed = errors.New("closed")
+
+// How many packets are in flight at most, across all queues, how long a
+// packet may take to come back before it is taken to be lost, and how long
+// the benchmark waits for the rest when nothing comes back. The window is
+// small because ironwood drops packets that have queued for more than 25ms,
+// and if those include the packets that set up a session, traffic stalls
+// until the session times out a minute later.
+const (
+	benchWindow      = 4
+	benchLossTimeout = 100 * time.Millisecond
+	benchIdleTimeout = time.Second
+)
+
+// The UDP destination ports of the packets that set up the session and of
+// the last of them, which aren't counted when they come back.
+const (
+	benchWarmupPort = 0xfffe
+	benchLastPort   = 0xffff
+)
+
+// benchCores starts two cores linked by the in-memory transport and echoes
+// every packet that reaches the second one back to the first. It returns a
+// ReadWriteCloser for each of them.
+func benchCores(b *testing.B) (*ipv6rwc.ReadWriteCloser, *ipv6rwc.ReadWriteCloser) {
+	network := inmem.NewNetwork(1)
+	logger := log.New(io.Discard, "", 0)
+	var cores []*core.Core
+	var rwcs []*ipv6rwc.ReadWriteCloser
+	for _, name := range []string{"a", "b"} {
+		cfg := config.GenerateConfig()
+		c, err := core.New(cfg.Certificate, logger,
+			core.CustomTransport{Scheme: inmem.Scheme, Transport: network},
+			core.ListenAddress(inmem.Scheme+"://"+name),
+		)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(c.Stop)
+		cores = append(cores, c)
+		rwc := ipv6rwc.NewReadWriteCloser(c)
+		rwc.SetMTU(rwc.MaxMTU())
+		rwcs = append(rwcs, rwc)
+	}
+	u, err := url.Parse(inmem.Scheme + "://a?from=b")
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err = cores[1].AddConfiguredPeer(u, ""); err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, err := rwcs[1].Read(buf)
+			if err != nil {
+				return
+			}
+			var addr [16]byte
+			copy(addr[:], buf[8:24])
+			copy(buf[8:24], buf[24:40])
+			copy(buf[24:40], addr[:])
+			_, _ = rwcs[1].Write(buf[:n])
+		}
+	}()
+	return rwcs[0], rwcs[1]
+}
+
+// benchPacket returns a UDP packet of the given size between two nodes.
+func benchPacket(from, to *ipv6rwc.ReadWriteCloser, size int, port uint16) []byte {
+	src, dst := from.Address(), to.Address()
+	packet := make([]byte, size)
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:], uint16(size-40))
+	packet[6], packet[7] = 17, 64 // UDP
+	copy(packet[8:24], src[:])
+	copy(packet[24:40], dst[:])
+	binary.BigEndian.PutUint16(packet[42:], port)
+	return packet
+}
+
+// warmup sends packets until one comes back and then waits for the blooms
+// of the nodes to spread, which takes a second per hop in ironwood, as
+// lookups are dropped until then and the traffic that triggered them stalls.
+// Whatever comes back is read all along, as a node stops handling protocol
+// traffic while nobody reads it.
+func warmup(b *testing.B, from, to *ipv6rwc.ReadWriteCloser) {
+	back, last := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(last)
+		buf := make([]byte, 65535)
+		for once := false; ; {
+			n, err := from.Read(buf)
+			if err != nil || n > 43 && binary.BigEndian.Uint16(buf[42:]) == benchLastPort {
+				return
+			}
+			if !once {
+				close(back)
+				once = true
+			}
+		}
+	}()
+	send := func(port uint16, done chan struct{}) {
+		packet := benchPacket(from, to, 1280, port)
+		for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); {
+			if _, err := from.Write(packet); err != nil {
+				b.Fatal(err)
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		b.Fatal("no packets came back")
+	}
+	send(benchWarmupPort, back)
+	time.Sleep(2 * time.Second)
+	send(benchLastPort, last)
+}
+
+// benchState is shared by the queues of a benchmark.
+type benchState struct {
+	total    int64
+	sent     atomic.Int64
+	received atomic.Int64
+	last     atomic.Int64  // When the last packet came back, in Unix nanoseconds
+	window   chan struct{} // A slot for each packet in flight
+	done     chan struct{} // Closed once every packet has come back
+	once     sync.Once
+}
+
+// device is one queue of a TUN interface in memory. Reads return packets of
+// a few UDP flows until all have been sent, keeping a window of them in
+// flight, and writes count the packets that come back.
+type device struct {
+	packet []byte
+	state  *benchState
+	closed chan struct{}
+	flow   uint16
+}
+
+func (d *device) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n := 0
+	for n < len(bufs) && d.state.sent.Load() < d.state.total {
+		if n == 0 {
+			select {
+			case d.state.window <- struct{}{}:
+			case <-time.After(benchLossTimeout):
+				// A packet was lost, so its slot is reused.
+			case <-d.closed:
+				return 0, os.ErrClosed
+			}
+		} else {
+			select {
+			case d.state.window <- struct{}{}:
+			default:
+				return n, nil
+			}
+		}
+		if d.state.sent.Add(1) > d.state.total {
+			break
+		}
+		copy(bufs[n][offset:], d.packet)
+		bufs[n][offset+40] = byte(d.flow) // Source port
+		d.flow = (d.flow + 1) % 16
+		sizes[n] = len(d.packet)
+		n++
+	}
+	if n > 0 {
+		return n, nil
+	}
+	<-d.closed
+	return 0, os.ErrClosed
+}
+
+func (d *device) Write(bufs [][]byte, offset int) (int, error) {
+	var received int64
+	for _, buf := range bufs {
+		if binary.BigEndian.Uint16(buf[offset+42:]) >= benchWarmupPort {
+			continue
+		}
+		select {
+		case <-d.state.window:
+		default:
+		}
+		received++
+	}
+	d.state.last.Store(time.Now().UnixNano())
+	if d.state.received.Add(received) >= d.state.total {
+		d.state.once.Do(func() { close(d.state.done) })
+	}
+	return len(bufs), nil
+}
+
+func (d *device) Close() error {
+	close(d.closed)
+	return nil
+}
+
+func (d *device) File() *os.File             { return nil }
+func (d *device) MTU() (int, error)          { return 65535, nil }
+func (d *device) Name() (string, error)      { return "bench", nil }
+func (d *device) Events() <-chan wgtun.Event { return nil }
+func (d *device) BatchSize() int             { return 128 }
+
+// benchmarkTunAdapter sends b.N packets of the given size from the TUN
+// interface of one node to another node, which sends them back, and reports
+// the rate in packets and bytes per second along with the share of packets
+// that were lost. The TUN interface itself is in memory, so this measures
+// the adapter and the cores, not the TUN driver of the system.
+func benchmarkTunAdapter(b *testing.B, queues, size int) {
+	rwc, remote := benchCores(b)
+	warmup(b, rwc, remote)
+
+	state := &benchState{
+		total:  int64(b.N),
+		window: make(chan struct{}, benchWindow),
+		done:   make(chan struct{}),
+	}
+	tun := &TunAdapter{
+		rwc: rwc,
+		log: log.New(io.Discard, "", 0),
+	}
+	packet := benchPacket(rwc, remote, size, 1)
+	for i := 0; i < queues; i++ {
+		tun.queues = append(tun.queues, &device{
+			packet: packet,
+			state:  state,
+			closed: make(chan struct{}),
+		})
+	}
+	tun.iface = tun.queues[0]
+	tun.isOpen, tun.isEnabled = true, true
+	defer func() {
+		for _, q := range tun.queues {
+			_ = q.Close()
+		}
+	}()
+
+	b.ResetTimer()
+	start := time.Now()
+	state.last.Store(start.UnixNano())
+	tun.startQueues()
+	for idle := time.NewTicker(benchIdleTimeout / 10); ; {
+		select {
+		case <-state.done:
+		case <-idle.C:
+			if time.Since(time.Unix(0, state.last.Load())) < benchIdleTimeout {
+				continue
+			}
+		}
+		idle.Stop()
+		break
+	}
+	b.StopTimer()
+	elapsed := time.Unix(0, state.last.Load()).Sub(start).Seconds()
+	received := state.received.Load()
+	b.ReportMetric(float64(received)/elapsed, "packets/s")
+	b.ReportMetric(float64(received)*float64(size)/elapsed, "bytes/s")
+	b.ReportMetric(100*float64(state.total-received)/float64(state.total), "%lost")
+}
+
+// Queues only parallelise the reads and writes of the TUN interface. All of
+// them send to and receive from the same core through one reader, and the
+// cores, which encrypt every packet, are much slower than the adapter, so
+// with a TUN interface in memory the number of queues makes no difference.
+// The gain is on Linux, where each queue has its own file descriptor and the
+// system calls on them run in parallel.
+func BenchmarkTunAdapter(b *testing.B) {
+	for _, queues := range []int{1, 4} {
+		for _, size := range []int{1280, 9000} {
+			b.Run(fmt.Sprintf("queues=%d/size=%d", queues, size), func(b *testing.B) {
+				benchmarkTunAdapter(b, queues, size)
+			})
+		}
+	}
+}
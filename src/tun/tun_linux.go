@@ -7,8 +7,12 @@ package tun
 
 import (
 	"fmt"
+	"net"
+	"net/netip"
+	"os"
 
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 	wgtun "golang.zx2c4.com/wireguard/tun"
 )
 
@@ -17,7 +21,13 @@ func (tun *TunAdapter) setup(ifname string, addr string, mtu uint64) error {
 	if ifname == "auto" {
 		ifname = "\000"
 	}
-	iface, err := wgtun.CreateTUN(ifname, int(mtu))
+	var iface wgtun.Device
+	var err error
+	if tun.config.queues > 1 {
+		iface, err = tun.setupQueues(ifname, int(mtu))
+	} else {
+		iface, err = wgtun.CreateTUN(ifname, int(mtu))
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create TUN: %w", err)
 	}
@@ -27,10 +37,71 @@ func (tun *TunAdapter) setup(ifname string, addr string, mtu uint64) error {
 	} else {
 		tun.mtu = 0
 	}
-	if addr != "" {
-		return tun.setupAddress(addr)
+	return tun.setupAddress(addr)
+}
+
+// Creates a multiqueue TUN adapter, so that packets can be read and written
+// on each queue in parallel. The kernel spreads the packets that it sends
+// over the queues by flow. Only the first queue is monitored for events. As
+// with wgtun.CreateTUN, offloads are enabled through the virtio-net header.
+func (tun *TunAdapter) setupQueues(ifname string, mtu int) (wgtun.Device, error) {
+	fd, err := openQueue(ifname)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	if err = unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	iface, err := wgtun.CreateTUNFromFile(os.NewFile(uintptr(fd), cloneDevicePath), mtu)
+	if err != nil {
+		return nil, err
+	}
+	name, err := iface.Name()
+	if err != nil {
+		iface.Close()
+		return nil, err
+	}
+	tun.queues = []wgtun.Device{iface}
+	for i := 1; i < int(tun.config.queues); i++ {
+		fd, err := openQueue(name)
+		if err == nil {
+			var queue wgtun.Device
+			if queue, _, err = wgtun.CreateUnmonitoredTUNFromFD(fd); err == nil {
+				tun.queues = append(tun.queues, queue)
+				continue
+			}
+			unix.Close(fd)
+		}
+		for _, queue := range tun.queues {
+			queue.Close()
+		}
+		tun.queues = nil
+		return nil, fmt.Errorf("failed to open queue %d: %w", i, err)
+	}
+	return iface, nil
+}
+
+const cloneDevicePath = "/dev/net/tun"
+
+// Opens a queue of the multiqueue TUN adapter with the given name, which is
+// created by the first queue.
+func openQueue(name string) (int, error) {
+	fd, err := unix.Open(cloneDevicePath, unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI | unix.IFF_VNET_HDR | unix.IFF_MULTI_QUEUE)
+	if err = unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
 }
 
 // Configures the "utun" adapter from an existing file descriptor.
@@ -41,18 +112,21 @@ func (tun *TunAdapter) setupFD(fd int32, addr string, mtu uint64) error {
 // Configures the TUN adapter with the correct IPv6 address and MTU. Netlink
 // is used to do this, so there is not a hard requirement on "ip" or "ifconfig"
 // to exist on the system, but this will fail if Netlink is not present in the
-// kernel (it nearly always is).
+// kernel (it nearly always is). Without an address, the adapter is only
+// brought up.
 func (tun *TunAdapter) setupAddress(addr string) error {
-	nladdr, err := netlink.ParseAddr(addr)
-	if err != nil {
-		return fmt.Errorf("couldn't parse address %q: %w", addr, err)
-	}
 	nlintf, err := netlink.LinkByName(tun.Name())
 	if err != nil {
 		return fmt.Errorf("failed to find link by name: %w", err)
 	}
-	if err := netlink.AddrAdd(nlintf, nladdr); err != nil {
-		return fmt.Errorf("failed to add address to link: %w", err)
+	if addr != "" {
+		nladdr, err := netlink.ParseAddr(addr)
+		if err != nil {
+			return fmt.Errorf("couldn't parse address %q: %w", addr, err)
+		}
+		if err := netlink.AddrAdd(nlintf, nladdr); err != nil {
+			return fmt.Errorf("failed to add address to link: %w", err)
+		}
 	}
 	if err := netlink.LinkSetMTU(nlintf, int(tun.mtu)); err != nil {
 		return fmt.Errorf("failed to set link MTU: %w", err)
@@ -62,7 +136,35 @@ func (tun *TunAdapter) setupAddress(addr string) error {
 	}
 	// Friendly output
 	tun.log.Infof("Interface name: %s", tun.Name())
-	tun.log.Infof("Interface IPv6: %s", addr)
+	if addr != "" {
+		tun.log.Infof("Interface IPv6: %s", addr)
+	}
 	tun.log.Infof("Interface MTU: %d", tun.mtu)
 	return nil
 }
+
+// Adds routes to the TUN adapter for the prefixes that are sent through it,
+// e.g. subnets outside of the network.
+func (tun *TunAdapter) setupRoutes(prefixes []netip.Prefix) error {
+	if len(prefixes) == 0 {
+		return nil
+	}
+	nlintf, err := netlink.LinkByName(tun.Name())
+	if err != nil {
+		return fmt.Errorf("failed to find link by name: %w", err)
+	}
+	for _, prefix := range prefixes {
+		route := &netlink.Route{
+			LinkIndex: nlintf.Attrs().Index,
+			Dst: &net.IPNet{
+				IP:   prefix.Addr().AsSlice(),
+				Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+			},
+		}
+		if err := netlink.RouteReplace(route); err != nil {
+			return fmt.Errorf("failed to add route to %s: %w", prefix, err)
+		}
+		tun.log.Infof("Interface route: %s", prefix)
+	}
+	return nil
+}
@@ -4,6 +4,8 @@
 package tun
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"log"
@@ -33,7 +35,15 @@ func (tun *TunAdapter) setup(ifname string, addr string, mtu uint64) error {
 		if guid, err = windows.GUIDFromString("{8f59971a-7872-4aa6-b2eb-061fc4e9d0a7}"); err != nil {
 			return err
 		}
+		extra := addr == "" // Further interfaces have no address of their own
+		if extra {
+			guid = interfaceGUID(ifname)
+		}
 		iface, err = wgtun.CreateTUNWithRequestedGUID(ifname, &guid, int(mtu))
+		if err != nil && extra {
+			// Uninstalling the driver would take down the main adapter too.
+			return err
+		}
 		if err != nil {
 			// Very rare condition, it will purge the old device and create new
 			tun.log.Printf("Error creating TUN: '%s'", err)
@@ -67,6 +77,20 @@ func (tun *TunAdapter) setup(ifname string, addr string, mtu uint64) error {
 	})
 }
 
+// interfaceGUID returns the GUID of a further interface, which is derived
+// from its name so that each has its own and keeps it across restarts.
+func interfaceGUID(ifname string) windows.GUID {
+	h := sha256.Sum256([]byte("ruvchain interface " + ifname))
+	h[6] = h[6]&0x0f | 0x50 // Version 5, name-based
+	h[8] = h[8]&0x3f | 0x80 // RFC 4122 variant
+	return windows.GUID{
+		Data1: binary.BigEndian.Uint32(h[0:4]),
+		Data2: binary.BigEndian.Uint16(h[4:6]),
+		Data3: binary.BigEndian.Uint16(h[6:8]),
+		Data4: [8]byte(h[8:16]),
+	}
+}
+
 // Configures the "utun" adapter from an existing file descriptor.
 func (tun *TunAdapter) setupFD(fd int32, addr string, mtu uint64) error {
 	return fmt.Errorf("setup via FD not supported on this platform")